@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// 交易所收入帳戶
+const ExchangeAccountID = "exchange"
+
+// 交易對的基礎幣與報價幣
+type Market struct {
+	Base  string
+	Quote string
+}
+
+// 用戶資產餘額
+type Accounts struct {
+	mutex    sync.Mutex
+	balances map[string]map[string]orderbook.Decimal // 用戶ID -> 資產 -> 餘額
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{balances: make(map[string]map[string]orderbook.Decimal)}
+}
+
+// 調整餘額（呼叫者需持有鎖）
+func (a *Accounts) adjust(userID, asset string, amount orderbook.Decimal) {
+	if a.balances[userID] == nil {
+		a.balances[userID] = make(map[string]orderbook.Decimal)
+	}
+	a.balances[userID][asset] += amount
+}
+
+// Deposit 入金
+func (a *Accounts) Deposit(userID, asset string, amount orderbook.Decimal) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.adjust(userID, asset, amount)
+}
+
+// Balance 查詢餘額
+func (a *Accounts) Balance(userID, asset string) orderbook.Decimal {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.balances[userID][asset]
+}
+
+// Settle 結算一筆成交：買方收基礎幣付報價幣，賣方相反；
+// 吃單方支付 taker 手續費，掛單方支付 maker 手續費（負數為返佣），淨手續費計入交易所帳戶。
+// 全程以 Decimal 計算，每個資產在所有帳戶（含交易所）的變動加總精確為 0
+func (a *Accounts) Settle(market Market, fees orderbook.FeeSchedule, t *orderbook.Trade) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	quantity, notional := t.Quantity, t.Price.Mul(t.Quantity)
+	a.adjust(t.BuyUserID, market.Base, quantity)
+	a.adjust(t.BuyUserID, market.Quote, -notional)
+	a.adjust(t.SellUserID, market.Base, -quantity)
+	a.adjust(t.SellUserID, market.Quote, notional)
+
+	taker, maker := t.BuyUserID, t.SellUserID
+	if t.AggressorSide == orderbook.Ask {
+		taker, maker = t.SellUserID, t.BuyUserID
+	}
+	takerFee := fees.TakerFee(t.Price, t.Quantity)
+	makerFee := fees.MakerFee(t.Price, t.Quantity)
+	a.adjust(taker, market.Quote, -takerFee)
+	a.adjust(maker, market.Quote, -makerFee)
+	a.adjust(ExchangeAccountID, market.Quote, takerFee+makerFee)
+}
+
+// EnableSettlement 讓所有訂單簿在成交時（持有訂單簿鎖）同步結算到帳戶餘額
+func (ex *Exchange) EnableSettlement() {
+	for symbol, ob := range ex.OrderBooks {
+		market := ex.Markets[symbol]
+		ob.OnTrade = func(t *orderbook.Trade) {
+			ex.Accounts.Settle(market, ob.Fees, t)
+		}
+	}
+}
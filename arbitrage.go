@@ -0,0 +1,53 @@
+package main
+
+import "github.com/clary-work01/crypto_exchange/orderbook"
+
+// Arbitrage 兩個相關市場之間的跨簿交叉：在 BuySymbol 以最佳賣價買入，同時在 SellSymbol 以最佳買價賣出
+type Arbitrage struct {
+	BuySymbol  orderbook.Symbol
+	SellSymbol orderbook.Symbol
+	BuyPrice   orderbook.Decimal // BuySymbol 的最佳賣價
+	SellPrice  orderbook.Decimal // SellSymbol 的最佳買價
+	Spread     orderbook.Decimal // SellPrice - BuyPrice
+	SpreadBps  float64           // 相對 BuyPrice 的基點
+}
+
+// DetectArbitrage 比較兩個交易對的最佳買賣價，任一方的最佳買價高於另一方的最佳賣價時返回該機會；
+// 兩個方向都交叉時返回價差較大者。只讀取兩本訂單簿的最佳價格，不會下單
+func (ex *Exchange) DetectArbitrage(symbolA, symbolB orderbook.Symbol) (Arbitrage, bool, error) {
+	obA, ok := ex.OrderBooks[symbolA]
+	if !ok {
+		return Arbitrage{}, false, orderbook.ErrUnknownSymbol
+	}
+	obB, ok := ex.OrderBooks[symbolB]
+	if !ok {
+		return Arbitrage{}, false, orderbook.ErrUnknownSymbol
+	}
+	a, b := obA.Metrics(), obB.Metrics()
+
+	best, found := Arbitrage{}, false
+	for _, pair := range []struct {
+		buy, sell               orderbook.Symbol
+		buyMetrics, sellMetrics orderbook.BookMetrics
+	}{
+		{symbolA, symbolB, a, b},
+		{symbolB, symbolA, b, a},
+	} {
+		ask, bid := pair.buyMetrics.BestAsk, pair.sellMetrics.BestBid
+		if ask == 0 || bid == 0 || bid <= ask {
+			continue
+		}
+		if spread := bid - ask; !found || spread > best.Spread {
+			best = Arbitrage{
+				BuySymbol:  pair.buy,
+				SellSymbol: pair.sell,
+				BuyPrice:   ask,
+				SellPrice:  bid,
+				Spread:     spread,
+				SpreadBps:  spread.Float64() / ask.Float64() * 10000,
+			}
+			found = true
+		}
+	}
+	return best, found, nil
+}
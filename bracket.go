@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// 括號單狀態
+type bracketState int
+
+const (
+	bracketPending bracketState = iota // 等待進場單完成
+	bracketActive                      // 止盈單已掛出、止損單已布防
+	bracketDone                        // 已結束
+)
+
+var errInvalidBracket = errors.New("take-profit and stop-loss must be on the entry's symbol and opposite side")
+
+// 保留已結束括號單最終狀態的筆數上限，超過時捨棄最早結束的
+const finishedBracketLimit = 1024
+
+// 括號單：進場單完成後才掛出止盈限價單並布防止損，兩者為 OCO（一方成交即取消另一方）
+type bracket struct {
+	state      bracketState
+	entry      *orderbook.Order
+	takeProfit *orderbook.Order // 止盈限價單，Price 為限價
+	stopLoss   *orderbook.Order // 止損單，Price 為觸發價，觸發後以市價單送出
+}
+
+// BracketStatus 括號單目前的狀態
+type BracketStatus struct {
+	Entry      orderbook.Order
+	TakeProfit orderbook.Order
+	StopLoss   orderbook.Order
+	Done       bool
+}
+
+// PlaceBracket 下括號單：先送出進場單，待其完全成交（或部分成交後剩餘被取消）後，
+// 按已成交比例調整數量，掛出止盈限價單並以 stopLoss.Price 為觸發價布防止損市價單。
+// 返回括號單ID（即進場單ID）及進場單的即時成交
+func (ex *Exchange) PlaceBracket(entry, takeProfit, stopLoss orderbook.Order) (string, []*orderbook.Trade, error) {
+	opposite := orderbook.Ask
+	if entry.Side == orderbook.Ask {
+		opposite = orderbook.Bid
+	}
+	for _, leg := range []orderbook.Order{takeProfit, stopLoss} {
+		if leg.Symbol != entry.Symbol || leg.Side != opposite {
+			return "", nil, &orderbook.OrderError{OrderID: entry.ID, Err: errInvalidBracket}
+		}
+	}
+
+	b := &bracket{entry: &entry, takeProfit: &takeProfit, stopLoss: &stopLoss}
+	b.takeProfit.Type = orderbook.Limit
+	b.stopLoss.Type = orderbook.Market
+
+	trades, err := ex.placeOrder(b.entry)
+	if err != nil {
+		return "", trades, err
+	}
+
+	ex.bracketMu.Lock()
+	ex.brackets[entry.ID] = b
+	ex.bracketMu.Unlock()
+
+	ex.processBrackets()
+	return entry.ID, trades, nil
+}
+
+// Bracket 查詢括號單狀態；已結束的括號單保留最近 finishedBracketLimit 筆的最終狀態
+func (ex *Exchange) Bracket(id string) (BracketStatus, bool) {
+	ex.bracketMu.Lock()
+	defer ex.bracketMu.Unlock()
+
+	if status, ok := ex.finishedBrackets[id]; ok {
+		return status, true
+	}
+	b, ok := ex.brackets[id]
+	if !ok {
+		return BracketStatus{}, false
+	}
+	return ex.bracketStatus(b), true
+}
+
+// 括號單目前的狀態；已送出的腿由訂單簿修改，因此在訂單簿的鎖內讀取（呼叫者需持有 bracketMu）
+func (ex *Exchange) bracketStatus(b *bracket) BracketStatus {
+	return BracketStatus{
+		Entry:      ex.orderSnapshot(b.entry),
+		TakeProfit: ex.orderSnapshot(b.takeProfit),
+		StopLoss:   ex.orderSnapshot(b.stopLoss),
+		Done:       b.state == bracketDone,
+	}
+}
+
+// 在訂單所屬交易對訂單簿的鎖內複製訂單；尚未送出的訂單不在任何訂單簿中，複製同樣安全
+func (ex *Exchange) orderSnapshot(o *orderbook.Order) orderbook.Order {
+	ob, err := ex.routeOrderBook(o.Symbol)
+	if err != nil {
+		ex.mutex.Lock()
+		ob = ex.OrderBooks[o.Symbol]
+		ex.mutex.Unlock()
+	}
+	if ob == nil {
+		return *o
+	}
+	return ob.OrderSnapshot(o)
+}
+
+// 推進所有進行中的括號單，每次下單後呼叫；直到沒有狀態變化為止，
+// 因為括號單自己送出的訂單也可能觸發其他括號單。結束的括號單移出進行中清單，只保留最終狀態
+func (ex *Exchange) processBrackets() {
+	ex.bracketMu.Lock()
+	defer ex.bracketMu.Unlock()
+
+	for changed := true; changed; {
+		changed = false
+		for id, b := range ex.brackets {
+			if ex.advanceBracket(b) {
+				changed = true
+			}
+			if b.state == bracketDone {
+				ex.finishBracket(id, b)
+			}
+		}
+	}
+}
+
+// 記錄已結束括號單的最終狀態並移出進行中清單，超過上限時捨棄最早結束的（呼叫者需持有 bracketMu）
+func (ex *Exchange) finishBracket(id string, b *bracket) {
+	delete(ex.brackets, id)
+	ex.finishedBrackets[id] = ex.bracketStatus(b)
+	ex.finishedOrder = append(ex.finishedOrder, id)
+	if len(ex.finishedOrder) > finishedBracketLimit {
+		delete(ex.finishedBrackets, ex.finishedOrder[0])
+		ex.finishedOrder = ex.finishedOrder[1:]
+	}
+}
+
+// 推進單一括號單，返回是否有狀態變化（呼叫者需持有 bracketMu）
+func (ex *Exchange) advanceBracket(b *bracket) bool {
+	switch b.state {
+	case bracketPending:
+		entry := ex.orderSnapshot(b.entry)
+		if entry.Status == orderbook.Cancelled && entry.FilledQuantity == 0 {
+			b.cancelLegs()
+			b.state = bracketDone
+			return true
+		}
+		if entry.Status != orderbook.Filled && entry.Status != orderbook.Cancelled {
+			return false
+		}
+		// 按進場單實際成交比例調整止盈、止損數量
+		b.takeProfit.Quantity = b.takeProfit.Quantity.Mul(entry.FilledQuantity).Div(entry.Quantity)
+		b.stopLoss.Quantity = b.stopLoss.Quantity.Mul(entry.FilledQuantity).Div(entry.Quantity)
+		b.state = bracketActive
+		if _, err := ex.placeOrder(b.takeProfit); err != nil {
+			b.stopLoss.Status = orderbook.Cancelled
+			b.state = bracketDone
+		}
+		return true
+
+	case bracketActive:
+		if ex.orderSnapshot(b.takeProfit).FilledQuantity > 0 {
+			// 止盈成交：取消止損，止盈剩餘部分繼續掛單
+			b.stopLoss.Status = orderbook.Cancelled
+			b.state = bracketDone
+			return true
+		}
+		if ex.stopTriggered(b) {
+			// 止損觸發：取消止盈並以市價送出止損單
+			if ob, err := ex.routeOrderBook(b.takeProfit.Symbol); err == nil {
+				ob.CancelOrder(b.takeProfit.ID)
+			}
+			ex.placeOrder(b.stopLoss)
+			b.state = bracketDone
+			return true
+		}
+	}
+	return false
+}
+
+// 最新成交價是否觸及止損觸發價：止損為賣單時跌破、為買單時突破
+func (ex *Exchange) stopTriggered(b *bracket) bool {
+	ob, err := ex.routeOrderBook(b.stopLoss.Symbol)
+	if err != nil {
+		return false
+	}
+	last, ok := ob.LastTradePrice()
+	if !ok {
+		return false
+	}
+	if b.stopLoss.Side == orderbook.Ask {
+		return last <= b.stopLoss.Price
+	}
+	return last >= b.stopLoss.Price
+}
+
+// 進場單未成交即結束時，兩條腿都不會送出
+func (b *bracket) cancelLegs() {
+	b.takeProfit.Status = orderbook.Cancelled
+	b.stopLoss.Status = orderbook.Cancelled
+}
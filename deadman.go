@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// 斷線自動撤單（dead man's switch）設定
+type deadManSwitch struct {
+	timeout       time.Duration
+	lastHeartbeat time.Time
+}
+
+// SetDeadManSwitch 為用戶啟用斷線自動撤單，timeout 內沒有心跳則撤銷該用戶在所有訂單簿的訂單
+// timeout <= 0 表示關閉
+func (ex *Exchange) SetDeadManSwitch(userID string, timeout time.Duration) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	if timeout <= 0 {
+		delete(ex.deadManSwitches, userID)
+		return
+	}
+
+	ex.deadManSwitches[userID] = &deadManSwitch{
+		timeout:       timeout,
+		lastHeartbeat: ex.Clock.Now(),
+	}
+}
+
+// Heartbeat 記錄用戶心跳，返回該用戶是否有啟用斷線自動撤單
+func (ex *Exchange) Heartbeat(userID string) bool {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	dms, ok := ex.deadManSwitches[userID]
+	if !ok {
+		return false
+	}
+	dms.lastHeartbeat = ex.Clock.Now()
+	return true
+}
+
+// checkDeadManSwitches 撤銷所有心跳逾時用戶的訂單（含開盤前競價簿中的訂單及尚未觸發的停損單），
+// 返回被撤銷的訂單ID。觸發後該用戶的開關會被移除，需重新設定
+func (ex *Exchange) checkDeadManSwitches() []string {
+	now := ex.Clock.Now()
+
+	ex.mutex.Lock()
+	expired := make([]string, 0)
+	for userID, dms := range ex.deadManSwitches {
+		if now.Sub(dms.lastHeartbeat) > dms.timeout {
+			expired = append(expired, userID)
+			delete(ex.deadManSwitches, userID)
+		}
+	}
+	books := make([]*orderbook.OrderBook, 0, len(ex.OrderBooks)+len(ex.auctionBooks))
+	for _, ob := range ex.OrderBooks {
+		books = append(books, ob)
+	}
+	for _, ob := range ex.auctionBooks {
+		books = append(books, ob)
+	}
+	ex.mutex.Unlock()
+
+	cancelled := make([]string, 0)
+	for _, userID := range expired {
+		for _, ob := range books {
+			cancelled = append(cancelled, ob.CancelUserOrders(userID)...)
+		}
+	}
+	return cancelled
+}
+
+// RunDeadManMonitor 背景監控心跳，每隔 interval 檢查一次，直到 stop 被關閉
+func (ex *Exchange) RunDeadManMonitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ex.checkDeadManSwitches()
+		case <-stop:
+			return
+		}
+	}
+}
+
+type HeartbeatRequest struct {
+	UserID string
+}
+
+func (ex *Exchange) handleHeartbeat(ctx echo.Context) error {
+	var req HeartbeatRequest
+
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "invalid request"})
+	}
+
+	if !ex.Heartbeat(req.UserID) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"msg": "dead man's switch not set"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"msg": "ok"})
+}
@@ -0,0 +1,20 @@
+package main
+
+import "time"
+
+// RunExpirySweeper 每隔 interval 清除各訂單簿中超過最長存活時間的掛單，直到 stop 被關閉
+func (ex *Exchange) RunExpirySweeper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, ob := range ex.OrderBooks {
+				ob.SweepExpired()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
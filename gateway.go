@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// Validator 下單進入訂單簿前的檢查，返回錯誤即拒絕訂單；
+// 應返回 orderbook 的哨兵錯誤或下方的錯誤，HTTP 介面據此決定狀態碼
+type Validator func(o *orderbook.Order) error
+
+var (
+	// 限價不是最小價格變動單位的整數倍
+	ErrPriceNotOnTick = errors.New("price is not a multiple of the tick size")
+	// 數量不是一手的整數倍
+	ErrQuantityNotOnLot = errors.New("quantity is not a multiple of the lot size")
+	// 限價單的名目金額低於下限
+	ErrNotionalTooSmall = errors.New("order notional below minimum")
+)
+
+// AddValidator 加入對所有交易對生效的檢查，按加入順序執行
+func (ex *Exchange) AddValidator(v Validator) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	ex.validators = append(ex.validators, v)
+}
+
+// AddSymbolValidator 加入只對某交易對生效的檢查，在所有交易對共用的檢查之後執行
+func (ex *Exchange) AddSymbolValidator(symbol orderbook.Symbol, v Validator) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	ex.symbolValidators[symbol] = append(ex.symbolValidators[symbol], v)
+}
+
+// 交易對適用的檢查：所有交易對共用的在前，交易對專屬的在後
+func (ex *Exchange) validatorPipeline(symbol orderbook.Symbol) []Validator {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	return append(append([]Validator(nil), ex.validators...), ex.symbolValidators[symbol]...)
+}
+
+// 依序執行檢查，遇到第一個錯誤即返回
+func runValidators(pipeline []Validator, o *orderbook.Order) error {
+	for _, v := range pipeline {
+		if err := v(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TickSizeValidator 限價必須是 tick 的整數倍，市價單不檢查；tick 不為正數時不限制
+func TickSizeValidator(tick orderbook.Decimal) Validator {
+	return func(o *orderbook.Order) error {
+		if tick > 0 && o.Type != orderbook.Market && o.Price%tick != 0 {
+			return ErrPriceNotOnTick
+		}
+		return nil
+	}
+}
+
+// LotSizeValidator 數量必須是 lot 的整數倍；lot 不為正數時不限制
+func LotSizeValidator(lot orderbook.Decimal) Validator {
+	return func(o *orderbook.Order) error {
+		if lot > 0 && o.Quantity%lot != 0 {
+			return ErrQuantityNotOnLot
+		}
+		return nil
+	}
+}
+
+// MinNotionalValidator 限價單的名目金額（價格 × 數量）不得低於 minNotional，市價單不檢查
+func MinNotionalValidator(minNotional orderbook.Decimal) Validator {
+	return func(o *orderbook.Order) error {
+		if o.Type != orderbook.Market && o.Price.Mul(o.Quantity) < minNotional {
+			return ErrNotionalTooSmall
+		}
+		return nil
+	}
+}
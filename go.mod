@@ -2,8 +2,9 @@ module github.com/clary-work01/crypto_exchange
 
 go 1.24.2
 
+require github.com/labstack/echo/v4 v4.13.4
+
 require (
-	github.com/labstack/echo/v4 v4.13.4 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/clary-work01/crypto_exchange/orderbook"
 	"github.com/labstack/echo/v4"
@@ -13,6 +18,22 @@ func main() {
 	ex := NewExchange()
 
 	e.POST("/order", ex.handlePlaceOrder)
+	e.POST("/heartbeat", ex.handleHeartbeat)
+	e.GET("/replication/:symbol", ex.handleGetReplication)
+	e.GET("/metrics", ex.handleMetrics)
+	e.GET("/metrics/json", ex.handleMetricsJSON)
+	e.GET("/depth/:symbol", ex.handleGetDepth)
+	e.GET("/depth/:symbol/delta", ex.handleGetDepthDelta)
+	e.GET("/summary/:symbol", ex.handleGetSummary)
+	e.GET("/order/:id/trades", ex.handleGetOrderTrades)
+	e.GET("/markets", ex.handleGetMarkets)
+
+	pool := NewMatchingPool(ex, 1024)
+	defer pool.Close()
+
+	go ex.RunDeadManMonitor(time.Second, nil)
+	go ex.RunExpirySweeper(time.Second, nil)
+	go ex.RunOpeningAuctionTimer(time.Second, nil)
 
 	e.Start(":3000")
 
@@ -20,54 +41,369 @@ func main() {
 
 type Exchange struct {
 	OrderBooks map[orderbook.Symbol]*orderbook.OrderBook
+	Markets    map[orderbook.Symbol]Market
+	Accounts   *Accounts
+	Clock      orderbook.Clock
+
+	mutex           sync.Mutex
+	deadManSwitches map[string]*deadManSwitch
+	phases          map[orderbook.Symbol]Phase
+	auctionBooks    map[orderbook.Symbol]*orderbook.OrderBook // 開盤前集合競價簿
+	// 交易對的階段切換鎖：下單全程持有讀鎖，切換階段（含集合競價及移入訂單）持有寫鎖；
+	// 建立後不再增減，可不經 mutex 讀取
+	phaseLocks map[orderbook.Symbol]*sync.RWMutex
+	pool       atomic.Pointer[MatchingPool] // 設定時下單經由撮合池
+
+	bracketMu        sync.Mutex
+	brackets         map[string]*bracket      // 進場單ID -> 進行中的括號單
+	finishedBrackets map[string]BracketStatus // 進場單ID -> 已結束括號單的最終狀態
+	finishedOrder    []string                 // 已結束括號單按結束順序排列，用於捨棄最早的紀錄
+
+	validators       []Validator                      // 下單前對所有交易對執行的檢查
+	symbolValidators map[orderbook.Symbol][]Validator // 只對特定交易對執行的檢查
+
+	stateMu      sync.Mutex
+	stateHistory map[orderbook.Symbol][]StateTransition // 階段及交易狀態變更紀錄
 }
 
 func NewExchange() *Exchange {
+	return newExchange(orderbook.SystemClock)
+}
+
+func newExchange(clock orderbook.Clock) *Exchange {
 	orderbooks := make(map[orderbook.Symbol]*orderbook.OrderBook)
 	orderbooks[orderbook.ETH] = orderbook.NewOrderBook(orderbook.ETH)
 
-	return &Exchange{
-		OrderBooks: orderbooks,
+	for _, ob := range orderbooks {
+		ob.Clock = clock
+	}
+
+	markets := map[orderbook.Symbol]Market{
+		orderbook.ETH: {Base: "ETH", Quote: "USDT"},
 	}
+
+	ex := &Exchange{
+		OrderBooks:       orderbooks,
+		Markets:          markets,
+		Accounts:         NewAccounts(),
+		Clock:            clock,
+		deadManSwitches:  make(map[string]*deadManSwitch),
+		phases:           make(map[orderbook.Symbol]Phase),
+		auctionBooks:     make(map[orderbook.Symbol]*orderbook.OrderBook),
+		phaseLocks:       make(map[orderbook.Symbol]*sync.RWMutex),
+		brackets:         make(map[string]*bracket),
+		finishedBrackets: make(map[string]BracketStatus),
+		stateHistory:     make(map[orderbook.Symbol][]StateTransition),
+		symbolValidators: make(map[orderbook.Symbol][]Validator),
+	}
+	for symbol, ob := range orderbooks {
+		ex.phaseLocks[symbol] = &sync.RWMutex{}
+		ex.watchBookState(symbol, ob)
+	}
+	return ex
 }
 
 type PlaceOrderRequest struct {
+	UserID   string
 	Symbol   orderbook.Symbol
 	Type     orderbook.OrderType
 	Side     orderbook.OrderSide
-	Price    float64
-	Quantity float64
+	Price    orderbook.Decimal
+	Quantity orderbook.Decimal
+	PostOnly bool              // 只做 maker，會立即成交時拒絕
+	Metadata map[string]string // 原樣帶到成交紀錄上
+}
+
+// 請求欄位驗證錯誤
+type fieldError struct {
+	Field string
+	Msg   string
+}
+
+// 檢查必填欄位：Symbol 不可為空、Quantity 必須為正、限價單 Price 必須為正（允許負價格的交易對除外）
+func (r PlaceOrderRequest) validate(allowNegativePrice bool) *fieldError {
+	switch {
+	case r.Symbol == "":
+		return &fieldError{Field: "Symbol", Msg: "symbol is required"}
+	case r.Quantity <= 0:
+		return &fieldError{Field: "Quantity", Msg: "quantity must be positive"}
+	case r.Type == orderbook.Limit && r.Price <= 0 && !allowNegativePrice:
+		return &fieldError{Field: "Price", Msg: "price must be positive for limit orders"}
+	}
+	return nil
+}
+
+// 單筆成交回應，Fee 為本訂單（吃單方）支付的手續費
+type TradeResponse struct {
+	ID        string
+	Price     orderbook.Decimal
+	Quantity  orderbook.Decimal
+	Fee       orderbook.Decimal
+	Timestamp time.Time
+}
+
+type PlaceOrderResponse struct {
+	OrderID        string
+	Status         string
+	FilledQuantity orderbook.Decimal
+	Trades         []TradeResponse
+	TotalFee       orderbook.Decimal // 本訂單累計手續費
+}
+
+// PlaceOrder 按市場階段將訂單送到對應交易對的訂單簿；啟用撮合池時經由撮合池處理
+func (ex *Exchange) PlaceOrder(o *orderbook.Order) ([]*orderbook.Trade, error) {
+	if pool := ex.pool.Load(); pool != nil {
+		// 撮合池剛關閉時改為直接下單
+		if trades, err := pool.PlaceOrder(o); !errors.Is(err, ErrPoolClosed) {
+			return trades, err
+		}
+	}
+
+	trades, err := ex.placeOrder(o)
+	ex.processBrackets()
+	return trades, err
+}
+
+// 下單但不推進括號單；先經過檢查流程，任一檢查失敗即拒絕。
+// 持有交易對的階段切換讀鎖，切換階段期間的下單會等到集合競價的訂單移入連續競價簿之後
+func (ex *Exchange) placeOrder(o *orderbook.Order) ([]*orderbook.Trade, error) {
+	if lock := ex.phaseLocks[o.Symbol]; lock != nil {
+		lock.RLock()
+		defer lock.RUnlock()
+	}
+	ob, err := ex.routeOrderBook(o.Symbol)
+	if err != nil {
+		return nil, &orderbook.OrderError{OrderID: o.ID, Err: err}
+	}
+	if err := screenOrder(ex.validatorPipeline(o.Symbol), o); err != nil {
+		return nil, err
+	}
+	return ob.PlaceOrder(o)
+}
+
+// 執行下單前的檢查流程，未通過時將訂單標記為被拒絕並返回 *orderbook.OrderError
+func screenOrder(pipeline []Validator, o *orderbook.Order) error {
+	if err := runValidators(pipeline, o); err != nil {
+		o.Status = orderbook.Cancelled
+		o.CancelReason = orderbook.Rejected
+		return &orderbook.OrderError{OrderID: o.ID, Err: err}
+	}
+	return nil
+}
+
+// 將 orderbook 的錯誤對應到 HTTP 狀態碼
+func httpStatusFor(err error) int {
+	switch {
+	case errors.Is(err, orderbook.ErrUnknownSymbol),
+		errors.Is(err, orderbook.ErrInvalidPrice),
+		errors.Is(err, orderbook.ErrInvalidQuantity),
+		errors.Is(err, orderbook.ErrMarketOrderInAuction),
+		errors.Is(err, ErrPriceNotOnTick),
+		errors.Is(err, ErrQuantityNotOnLot),
+		errors.Is(err, ErrNotionalTooSmall):
+		return http.StatusBadRequest
+	case errors.Is(err, orderbook.ErrOrderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, orderbook.ErrDuplicateOrderID):
+		return http.StatusConflict
+	case errors.Is(err, orderbook.ErrNoLiquidity),
+		errors.Is(err, orderbook.ErrInsufficientLiquidity),
+		errors.Is(err, orderbook.ErrSpreadTooWide),
+		errors.Is(err, orderbook.ErrPriceDeviation),
+		errors.Is(err, orderbook.ErrWouldLock),
+		errors.Is(err, orderbook.ErrInsufficientImprovement),
+		errors.Is(err, orderbook.ErrLevelFull),
+		errors.Is(err, orderbook.ErrPostOnlyWouldTake):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, orderbook.ErrTooManyOpenOrders):
+		return http.StatusTooManyRequests
+	case errors.Is(err, orderbook.ErrBookHalted),
+		errors.Is(err, orderbook.ErrBookDraining),
+		errors.Is(err, ErrPoolClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// 錯誤回應，訂單相關錯誤會附上訂單ID
+func errorResponse(ctx echo.Context, err error) error {
+	body := map[string]string{"msg": err.Error()}
+
+	var orderErr *orderbook.OrderError
+	if errors.As(err, &orderErr) {
+		body["msg"] = orderErr.Err.Error()
+		body["orderID"] = orderErr.OrderID
+	}
+	return ctx.JSON(httpStatusFor(err), body)
 }
 
 func (ex *Exchange) handlePlaceOrder(ctx echo.Context) error {
 	var req PlaceOrderRequest
 
 	if err := json.NewDecoder(ctx.Request().Body).Decode(&req); err != nil {
-		return err
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "invalid request body"})
+	}
+	allowNegative := false
+	if ob, ok := ex.OrderBooks[req.Symbol]; ok {
+		allowNegative = ob.AllowNegativePrice
+	}
+	if ferr := req.validate(allowNegative); ferr != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": ferr.Msg, "field": ferr.Field})
 	}
 
-	fmt.Println(req.Symbol)
-	buyOrder1 := &orderbook.Order{
-		ID:       "buy1",
+	order := &orderbook.Order{
+		ID:       orderbook.GenerateOrderID(),
+		UserID:   req.UserID,
 		Symbol:   req.Symbol,
 		Side:     req.Side,
 		Type:     req.Type,
 		Price:    req.Price,
 		Quantity: req.Quantity,
+		PostOnly: req.PostOnly,
+		Metadata: req.Metadata,
+	}
+
+	trades, err := ex.PlaceOrder(order)
+	if err != nil {
+		return errorResponse(ctx, err)
+	}
+	ob := ex.OrderBooks[order.Symbol]
+
+	resp := PlaceOrderResponse{
+		OrderID:        order.ID,
+		Status:         orderbook.GetStatusName(order.Status),
+		FilledQuantity: order.FilledQuantity,
+		Trades:         make([]TradeResponse, 0, len(trades)),
+	}
+	for _, t := range trades {
+		fee := ob.Fees.TakerFee(t.Price, t.Quantity)
+		resp.Trades = append(resp.Trades, TradeResponse{
+			ID:        t.ID,
+			Price:     t.Price,
+			Quantity:  t.Quantity,
+			Fee:       fee,
+			Timestamp: t.Timestamp,
+		})
+		resp.TotalFee += fee
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+type DepthResponse struct {
+	Symbol   orderbook.Symbol
+	Sequence uint64
+	Bids     []orderbook.DepthLevel
+	Asks     []orderbook.DepthLevel
+}
+
+// 未指定 limit 時的預設檔數
+const defaultDepthLimit = 100
+
+// GET /depth/:symbol?limit=N 返回按價格聚合的 L2 深度快照及序號
+func (ex *Exchange) handleGetDepth(ctx echo.Context) error {
+	symbol := orderbook.Symbol(ctx.Param("symbol"))
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return errorResponse(ctx, orderbook.ErrUnknownSymbol)
+	}
+
+	limit := defaultDepthLimit
+	if raw := ctx.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "invalid limit"})
+		}
+		limit = n
+	}
+
+	bids, asks, seq := ob.DepthSnapshot(limit)
+	return ctx.JSON(http.StatusOK, DepthResponse{
+		Symbol:   symbol,
+		Sequence: seq,
+		Bids:     bids,
+		Asks:     asks,
+	})
+}
+
+// EnableDepthDeltas 讓所有訂單簿記錄深度層級變更供 /depth/:symbol/delta 輪詢，每個交易對保留 limit 筆，
+// 超過範圍的輪詢客戶端需重新取得快照；未啟用時該端點一律要求重新同步
+func (ex *Exchange) EnableDepthDeltas(limit int) {
+	for _, ob := range ex.OrderBooks {
+		ob.DepthDeltaLimit = limit
+	}
+}
+
+// 深度增量回應；Resync 為 true 時 since 已超出保留範圍，客戶端需重新取得完整快照
+type DepthDeltaResponse struct {
+	Symbol   orderbook.Symbol
+	Since    uint64
+	Sequence uint64
+	Changes  []orderbook.LevelChange
+	Resync   bool
+}
+
+// GET /depth/:symbol/delta?since=N 返回序號 N 之後的深度層級變更及新序號
+func (ex *Exchange) handleGetDepthDelta(ctx echo.Context) error {
+	symbol := orderbook.Symbol(ctx.Param("symbol"))
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return errorResponse(ctx, orderbook.ErrUnknownSymbol)
+	}
+
+	since, err := strconv.ParseUint(ctx.QueryParam("since"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "invalid since"})
 	}
 
-	symbol := req.Symbol
-	ob := ex.OrderBooks[symbol]
-	trade := ob.PlaceOrder(buyOrder1)
-	fmt.Println(trade)
-	return ctx.JSON(200, "order placed")
+	changes, seq, ok := ob.DepthDelta(since)
+	return ctx.JSON(http.StatusOK, DepthDeltaResponse{
+		Symbol:   symbol,
+		Since:    since,
+		Sequence: seq,
+		Changes:  changes,
+		Resync:   !ok,
+	})
 }
 
-// func (ex *Exchange) handleGetOrderBook(ctx echo.Context) error {
-// 	symbol := ctx.Param("symbol")
-// 	ob, ok := ex.OrderBooks[orderbook.Symbol(symbol)]
+type SummaryResponse struct {
+	Symbol         orderbook.Symbol
+	BestBid        orderbook.Decimal
+	BestAsk        orderbook.Decimal
+	Mid            orderbook.Decimal
+	Spread         orderbook.Decimal
+	LiquidityScore float64
+}
+
+// GET /summary/:symbol 返回交易對概況
+func (ex *Exchange) handleGetSummary(ctx echo.Context) error {
+	symbol := orderbook.Symbol(ctx.Param("symbol"))
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return errorResponse(ctx, orderbook.ErrUnknownSymbol)
+	}
+
+	resp := SummaryResponse{Symbol: symbol, LiquidityScore: ob.LiquidityScore()}
+	resp.BestBid, resp.BestAsk, _ = ob.GetBestBidAsk()
+	if resp.BestBid != 0 && resp.BestAsk != 0 {
+		resp.Mid = (resp.BestBid + resp.BestAsk) / 2
+		resp.Spread = resp.BestAsk - resp.BestBid
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+type OrderTradesResponse struct {
+	OrderID string
+	Trades  []orderbook.Trade
+}
 
-// 	if !ok {
-// 		return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "symbol not found"})
-// 	}
-// }
+// GET /order/:id/trades 返回訂單作為買方或賣方的所有成交，供客戶端對帳
+func (ex *Exchange) handleGetOrderTrades(ctx echo.Context) error {
+	orderID := ctx.Param("id")
+	resp := OrderTradesResponse{OrderID: orderID, Trades: make([]orderbook.Trade, 0)}
+	for _, symbol := range ex.sortedSymbols() {
+		resp.Trades = append(resp.Trades, ex.OrderBooks[symbol].TradesForOrder(orderID)...)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
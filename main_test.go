@@ -0,0 +1,1139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// 發送 POST /order 並解析回應
+func postOrder(t *testing.T, ex *Exchange, body string) (*httptest.ResponseRecorder, PlaceOrderResponse) {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	if err := ex.handlePlaceOrder(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+
+	var resp PlaceOrderResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// 測試用的十進位數字面值
+func dec(f float64) orderbook.Decimal {
+	return orderbook.NewDecimal(f)
+}
+
+// 測試心跳逾時後自動撤銷用戶所有訂單
+func TestDeadManSwitch(t *testing.T) {
+	clock := orderbook.NewManualClock(time.Unix(1700000000, 0))
+	ex := newExchange(clock)
+	ob := ex.OrderBooks[orderbook.ETH]
+
+	ob.PlaceOrder(&orderbook.Order{ID: "mm_bid", UserID: "mm", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1990), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "mm_ask", UserID: "mm", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2010), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "other_bid", UserID: "other", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1980), Quantity: dec(1)})
+
+	ex.SetDeadManSwitch("mm", 5*time.Second)
+
+	// 逾時前有心跳，訂單保留
+	clock.Advance(4 * time.Second)
+	if !ex.Heartbeat("mm") {
+		t.Fatal("expected heartbeat to be accepted")
+	}
+	clock.Advance(4 * time.Second)
+	if cancelled := ex.checkDeadManSwitches(); len(cancelled) != 0 {
+		t.Fatalf("expected no cancellations, got %v", cancelled)
+	}
+
+	// 背景監控在逾時後撤單
+	stop := make(chan struct{})
+	defer close(stop)
+	go ex.RunDeadManMonitor(time.Millisecond, stop)
+	clock.Advance(2 * time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	bids, asks := ob.GetDepth(10)
+	for (len(bids) > 1 || len(asks) > 0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		bids, asks = ob.GetDepth(10)
+	}
+
+	if len(asks) != 0 {
+		t.Errorf("expected mm ask to be cancelled, got %d ask levels", len(asks))
+	}
+	if len(bids) != 1 || bids[0].Price != dec(1980) {
+		t.Errorf("expected only other user's bid to remain, got %v", bids)
+	}
+	if ex.Heartbeat("mm") {
+		t.Error("expected switch to be removed after firing")
+	}
+}
+
+// 測試開盤集合競價時間到後即使沒有新訂單，背景定時器也會完成競價
+func TestOpeningAuctionTimer(t *testing.T) {
+	clock := orderbook.NewManualClock(time.Unix(1700000000, 0))
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.Clock = clock
+	ob.OpeningAuctionWindow = time.Minute
+
+	ex.PlaceOrder(&orderbook.Order{ID: "bid", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2010), Quantity: dec(1)})
+	ex.PlaceOrder(&orderbook.Order{ID: "ask", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go ex.RunOpeningAuctionTimer(time.Millisecond, stop)
+
+	ex.checkOpeningAuctions()
+	if _, ok := ob.OpeningAuctionResult(); ok {
+		t.Fatal("expected auction still open before the window ends")
+	}
+	clock.Advance(2 * time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	result, ok := ob.OpeningAuctionResult()
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		result, ok = ob.OpeningAuctionResult()
+	}
+	if !ok || result.Quantity != dec(1) {
+		t.Fatalf("expected timer to complete the auction for 1, got %+v %v", result, ok)
+	}
+}
+
+// 測試斷線自動撤單涵蓋開盤前競價簿中的訂單及尚未觸發的停損單
+func TestDeadManSwitchCoversAuctionAndStops(t *testing.T) {
+	clock := orderbook.NewManualClock(time.Unix(1700000000, 0))
+	ex := newExchange(clock)
+	ex.PlaceOrder(&orderbook.Order{ID: "stop", UserID: "mm", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.StopLimit, StopPrice: dec(2100), Price: dec(2110), Quantity: dec(1)})
+	ex.SetPhase(orderbook.ETH, PreOpen)
+	ex.PlaceOrder(&orderbook.Order{ID: "auction_bid", UserID: "mm", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1990), Quantity: dec(1)})
+
+	ex.SetDeadManSwitch("mm", time.Second)
+	clock.Advance(2 * time.Second)
+	cancelled := strings.Join(ex.checkDeadManSwitches(), ",")
+	if cancelled != "stop,auction_bid" && cancelled != "auction_bid,stop" {
+		t.Errorf("expected auction order and stop cancelled, got %s", cancelled)
+	}
+	if stops := ex.OrderBooks[orderbook.ETH].PendingStopOrders(); len(stops) != 0 {
+		t.Errorf("expected no pending stops, got %d", len(stops))
+	}
+}
+
+// 測試下單回應包含每筆成交手續費與累計手續費
+func TestPlaceOrderResponseIncludesFees(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.Fees = orderbook.FeeSchedule{MakerRate: dec(-0.0001), TakerRate: dec(0.001)}
+
+	ob.PlaceOrder(&orderbook.Order{ID: "ask1", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "ask2", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2010), Quantity: dec(1)})
+
+	rec, resp := postOrder(t, ex, `{"UserID":"taker","Symbol":"ETH","Type":0,"Side":0,"Price":2010,"Quantity":1.5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Trades) != 2 {
+		t.Fatalf("expected 2 trades, got %d", len(resp.Trades))
+	}
+
+	wantFees := []orderbook.Decimal{dec(2), dec(1.005)}
+	var total orderbook.Decimal
+	for i, tr := range resp.Trades {
+		if tr.Fee != wantFees[i] {
+			t.Errorf("trade %d: expected fee %s, got %s", i, wantFees[i], tr.Fee)
+		}
+		total += wantFees[i]
+	}
+	if resp.TotalFee != total {
+		t.Errorf("expected total fee %s, got %s", total, resp.TotalFee)
+	}
+	if !strings.Contains(rec.Body.String(), `"Fee"`) || !strings.Contains(rec.Body.String(), `"TotalFee"`) {
+		t.Errorf("expected fee fields in body: %s", rec.Body.String())
+	}
+}
+
+// 測試市價單遇到空訂單簿時回應 422
+func TestPlaceMarketOrderNoLiquidity(t *testing.T) {
+	ex := NewExchange()
+
+	rec, _ := postOrder(t, ex, `{"Symbol":"ETH","Type":1,"Side":0,"Quantity":1}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "no liquidity") {
+		t.Errorf("expected no liquidity message, got %s", rec.Body.String())
+	}
+}
+
+// 測試錯誤到 HTTP 狀態碼的對應
+func TestHTTPStatusForErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{orderbook.ErrUnknownSymbol, http.StatusBadRequest},
+		{orderbook.ErrInvalidPrice, http.StatusBadRequest},
+		{orderbook.ErrInvalidQuantity, http.StatusBadRequest},
+		{orderbook.ErrOrderNotFound, http.StatusNotFound},
+		{orderbook.ErrDuplicateOrderID, http.StatusConflict},
+		{orderbook.ErrNoLiquidity, http.StatusUnprocessableEntity},
+		{orderbook.ErrInsufficientLiquidity, http.StatusUnprocessableEntity},
+		{orderbook.ErrBookHalted, http.StatusServiceUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		wrapped := &orderbook.OrderError{OrderID: "o1", Err: tt.err}
+		if got := httpStatusFor(wrapped); got != tt.want {
+			t.Errorf("%v: expected %d, got %d", tt.err, tt.want, got)
+		}
+	}
+
+	rec, _ := postOrder(t, NewExchange(), `{"Symbol":"DOGE","Type":0,"Side":0,"Price":1,"Quantity":1}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown symbol") {
+		t.Errorf("expected 400 unknown symbol, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// 測試備援落後量的指標與查詢端點
+func TestReplicationLagEndpoints(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.MarkApplied(4)
+	ob.ObservePrimarySequence(9)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/replication/ETH", nil)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	ctx.SetParamNames("symbol")
+	ctx.SetParamValues("ETH")
+	if err := ex.handleGetReplication(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var resp ReplicationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.PrimarySequence != 9 || resp.AppliedSequence != 4 || resp.Lag != 5 {
+		t.Errorf("unexpected replication status %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := ex.handleMetrics(e.NewContext(httptest.NewRequest(http.MethodGet, "/metrics", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rec.Body.String(), `orderbook_replication_lag{symbol="ETH"} 5`) {
+		t.Errorf("expected lag metric, got %s", rec.Body.String())
+	}
+}
+
+// 發送 GET 請求到帶 :symbol 參數的處理函數
+func getWithSymbol(t *testing.T, handler echo.HandlerFunc, target, symbol string) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)
+	ctx.SetParamNames("symbol")
+	ctx.SetParamValues(symbol)
+	if err := handler(ctx); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	return rec
+}
+
+// 測試 L2 深度快照的排序、檔數限制及序號
+func TestGetDepthEndpoint(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	for i, price := range []orderbook.Decimal{dec(1990), dec(1995), dec(1980), dec(1985)} {
+		ob.PlaceOrder(&orderbook.Order{ID: fmt.Sprintf("bid%d", i), Side: orderbook.Bid, Type: orderbook.Limit, Price: price, Quantity: dec(1)})
+	}
+	for i, price := range []orderbook.Decimal{dec(2010), dec(2005), dec(2020)} {
+		ob.PlaceOrder(&orderbook.Order{ID: fmt.Sprintf("ask%d", i), Side: orderbook.Ask, Type: orderbook.Limit, Price: price, Quantity: dec(2)})
+	}
+
+	rec := getWithSymbol(t, ex.handleGetDepth, "/depth/ETH?limit=2", "ETH")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"Sequence":7`) {
+		t.Errorf("expected sequence field, got %s", rec.Body.String())
+	}
+
+	var resp DepthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Bids) != 2 || resp.Bids[0].Price != dec(1995) || resp.Bids[1].Price != dec(1990) {
+		t.Errorf("expected bids [1995 1990], got %v", resp.Bids)
+	}
+	if len(resp.Asks) != 2 || resp.Asks[0].Price != dec(2005) || resp.Asks[1].Price != dec(2010) || resp.Asks[0].Quantity != dec(2) {
+		t.Errorf("expected asks [2005 2010], got %v", resp.Asks)
+	}
+
+	if rec := getWithSymbol(t, ex.handleGetDepth, "/depth/ETH?limit=abc", "ETH"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid limit, got %d", rec.Code)
+	}
+}
+
+// 測試深度增量：取得快照後的層級新增、更新與移除，及過舊序號的重新同步訊號
+func TestGetDepthDeltaEndpoint(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	if _, _, ok := ob.DepthDelta(0); ok {
+		t.Fatal("expected depth deltas to be off by default")
+	}
+	ex.EnableDepthDeltas(10000)
+	ob.PlaceOrder(&orderbook.Order{ID: "bid1", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1990), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "ask1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2010), Quantity: dec(2)})
+	_, _, since := ob.DepthSnapshot(10)
+
+	ob.PlaceOrder(&orderbook.Order{ID: "bid2", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1995), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "buy", Side: orderbook.Bid, Type: orderbook.Market, Quantity: dec(0.5)})
+	ob.CancelOrder("bid1")
+
+	rec := getWithSymbol(t, ex.handleGetDepthDelta, fmt.Sprintf("/depth/ETH/delta?since=%d", since), "ETH")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp DepthDeltaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Resync || resp.Sequence != since+3 {
+		t.Fatalf("expected delta up to sequence %d, got %+v", since+3, resp)
+	}
+	want := []orderbook.LevelChange{
+		{Sequence: since + 1, Action: orderbook.LevelAdded, Side: orderbook.Bid, Price: dec(1995), Quantity: dec(1)},
+		{Sequence: since + 2, Action: orderbook.LevelUpdated, Side: orderbook.Ask, Price: dec(2010), Quantity: dec(1.5)},
+		{Sequence: since + 3, Action: orderbook.LevelRemoved, Side: orderbook.Bid, Price: dec(1990)},
+	}
+	if len(resp.Changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), resp.Changes)
+	}
+	for i := range want {
+		if resp.Changes[i] != want[i] {
+			t.Errorf("change %d: expected %+v, got %+v", i, want[i], resp.Changes[i])
+		}
+	}
+
+	// 超出保留範圍時要求重新同步
+	ob.DepthDeltaLimit = 1
+	ob.PlaceOrder(&orderbook.Order{ID: "bid3", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1980), Quantity: dec(1)})
+	rec = getWithSymbol(t, ex.handleGetDepthDelta, fmt.Sprintf("/depth/ETH/delta?since=%d", since), "ETH")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Resync {
+		t.Errorf("expected resync signal for stale sequence, got %s", rec.Body.String())
+	}
+
+	if rec := getWithSymbol(t, ex.handleGetDepthDelta, "/depth/ETH/delta?since=x", "ETH"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid since, got %d", rec.Code)
+	}
+}
+
+// 測試成交後餘額反映幣種轉移及手續費/返佣，交易所收入等於淨手續費
+func TestFeeRebateSettlement(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.Fees = orderbook.FeeSchedule{MakerRate: dec(-0.0002), TakerRate: dec(0.001)}
+	ex.EnableSettlement()
+
+	ex.Accounts.Deposit("maker", "ETH", dec(5))
+	ex.Accounts.Deposit("taker", "USDT", dec(10000))
+
+	ob.PlaceOrder(&orderbook.Order{ID: "ask", UserID: "maker", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(2)})
+	ob.PlaceOrder(&orderbook.Order{ID: "bid", UserID: "taker", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1.5)})
+
+	// 成交額 3000，吃單手續費 3，掛單返佣 0.6
+	checks := []struct {
+		user, asset string
+		want        orderbook.Decimal
+	}{
+		{"taker", "ETH", dec(1.5)},
+		{"taker", "USDT", dec(10000 - 3000 - 3)},
+		{"maker", "ETH", dec(3.5)},
+		{"maker", "USDT", dec(3000.6)},
+		{ExchangeAccountID, "USDT", dec(2.4)},
+	}
+	for _, c := range checks {
+		if got := ex.Accounts.Balance(c.user, c.asset); got != c.want {
+			t.Errorf("%s %s: expected %s, got %s", c.user, c.asset, c.want, got)
+		}
+	}
+}
+
+// 測試多筆成交（雙方向吃單、手續費被截斷）後，每個資產的用戶變動加上交易所收入精確為 0
+func TestSettlementConservation(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.Fees = orderbook.FeeSchedule{MakerRate: dec(-0.00025), TakerRate: dec(0.00075)}
+	ex.EnableSettlement()
+
+	ob.PlaceOrder(&orderbook.Order{ID: "a1", UserID: "u1", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000.01), Quantity: dec(0.3)})
+	ob.PlaceOrder(&orderbook.Order{ID: "a2", UserID: "u2", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000.03), Quantity: dec(0.7)})
+	ob.PlaceOrder(&orderbook.Order{ID: "b1", UserID: "u3", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000.03), Quantity: dec(0.77777777)})
+	ob.PlaceOrder(&orderbook.Order{ID: "b2", UserID: "u1", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1999.99), Quantity: dec(0.33333333)})
+	ob.PlaceOrder(&orderbook.Order{ID: "s1", UserID: "u2", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.Market, Quantity: dec(0.12345678)})
+
+	if len(ob.Trades) < 3 {
+		t.Fatalf("expected at least 3 trades, got %d", len(ob.Trades))
+	}
+	totals := make(map[string]orderbook.Decimal)
+	for _, assets := range ex.Accounts.balances {
+		for asset, balance := range assets {
+			totals[asset] += balance
+		}
+	}
+	for asset, total := range totals {
+		if total != 0 {
+			t.Errorf("%s: expected deltas to sum to 0, got %s", asset, total)
+		}
+	}
+	if revenue := ex.Accounts.Balance(ExchangeAccountID, "USDT"); revenue <= 0 {
+		t.Errorf("expected positive exchange revenue, got %s", revenue)
+	}
+}
+
+// 測試概況端點包含流動性評分
+func TestGetSummaryEndpoint(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.PlaceOrder(&orderbook.Order{ID: "bid", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1999), Quantity: dec(4)})
+	ob.PlaceOrder(&orderbook.Order{ID: "ask", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2001), Quantity: dec(6)})
+
+	rec := getWithSymbol(t, ex.handleGetSummary, "/summary/ETH", "ETH")
+	var resp SummaryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Mid != dec(2000) || resp.Spread != dec(2) {
+		t.Errorf("expected mid 2000 spread 2, got %+v", resp)
+	}
+	if !almostEqual(resp.LiquidityScore, ob.LiquidityScore()) || resp.LiquidityScore <= 0 {
+		t.Errorf("expected positive liquidity score, got %.4f", resp.LiquidityScore)
+	}
+}
+
+// 測試開盤前集合競價後切換到連續競價
+func TestPhaseAuctionThenContinuous(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+
+	if _, err := ex.SetPhase(orderbook.ETH, PreOpen); err != nil {
+		t.Fatal(err)
+	}
+	place := func(id string, side orderbook.OrderSide, price, qty orderbook.Decimal) []*orderbook.Trade {
+		trades, err := ex.PlaceOrder(&orderbook.Order{ID: id, Symbol: orderbook.ETH, Side: side, Type: orderbook.Limit, Price: price, Quantity: qty})
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		return trades
+	}
+
+	place("b1", orderbook.Bid, dec(2010), dec(1))
+	place("b2", orderbook.Bid, dec(2000), dec(1))
+	place("a1", orderbook.Ask, dec(1990), dec(1))
+	if trades := place("a2", orderbook.Ask, dec(2005), dec(2)); len(trades) != 0 {
+		t.Fatal("expected no matching during pre-open")
+	}
+	if len(ob.UnFilledOrders) != 0 {
+		t.Fatal("expected pre-open orders to stay out of the continuous book")
+	}
+
+	result, err := ex.SetPhase(orderbook.ETH, Continuous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1990 與 2000 皆可成交 1 且失衡相同，取較低價格
+	if result.Quantity != dec(1) || result.Price != dec(1990) {
+		t.Fatalf("expected auction to clear 1 at 1990, got %s at %s", result.Quantity, result.Price)
+	}
+
+	// 剩餘訂單移入連續競價簿
+	bid, ask, _ := ob.GetBestBidAsk()
+	if bid != dec(2000) || ask != dec(2005) {
+		t.Errorf("expected continuous book 2000/2005, got %s/%s", bid, ask)
+	}
+
+	// 連續競價階段正常撮合
+	if trades := place("b3", orderbook.Bid, dec(2005), dec(1)); len(trades) != 1 || trades[0].Price != dec(2005) {
+		t.Errorf("expected continuous match at 2005, got %v", trades)
+	}
+
+	ex.SetPhase(orderbook.ETH, Closed)
+	if _, err := ex.PlaceOrder(&orderbook.Order{ID: "late", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1), Quantity: dec(1)}); !errors.Is(err, orderbook.ErrBookHalted) {
+		t.Errorf("expected ErrBookHalted while closed, got %v", err)
+	}
+}
+
+// 測試開盤前下的停損單在集合競價結束後移入連續競價簿：被競價成交價觸發的轉為限價單進場
+func TestPhaseAuctionCarriesStops(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	if _, err := ex.SetPhase(orderbook.ETH, PreOpen); err != nil {
+		t.Fatal(err)
+	}
+	place := func(o *orderbook.Order) {
+		o.Symbol = orderbook.ETH
+		if _, err := ex.PlaceOrder(o); err != nil {
+			t.Fatalf("%s: %v", o.ID, err)
+		}
+	}
+	place(&orderbook.Order{ID: "b1", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+	place(&orderbook.Order{ID: "a1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+	triggered := &orderbook.Order{ID: "s1", Side: orderbook.Ask, Type: orderbook.StopLimit, StopPrice: dec(2010), Price: dec(2050), Quantity: dec(1)}
+	pending := &orderbook.Order{ID: "s2", Side: orderbook.Ask, Type: orderbook.StopLimit, StopPrice: dec(1900), Price: dec(1890), Quantity: dec(1)}
+	place(triggered)
+	place(pending)
+
+	if _, err := ex.SetPhase(orderbook.ETH, Continuous); err != nil {
+		t.Fatal(err)
+	}
+	// 競價成交價 2000 觸發 s1，以限價 2050 掛單；s2 仍等待觸發
+	if o, ok := ob.UnFilledOrders["s1"]; !ok || o.Type != orderbook.Limit || o.Price != dec(2050) {
+		t.Errorf("expected s1 resting as a limit order at 2050, got %+v", o)
+	}
+	stops := ob.PendingStopOrders()
+	if len(stops) != 1 || stops[0].ID != "s2" || pending.Status != orderbook.Pending {
+		t.Errorf("expected s2 pending in the continuous book, got %v", stops)
+	}
+}
+
+// 測試未執行集合競價即休市時，競價簿中的訂單及停損單被取消並發布取消事件
+func TestPhasePreOpenToClosedCancels(t *testing.T) {
+	ex := NewExchange()
+	if _, err := ex.SetPhase(orderbook.ETH, PreOpen); err != nil {
+		t.Fatal(err)
+	}
+	limit := &orderbook.Order{ID: "b1", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)}
+	stop := &orderbook.Order{ID: "s1", Symbol: orderbook.ETH, Side: orderbook.Ask, Type: orderbook.StopLimit, StopPrice: dec(1900), Price: dec(1890), Quantity: dec(1)}
+	for _, o := range []*orderbook.Order{limit, stop} {
+		if _, err := ex.PlaceOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, unsubscribe := ex.auctionBooks[orderbook.ETH].SubscribeEvents(16)
+	defer unsubscribe()
+
+	if _, err := ex.SetPhase(orderbook.ETH, Closed); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []*orderbook.Order{limit, stop} {
+		if o.Status != orderbook.Cancelled || o.CancelReason != orderbook.AuctionCancelled {
+			t.Errorf("%s: expected cancelled with AuctionCancelled, got %s/%s", o.ID,
+				orderbook.GetStatusName(o.Status), orderbook.GetCancelReasonName(o.CancelReason))
+		}
+	}
+	cancelled := make([]string, 0)
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == orderbook.EventOrderCancel {
+			cancelled = append(cancelled, ev.Order.ID)
+		}
+	}
+	if strings.Join(cancelled, ",") != "b1,s1" {
+		t.Errorf("expected cancel events for b1 and s1, got %v", cancelled)
+	}
+}
+
+// 測試切換階段期間的下單等到集合競價的剩餘訂單移入之後，不會插隊到前面
+func TestPhaseTransitionBlocksOrders(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	var wg sync.WaitGroup
+	var once sync.Once
+	// 集合競價成交時（切換進行中）送出一筆同價位的新訂單
+	ob.OnTrade = func(*orderbook.Trade) {
+		once.Do(func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ex.PlaceOrder(&orderbook.Order{ID: "late", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+			}()
+			time.Sleep(20 * time.Millisecond)
+		})
+	}
+	if _, err := ex.SetPhase(orderbook.ETH, PreOpen); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []*orderbook.Order{
+		{ID: "b1", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(2)},
+		{ID: "a1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)},
+	} {
+		o.Symbol = orderbook.ETH
+		if _, err := ex.PlaceOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ex.SetPhase(orderbook.ETH, Continuous); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	ids := make([]string, 0)
+	for _, o := range ob.BidLevels[dec(2000)].OrderList() {
+		ids = append(ids, o.ID)
+	}
+	if strings.Join(ids, ",") != "b1,late" {
+		t.Errorf("expected carried-over b1 ahead of late, got %v", ids)
+	}
+}
+
+// 測試競價簿沿用連續競價簿的設定，且移入連續競價簿時被拒絕的訂單會回報
+func TestPhaseAuctionKeepsConfig(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.MaxOpenOrdersPerUser = 1
+
+	if _, err := ex.PlaceOrder(&orderbook.Order{ID: "rest", UserID: "u1", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1900), Quantity: dec(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.SetPhase(orderbook.ETH, PreOpen); err != nil {
+		t.Fatal(err)
+	}
+	place := func(id string) error {
+		_, err := ex.PlaceOrder(&orderbook.Order{ID: id, UserID: "u1", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+		return err
+	}
+	if err := place("p1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := place("p2"); !errors.Is(err, orderbook.ErrTooManyOpenOrders) {
+		t.Fatalf("expected auction book to enforce MaxOpenOrdersPerUser, got %v", err)
+	}
+
+	// p1 移入連續競價簿時超過 u1 的掛單上限
+	_, err := ex.SetPhase(orderbook.ETH, Continuous)
+	if !errors.Is(err, orderbook.ErrTooManyOpenOrders) {
+		t.Fatalf("expected carry-over rejection to be reported, got %v", err)
+	}
+	var oe *orderbook.OrderError
+	if !errors.As(err, &oe) || oe.OrderID != "p1" {
+		t.Errorf("expected error for p1, got %v", err)
+	}
+	if _, ok := ob.UnFilledOrders["rest"]; !ok || len(ob.UnFilledOrders) != 1 {
+		t.Errorf("expected only the original order to rest, got %d orders", len(ob.UnFilledOrders))
+	}
+}
+
+// 測試 GET /order/:id/trades 返回訂單的所有成交
+func TestGetOrderTradesEndpoint(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.PlaceOrder(&orderbook.Order{ID: "m1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "m2", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "taker", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(101), Quantity: dec(2)})
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/order/taker/trades", nil), rec)
+	ctx.SetParamNames("id")
+	ctx.SetParamValues("taker")
+	if err := ex.handleGetOrderTrades(ctx); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+
+	var resp OrderTradesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(resp.Trades) != 2 {
+		t.Fatalf("expected 200 with 2 trades, got %d with %d", rec.Code, len(resp.Trades))
+	}
+}
+
+// 測試 GET /metrics/json 返回每個交易對的指標快照
+func TestMetricsJSONEndpoint(t *testing.T) {
+	ex := NewExchange()
+	ob := ex.OrderBooks[orderbook.ETH]
+	ob.PlaceOrder(&orderbook.Order{ID: "a1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2010), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "a2", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2020), Quantity: dec(2)})
+	ob.PlaceOrder(&orderbook.Order{ID: "b1", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(1990), Quantity: dec(1)})
+	ob.PlaceOrder(&orderbook.Order{ID: "m1", Side: orderbook.Bid, Type: orderbook.Market, Quantity: dec(1.5)})
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := ex.handleMetricsJSON(e.NewContext(httptest.NewRequest(http.MethodGet, "/metrics/json", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp map[string]orderbook.BookMetrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	m, ok := resp["ETH"]
+	if !ok {
+		t.Fatalf("expected ETH metrics, got %s", rec.Body.String())
+	}
+	if m.TotalResting != 2 || m.TotalBidOrders != 1 || m.TotalAskOrders != 1 {
+		t.Errorf("unexpected resting counts %+v", m.BookStats)
+	}
+	if m.BestBid != dec(1990) || m.BestAsk != dec(2020) || m.Mid != dec(2005) || m.Spread != dec(30) {
+		t.Errorf("unexpected prices %+v", m)
+	}
+	if m.LastPrice != dec(2020) || m.TotalTrades != 2 || m.Volume24h != dec(1.5) {
+		t.Errorf("unexpected trade metrics %+v", m)
+	}
+}
+
+// 測試只列出活躍交易對，include_idle 時列出全部
+func TestActiveSymbols(t *testing.T) {
+	ex := NewExchange()
+	ex.OrderBooks["BTC"] = orderbook.NewOrderBook("BTC")
+	ex.OrderBooks["SOL"] = orderbook.NewOrderBook("SOL")
+
+	// ETH 有掛單，BTC 只有成交，SOL 閒置
+	ex.OrderBooks[orderbook.ETH].PlaceOrder(&orderbook.Order{ID: "e1", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+	btc := ex.OrderBooks["BTC"]
+	btc.PlaceOrder(&orderbook.Order{ID: "b1", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(60000), Quantity: dec(1)})
+	btc.PlaceOrder(&orderbook.Order{ID: "b2", Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(60000), Quantity: dec(1)})
+
+	if got := fmt.Sprint(ex.ActiveSymbols()); got != "[BTC ETH]" {
+		t.Errorf("expected [BTC ETH], got %s", got)
+	}
+
+	for _, tc := range []struct {
+		target string
+		want   []orderbook.Symbol
+	}{
+		{"/markets", []orderbook.Symbol{"BTC", "ETH"}},
+		{"/markets?include_idle=true", []orderbook.Symbol{"BTC", "ETH", "SOL"}},
+	} {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		if err := ex.handleGetMarkets(e.NewContext(httptest.NewRequest(http.MethodGet, tc.target, nil), rec)); err != nil {
+			t.Fatal(err)
+		}
+		var markets []MarketSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &markets); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		got := make([]orderbook.Symbol, 0, len(markets))
+		for _, m := range markets {
+			got = append(got, m.Symbol)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.target, tc.want, got)
+		}
+		if markets[0].TotalTrades != 1 {
+			t.Errorf("%s: expected BTC stats with 1 trade, got %+v", tc.target, markets[0])
+		}
+	}
+}
+
+// 建立含多個交易對的交易所，供並行下單測試與基準測試使用
+func newMultiSymbolExchange(symbols ...orderbook.Symbol) *Exchange {
+	ex := NewExchange()
+	for _, symbol := range symbols {
+		ex.OrderBooks[symbol] = orderbook.NewOrderBook(symbol)
+		if ex.phaseLocks[symbol] == nil {
+			ex.phaseLocks[symbol] = &sync.RWMutex{}
+		}
+	}
+	return ex
+}
+
+// 測試多交易對並行下單的正確性，分別直接下單及經由撮合池（需搭配 -race）
+func TestPlaceOrderConcurrentSymbols(t *testing.T) {
+	t.Run("mutex", func(t *testing.T) {
+		testPlaceOrderConcurrentSymbols(t, false)
+	})
+	t.Run("pool", func(t *testing.T) {
+		testPlaceOrderConcurrentSymbols(t, true)
+	})
+}
+
+func testPlaceOrderConcurrentSymbols(t *testing.T, usePool bool) {
+	symbols := []orderbook.Symbol{orderbook.ETH, "BTC", "SOL"}
+	ex := newMultiSymbolExchange(symbols...)
+	var pool *MatchingPool
+	if usePool {
+		pool = NewMatchingPool(ex, 64)
+		defer pool.Close()
+	}
+
+	const perSymbol = 200
+	var wg sync.WaitGroup
+	for _, symbol := range symbols {
+		for _, side := range []orderbook.OrderSide{orderbook.Bid, orderbook.Ask} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perSymbol; i++ {
+					ex.PlaceOrder(&orderbook.Order{
+						ID: fmt.Sprintf("%s_%v_%d", symbol, side, i), Symbol: symbol,
+						Side: side, Type: orderbook.Limit, Price: dec(100), Quantity: dec(1),
+					})
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, symbol := range symbols {
+		snap := ex.OrderBooks[symbol].FullSnapshot()
+		if pool != nil {
+			var err error
+			if snap, err = pool.Snapshot(symbol); err != nil {
+				t.Fatalf("%s: unexpected snapshot error %v", symbol, err)
+			}
+		}
+		if len(snap.Bids) != 0 || len(snap.Asks) != 0 {
+			t.Errorf("%s: expected every order matched, got %d bid / %d ask levels", symbol, len(snap.Bids), len(snap.Asks))
+		}
+		if n := len(ex.OrderBooks[symbol].Trades); n != perSymbol {
+			t.Errorf("%s: expected %d trades, got %d", symbol, perSymbol, n)
+		}
+	}
+
+	if _, err := ex.PlaceOrder(&orderbook.Order{ID: "x", Symbol: "DOGE", Type: orderbook.Limit, Price: dec(1), Quantity: dec(1)}); !errors.Is(err, orderbook.ErrUnknownSymbol) {
+		t.Errorf("expected ErrUnknownSymbol, got %v", err)
+	}
+}
+
+var benchSymbols = []orderbook.Symbol{orderbook.ETH, "BTC", "SOL", "XRP"}
+
+// 以交替方向的限價單在多個交易對上並行下單
+func benchmarkPlaceOrders(b *testing.B, place func(o *orderbook.Order)) {
+	var seq atomic.Uint64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := seq.Add(1)
+			side := orderbook.Bid
+			if n%2 == 0 {
+				side = orderbook.Ask
+			}
+			place(&orderbook.Order{
+				ID: fmt.Sprintf("o%d", n), Symbol: benchSymbols[n/2%uint64(len(benchSymbols))],
+				Side: side, Type: orderbook.Limit, Price: dec(100), Quantity: dec(1),
+			})
+		}
+	})
+}
+
+// 各 goroutine 直接呼叫訂單簿，靠訂單簿的鎖互斥
+func BenchmarkPlaceOrderMutex(b *testing.B) {
+	ex := newMultiSymbolExchange(benchSymbols[1:]...)
+	benchmarkPlaceOrders(b, func(o *orderbook.Order) { ex.PlaceOrder(o) })
+}
+
+// 經由撮合池：每個交易對由單一 goroutine 批次撮合
+func BenchmarkPlaceOrderPool(b *testing.B) {
+	ex := newMultiSymbolExchange(benchSymbols[1:]...)
+	pool := NewMatchingPool(ex, 1024)
+	defer pool.Close()
+	benchmarkPlaceOrders(b, func(o *orderbook.Order) { ex.PlaceOrder(o) })
+}
+
+// 測試撮合池關閉後：撮合池返回 ErrPoolClosed，交易所改為直接下單
+func TestMatchingPoolClose(t *testing.T) {
+	ex := NewExchange()
+	pool := NewMatchingPool(ex, 8)
+	if _, err := pool.Snapshot("DOGE"); !errors.Is(err, orderbook.ErrUnknownSymbol) {
+		t.Errorf("expected ErrUnknownSymbol, got %v", err)
+	}
+	pool.Close()
+	pool.Close()
+
+	o := &orderbook.Order{ID: "o1", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(100), Quantity: dec(1)}
+	if _, err := pool.PlaceOrder(o); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+	if _, err := ex.PlaceOrder(o); err != nil {
+		t.Fatalf("expected direct placement after Close, got %v", err)
+	}
+	if _, ok := ex.OrderBooks[orderbook.ETH].UnFilledOrders["o1"]; !ok {
+		t.Error("expected o1 resting after direct placement")
+	}
+}
+
+// 測試括號單：進場單成交後掛出止盈，止盈成交後止損被取消；止損觸發時止盈被取消
+func TestPlaceBracket(t *testing.T) {
+	limit := func(id string, side orderbook.OrderSide, price, qty orderbook.Decimal) orderbook.Order {
+		return orderbook.Order{ID: id, Symbol: orderbook.ETH, Side: side, Type: orderbook.Limit, Price: price, Quantity: qty}
+	}
+	place := func(ex *Exchange, o orderbook.Order) {
+		if _, err := ex.PlaceOrder(&o); err != nil {
+			t.Fatalf("%s: unexpected error %v", o.ID, err)
+		}
+	}
+
+	ex := NewExchange()
+	id, _, err := ex.PlaceBracket(
+		limit("entry", orderbook.Bid, dec(2000), dec(2)),
+		limit("tp", orderbook.Ask, dec(2100), dec(2)),
+		limit("sl", orderbook.Ask, dec(1900), dec(2)),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ex.OrderBooks[orderbook.ETH].Metrics().TotalAskOrders != 0 {
+		t.Fatalf("expected take-profit deferred until entry fills")
+	}
+
+	// 進場單部分成交後剩餘被取消：止盈按比例掛出 1.5
+	place(ex, limit("seller", orderbook.Ask, dec(2000), dec(1.5)))
+	ex.OrderBooks[orderbook.ETH].CancelOrder("entry")
+	ex.processBrackets()
+	status, _ := ex.Bracket(id)
+	if status.TakeProfit.Quantity != dec(1.5) || status.StopLoss.Quantity != dec(1.5) {
+		t.Fatalf("expected legs sized to 1.5, got tp %s sl %s", status.TakeProfit.Quantity, status.StopLoss.Quantity)
+	}
+	if q := ex.OrderBooks[orderbook.ETH].LevelQuantity(dec(2100), orderbook.Ask); q != dec(1.5) {
+		t.Fatalf("expected take-profit resting at 2100, got %s", q)
+	}
+
+	// 止盈成交，止損被取消
+	place(ex, limit("buyer", orderbook.Bid, dec(2100), dec(1.5)))
+	status, _ = ex.Bracket(id)
+	if !status.Done || status.TakeProfit.Status != orderbook.Filled || status.StopLoss.Status != orderbook.Cancelled {
+		t.Errorf("expected tp filled and sl cancelled, got %+v", status)
+	}
+	if len(ex.brackets) != 0 {
+		t.Errorf("expected finished bracket removed from active set, got %d", len(ex.brackets))
+	}
+
+	// 另一組：價格跌破止損價，止損以市價送出並取消止盈
+	ex = NewExchange()
+	id, _, _ = ex.PlaceBracket(
+		limit("entry", orderbook.Bid, dec(2000), dec(1)),
+		limit("tp", orderbook.Ask, dec(2100), dec(1)),
+		limit("sl", orderbook.Ask, dec(1900), dec(1)),
+	)
+	place(ex, limit("seller", orderbook.Ask, dec(2000), dec(1)))
+	place(ex, limit("support", orderbook.Bid, dec(1850), dec(5)))
+	place(ex, limit("dump", orderbook.Ask, dec(1850), dec(1)))
+
+	status, _ = ex.Bracket(id)
+	if !status.Done || status.TakeProfit.Status != orderbook.Cancelled {
+		t.Errorf("expected tp cancelled after stop triggered, got %+v", status.TakeProfit)
+	}
+	if status.StopLoss.FilledQuantity != dec(1) {
+		t.Errorf("expected stop-loss market order filled, got %s", status.StopLoss.FilledQuantity)
+	}
+}
+
+// 測試下單請求缺少必填欄位時返回 400 及對應欄位
+func TestPlaceOrderRequestValidation(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"empty body", ``, ""},
+		{"empty object", `{}`, "Symbol"},
+		{"missing symbol", `{"Type":0,"Side":0,"Price":2000,"Quantity":1}`, "Symbol"},
+		{"missing quantity", `{"Symbol":"ETH","Type":0,"Side":0,"Price":2000}`, "Quantity"},
+		{"negative quantity", `{"Symbol":"ETH","Type":0,"Side":0,"Price":2000,"Quantity":-1}`, "Quantity"},
+		{"limit without price", `{"Symbol":"ETH","Type":0,"Side":0,"Quantity":1}`, "Price"},
+	}
+	for _, tc := range cases {
+		ex := NewExchange()
+		rec, _ := postOrder(t, ex, tc.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", tc.name, rec.Code)
+			continue
+		}
+		var body map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if body["field"] != tc.field {
+			t.Errorf("%s: expected field %q, got %q (%s)", tc.name, tc.field, body["field"], body["msg"])
+		}
+		if n := ex.OrderBooks[orderbook.ETH].Stats().TotalResting; n != 0 {
+			t.Errorf("%s: expected nothing resting, got %d orders", tc.name, n)
+		}
+	}
+
+	// 市價單不需要價格
+	ex := NewExchange()
+	ex.OrderBooks[orderbook.ETH].PlaceOrder(&orderbook.Order{ID: "ask", Side: orderbook.Ask, Type: orderbook.Limit, Price: dec(2000), Quantity: dec(1)})
+	if rec, _ := postOrder(t, ex, `{"Symbol":"ETH","Type":1,"Side":0,"Quantity":1}`); rec.Code != http.StatusOK {
+		t.Errorf("expected market order without price accepted, got %d", rec.Code)
+	}
+}
+
+func TestDetectArbitrage(t *testing.T) {
+	ex := newMultiSymbolExchange("BTC-SPOT", "BTC-ALT")
+	place := func(symbol orderbook.Symbol, id string, side orderbook.OrderSide, price orderbook.Decimal) {
+		ex.OrderBooks[symbol].PlaceOrder(&orderbook.Order{ID: id, Symbol: symbol, Side: side, Type: orderbook.Limit, Price: price, Quantity: dec(1)})
+	}
+	place("BTC-SPOT", "s_bid", orderbook.Bid, dec(99))
+	place("BTC-SPOT", "s_ask", orderbook.Ask, dec(100))
+	place("BTC-ALT", "a_bid", orderbook.Bid, dec(101))
+	place("BTC-ALT", "a_ask", orderbook.Ask, dec(102))
+
+	arb, ok, err := ex.DetectArbitrage("BTC-SPOT", "BTC-ALT")
+	if err != nil || !ok {
+		t.Fatalf("expected arbitrage, got ok=%v err=%v", ok, err)
+	}
+	want := Arbitrage{BuySymbol: "BTC-SPOT", SellSymbol: "BTC-ALT", BuyPrice: dec(100), SellPrice: dec(101), Spread: dec(1), SpreadBps: 100}
+	if arb != want {
+		t.Errorf("expected %+v, got %+v", want, arb)
+	}
+	// 參數順序不影響結果
+	if arb, ok, _ := ex.DetectArbitrage("BTC-ALT", "BTC-SPOT"); !ok || arb != want {
+		t.Errorf("expected same arbitrage with swapped symbols, got %+v %v", arb, ok)
+	}
+
+	ex.OrderBooks["BTC-ALT"].CancelOrder("a_bid")
+	if _, ok, _ := ex.DetectArbitrage("BTC-SPOT", "BTC-ALT"); ok {
+		t.Errorf("expected no arbitrage after removing crossed bid")
+	}
+	if _, _, err := ex.DetectArbitrage("BTC-SPOT", "NOPE"); !errors.Is(err, orderbook.ErrUnknownSymbol) {
+		t.Errorf("expected ErrUnknownSymbol, got %v", err)
+	}
+}
+
+func TestCheckQuotingObligation(t *testing.T) {
+	ex := newMultiSymbolExchange("BTC")
+	ob := ex.OrderBooks["BTC"]
+	place := func(id, user string, side orderbook.OrderSide, price orderbook.Decimal) {
+		ob.PlaceOrder(&orderbook.Order{ID: id, UserID: user, Symbol: "BTC", Side: side, Type: orderbook.Limit, Price: price, Quantity: dec(1)})
+	}
+	// 其他用戶的更優報價不影響檢查
+	place("o_bid", "other", orderbook.Bid, dec(99.95))
+	place("o_ask", "other", orderbook.Ask, dec(100.05))
+	place("mm_bid", "mm", orderbook.Bid, dec(99.9))
+	place("mm_bid2", "mm", orderbook.Bid, dec(99.5))
+	place("mm_ask", "mm", orderbook.Ask, dec(100.1))
+	place("wide_bid", "wide", orderbook.Bid, dec(98))
+	place("wide_ask", "wide", orderbook.Ask, dec(102))
+	place("one_bid", "onesided", orderbook.Bid, dec(99.9))
+
+	ok, detail := ex.CheckQuotingObligation("mm", "BTC", 25)
+	if !ok || detail.BestBid != dec(99.9) || detail.BestAsk != dec(100.1) || math.Abs(detail.SpreadBps-20) > 1e-9 {
+		t.Errorf("expected compliant 20bps quote, got %v %+v", ok, detail)
+	}
+	if ok, detail := ex.CheckQuotingObligation("wide", "BTC", 25); ok || detail.Reason != "spread too wide" || math.Abs(detail.SpreadBps-400) > 1e-9 {
+		t.Errorf("expected too-wide quote flagged, got %v %+v", ok, detail)
+	}
+	if ok, detail := ex.CheckQuotingObligation("onesided", "BTC", 25); ok || detail.Reason != "missing ask" {
+		t.Errorf("expected one-sided quote flagged, got %v %+v", ok, detail)
+	}
+	if ok, detail := ex.CheckQuotingObligation("nobody", "BTC", 25); ok || detail.Reason != "no quotes" {
+		t.Errorf("expected absent quotes flagged, got %v %+v", ok, detail)
+	}
+}
+
+// 測試交易對狀態變更紀錄：暫停、恢復及階段切換按順序記錄原因與時間
+func TestSymbolStateHistory(t *testing.T) {
+	clock := orderbook.NewManualClock(time.Unix(1700000000, 0))
+	ex := newExchange(clock)
+	ob := ex.OrderBooks[orderbook.ETH]
+
+	ob.Halt("circuit breaker")
+	clock.Advance(time.Minute)
+	ob.Resume()
+	ob.Resume() // 狀態未改變，不記錄
+	clock.Advance(time.Minute)
+	ex.SetPhase(orderbook.ETH, Closed)
+
+	history := ex.SymbolStateHistory(orderbook.ETH)
+	want := []StateTransition{
+		{Kind: BookStateTransition, From: "正常交易", To: "暫停交易", Reason: "circuit breaker", Timestamp: time.Unix(1700000000, 0)},
+		{Kind: BookStateTransition, From: "暫停交易", To: "正常交易", Reason: "resumed", Timestamp: time.Unix(1700000060, 0)},
+		{Kind: PhaseTransition, From: "連續競價", To: "休市", Reason: "market closed", Timestamp: time.Unix(1700000120, 0)},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("expected %d transitions, got %+v", len(want), history)
+	}
+	for i := range want {
+		got := history[i]
+		if got.Kind != want[i].Kind || got.From != want[i].From || got.To != want[i].To ||
+			got.Reason != want[i].Reason || !got.Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("transition %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+}
+
+// 測試下單檢查流程：按順序執行、遇到第一個錯誤即拒絕，交易對專屬檢查只對該交易對生效
+func TestValidatorPipeline(t *testing.T) {
+	ex := newMultiSymbolExchange("BTC")
+	var calls []string
+	trace := func(name string, v Validator) Validator {
+		return func(o *orderbook.Order) error {
+			calls = append(calls, name)
+			return v(o)
+		}
+	}
+	ex.AddValidator(trace("tick", TickSizeValidator(dec(0.5))))
+	ex.AddValidator(trace("lot", LotSizeValidator(dec(0.1))))
+	ex.AddSymbolValidator("BTC", trace("notional", MinNotionalValidator(dec(100))))
+
+	place := func(id string, symbol orderbook.Symbol, price, qty orderbook.Decimal) (*orderbook.Order, error) {
+		o := &orderbook.Order{ID: id, Symbol: symbol, Side: orderbook.Bid, Type: orderbook.Limit, Price: price, Quantity: qty}
+		_, err := ex.PlaceOrder(o)
+		return o, err
+	}
+
+	cases := []struct {
+		name   string
+		symbol orderbook.Symbol
+		price  orderbook.Decimal
+		qty    orderbook.Decimal
+		want   error
+		called []string
+	}{
+		{"off tick", "BTC", dec(100.3), dec(1), ErrPriceNotOnTick, []string{"tick"}},
+		{"off lot", "BTC", dec(100.5), dec(1.05), ErrQuantityNotOnLot, []string{"tick", "lot"}},
+		{"small notional", "BTC", dec(100), dec(0.5), ErrNotionalTooSmall, []string{"tick", "lot", "notional"}},
+		{"valid", "BTC", dec(100), dec(2), nil, []string{"tick", "lot", "notional"}},
+		{"other symbol skips notional", orderbook.ETH, dec(100), dec(0.5), nil, []string{"tick", "lot"}},
+	}
+	for i, tc := range cases {
+		calls = nil
+		o, err := place(fmt.Sprintf("o%d", i), tc.symbol, tc.price, tc.qty)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tc.called) {
+			t.Errorf("%s: expected validators %v to run, got %v", tc.name, tc.called, calls)
+		}
+		if tc.want != nil {
+			if o.Status != orderbook.Cancelled || o.CancelReason != orderbook.Rejected {
+				t.Errorf("%s: expected order rejected, got %s", tc.name, orderbook.GetStatusName(o.Status))
+			}
+			if _, ok := ex.OrderBooks[tc.symbol].UnFilledOrders[o.ID]; ok {
+				t.Errorf("%s: rejected order reached the book", tc.name)
+			}
+			if code := httpStatusFor(err); code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", tc.name, code)
+			}
+		}
+	}
+}
+
+// 測試 tick 或 lot 設為 0 時不做檢查，而不是除以 0 導致 panic
+func TestZeroTickLotValidators(t *testing.T) {
+	o := &orderbook.Order{ID: "o", Symbol: orderbook.ETH, Side: orderbook.Bid, Type: orderbook.Limit, Price: dec(100.3), Quantity: dec(1.05)}
+	for name, v := range map[string]Validator{"tick": TickSizeValidator(0), "lot": LotSizeValidator(0)} {
+		if err := v(o); err != nil {
+			t.Errorf("%s: expected zero step to disable the check, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// 有掛單或最近 24 小時有成交的訂單簿視為活躍
+func isActive(m orderbook.BookMetrics) bool {
+	return m.TotalResting > 0 || m.Volume24h > 0
+}
+
+// ActiveSymbols 返回有掛單或最近有成交的交易對（按名稱排序）
+func (ex *Exchange) ActiveSymbols() []orderbook.Symbol {
+	active := make([]orderbook.Symbol, 0)
+	for _, symbol := range ex.sortedSymbols() {
+		if isActive(ex.OrderBooks[symbol].Metrics()) {
+			active = append(active, symbol)
+		}
+	}
+	return active
+}
+
+type MarketSummary struct {
+	Symbol orderbook.Symbol
+	Active bool
+	orderbook.BookMetrics
+}
+
+// GET /markets 返回活躍交易對及其基本統計；?include_idle=true 時一併列出閒置交易對
+func (ex *Exchange) handleGetMarkets(ctx echo.Context) error {
+	includeIdle := false
+	if raw := ctx.QueryParam("include_idle"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"msg": "invalid include_idle"})
+		}
+		includeIdle = v
+	}
+
+	markets := make([]MarketSummary, 0)
+	for _, symbol := range ex.sortedSymbols() {
+		m := ex.OrderBooks[symbol].Metrics()
+		active := isActive(m)
+		if active || includeIdle {
+			markets = append(markets, MarketSummary{Symbol: symbol, Active: active, BookMetrics: m})
+		}
+	}
+	return ctx.JSON(http.StatusOK, markets)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+	"github.com/labstack/echo/v4"
+)
+
+// 按交易對名稱排序，確保輸出穩定
+func (ex *Exchange) sortedSymbols() []orderbook.Symbol {
+	symbols := make([]orderbook.Symbol, 0, len(ex.OrderBooks))
+	for symbol := range ex.OrderBooks {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+	return symbols
+}
+
+// GET /metrics 以 Prometheus 文字格式輸出指標
+func (ex *Exchange) handleMetrics(ctx echo.Context) error {
+	var b strings.Builder
+
+	b.WriteString("# TYPE orderbook_replication_lag gauge\n")
+	for _, symbol := range ex.sortedSymbols() {
+		fmt.Fprintf(&b, "orderbook_replication_lag{symbol=%q} %d\n", symbol, ex.OrderBooks[symbol].ReplicationLag())
+	}
+
+	b.WriteString("# TYPE orderbook_cancel_level_size_max gauge\n")
+	for _, symbol := range ex.sortedSymbols() {
+		fmt.Fprintf(&b, "orderbook_cancel_level_size_max{symbol=%q} %d\n", symbol, ex.OrderBooks[symbol].CancelLevelStats().MaxLevelSize)
+	}
+	b.WriteString("# TYPE orderbook_cancel_level_size_avg gauge\n")
+	for _, symbol := range ex.sortedSymbols() {
+		fmt.Fprintf(&b, "orderbook_cancel_level_size_avg{symbol=%q} %g\n", symbol, ex.OrderBooks[symbol].CancelLevelStats().AvgLevelSize)
+	}
+
+	return ctx.String(http.StatusOK, b.String())
+}
+
+// MetricsSnapshot 返回每個交易對的指標快照，每本訂單簿各自在一次加鎖內取得
+func (ex *Exchange) MetricsSnapshot() map[orderbook.Symbol]orderbook.BookMetrics {
+	snapshot := make(map[orderbook.Symbol]orderbook.BookMetrics, len(ex.OrderBooks))
+	for symbol, ob := range ex.OrderBooks {
+		snapshot[symbol] = ob.Metrics()
+	}
+	return snapshot
+}
+
+// GET /metrics/json 以 JSON 輸出各交易對的指標快照，供儀表板定期拉取
+func (ex *Exchange) handleMetricsJSON(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, ex.MetricsSnapshot())
+}
+
+type ReplicationResponse struct {
+	Symbol          orderbook.Symbol
+	PrimarySequence uint64
+	AppliedSequence uint64
+	Lag             uint64
+}
+
+// GET /replication/:symbol 返回備援複製進度
+func (ex *Exchange) handleGetReplication(ctx echo.Context) error {
+	symbol := orderbook.Symbol(ctx.Param("symbol"))
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return errorResponse(ctx, orderbook.ErrUnknownSymbol)
+	}
+
+	primary, applied, lag := ob.ReplicationStatus()
+	return ctx.JSON(http.StatusOK, ReplicationResponse{
+		Symbol:          symbol,
+		PrimarySequence: primary,
+		AppliedSequence: applied,
+		Lag:             lag,
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import "github.com/clary-work01/crypto_exchange/orderbook"
+
+// QuotingObligation 做市商報價義務的檢查結果
+type QuotingObligation struct {
+	BestBid   orderbook.Decimal // 該用戶的最高買價，沒有買單時為 0
+	BestAsk   orderbook.Decimal // 該用戶的最低賣價，沒有賣單時為 0
+	SpreadBps float64           // 以雙邊報價中間價計算的價差基點，單邊報價時為 0
+	Reason    string            // 不符合時的原因
+}
+
+// CheckQuotingObligation 檢查做市商是否在交易對上維持雙邊報價，且價差不超過 maxSpreadBps；
+// 只讀取該用戶自己的最佳買賣價，不合規時 Reason 說明原因
+func (ex *Exchange) CheckQuotingObligation(userID string, symbol orderbook.Symbol, maxSpreadBps float64) (bool, QuotingObligation) {
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return false, QuotingObligation{Reason: orderbook.ErrUnknownSymbol.Error()}
+	}
+
+	bid, ask, bidOK, askOK := ob.UserBestBidAsk(userID)
+	detail := QuotingObligation{BestBid: bid, BestAsk: ask}
+	switch {
+	case !bidOK && !askOK:
+		detail.Reason = "no quotes"
+		return false, detail
+	case !bidOK:
+		detail.Reason = "missing bid"
+		return false, detail
+	case !askOK:
+		detail.Reason = "missing ask"
+		return false, detail
+	}
+
+	detail.SpreadBps = (ask - bid).Float64() / ((ask + bid).Float64() / 2) * 10000
+	if detail.SpreadBps > maxSpreadBps {
+		detail.Reason = "spread too wide"
+		return false, detail
+	}
+	return true, detail
+}
@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// RunOpeningAuctionTimer 每隔 interval 檢查各訂單簿的開盤集合競價，時間已到但沒有新訂單觸發時完成競價，直到 stop 被關閉
+func (ex *Exchange) RunOpeningAuctionTimer(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ex.checkOpeningAuctions()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 完成各交易對已到期的開盤集合競價；持有階段切換讀鎖，與下單相同不會和階段切換交錯
+func (ex *Exchange) checkOpeningAuctions() {
+	for symbol, ob := range ex.OrderBooks {
+		lock := ex.phaseLocks[symbol]
+		lock.RLock()
+		ob.CheckOpeningAuction()
+		lock.RUnlock()
+	}
+}
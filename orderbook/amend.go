@@ -0,0 +1,112 @@
+package orderbook
+
+import "container/heap"
+
+// AmendOrder 修改掛單的價格與總數量（含已成交部分）。
+// 價格不變且只減少數量（或增幅低於 AmendPriorityResetPct）時原地修改，保留時間優先；
+// 顯著增加數量或改價則失去優先權，以新條件重新進場（可能立即撮合），返回因此產生的成交。
+// 新條件在撮合前就會被拒絕（如排空中、只掛單會吃單、鎖定市場）時返回錯誤，原掛單保持不變
+func (ob *OrderBook) AmendOrder(orderID string, price, quantity Decimal) ([]*Trade, error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	o, ok := ob.UnFilledOrders[orderID]
+	if !ok {
+		return nil, &OrderError{OrderID: orderID, Err: ErrOrderNotFound}
+	}
+	if quantity <= o.FilledQuantity {
+		return nil, &OrderError{OrderID: orderID, Err: ErrInvalidQuantity}
+	}
+	if price <= 0 && !ob.AllowNegativePrice {
+		return nil, &OrderError{OrderID: orderID, Err: ErrInvalidPrice}
+	}
+
+	level := ob.BidLevels[o.Price]
+	if o.Side == Ask {
+		level = ob.AskLevels[o.Price]
+	}
+
+	if price == o.Price && (quantity <= o.Quantity || ob.negligibleIncrease(o.Quantity, quantity)) {
+		// 減少數量或微幅增加：原地修改，隊列位置不變
+		level.Quantity -= o.Quantity - quantity
+		level.touch()
+		o.Quantity = quantity
+		if o.IsIceberg() && o.visible > o.Remaining() {
+			o.visible = o.Remaining()
+		}
+		ob.sequence++
+		ob.afterChange()
+		ob.publishEvent(EventOrderUpdate, nil, o)
+		return nil, nil
+	}
+
+	// 顯著增加數量或改價：移出原層級後以新條件重新進場；先以新條件驗證，被拒絕時放回原位置
+	var next *Order
+	if e := level.nodes[orderID].Next(); e != nil {
+		next = e.Value.(*Order)
+	}
+	origPrice, origQuantity := o.Price, o.Quantity
+	level.RemoveOrder(orderID)
+	delete(ob.UnFilledOrders, orderID)
+
+	o.Price, o.Quantity = price, quantity
+	if err := ob.amendError(o); err != nil {
+		o.Price, o.Quantity = origPrice, origQuantity
+		ob.restoreResting(o, level, next)
+		return nil, &OrderError{OrderID: orderID, Err: err}
+	}
+	ob.cleanupPriceLevel(level, o.Side == Bid)
+
+	filled := o.FilledQuantity
+	trades, err := ob.placeOrder(o)
+	if o.Status == Pending && filled > 0 {
+		o.Status = Partial
+	}
+	trades = append(trades, ob.resolveCrosses()...)
+	return trades, err
+}
+
+// 以新條件重新進場時在撮合前就會被拒絕的原因，與 placeOrder 的檢查一致；
+// 訂單需已移出訂單簿（呼叫者需持有鎖）
+func (ob *OrderBook) amendError(o *Order) error {
+	if err := ob.validateOrder(o); err != nil {
+		return err
+	}
+	if ob.AuctionMode {
+		return nil
+	}
+	takes := ob.wouldTake(o)
+	if o.PostOnly && takes {
+		return ErrPostOnlyWouldTake
+	}
+	if o.AONLevel && takes && !ob.aonLevelSatisfied(o) {
+		return ErrInsufficientLiquidity
+	}
+	if !takes {
+		return ob.restingError(o)
+	}
+	return nil
+}
+
+// 將移出的掛單放回原層級中 next 之前，保留時間優先；層級已因清空被移除時重建（呼叫者需持有鎖）
+func (ob *OrderBook) restoreResting(o *Order, level *PriceLevel, next *Order) {
+	ob.UnFilledOrders[o.ID] = o
+	levels, side := ob.BidLevels, heap.Interface(ob.Bids)
+	if o.Side == Ask {
+		levels, side = ob.AskLevels, ob.Asks
+	}
+	if levels[o.Price] != level {
+		level = ob.newLevel(o.Side, o.Price)
+		levels[o.Price] = level
+		heap.Push(side, level)
+	}
+	level.insertBefore(o, next)
+}
+
+// 數量增幅是否低於 AmendPriorityResetPct（相對原數量的百分比），視為可忽略而保留時間優先
+func (ob *OrderBook) negligibleIncrease(from, to Decimal) bool {
+	if ob.AmendPriorityResetPct <= 0 {
+		return false
+	}
+	return (to-from).Float64()/from.Float64()*100 < ob.AmendPriorityResetPct
+}
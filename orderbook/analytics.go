@@ -0,0 +1,470 @@
+package orderbook
+
+import (
+	"math"
+	"time"
+)
+
+// 訂單受理時的中間價及方向
+type slippageRef struct {
+	mid  Decimal
+	side OrderSide
+}
+
+// 保留受理時中間價的訂單數上限，超過時移除最早受理的紀錄
+const submissionMidLimit = 10000
+
+// 記錄訂單受理時的中間價；單邊或空簿沒有中間價，不記錄（呼叫者需持有鎖）
+func (ob *OrderBook) recordSubmissionMid(o *Order) {
+	ob.pruneStaleTops()
+	if ob.Bids.Len() == 0 || ob.Asks.Len() == 0 {
+		return
+	}
+	mid := (ob.Bids.Peek().Price + ob.Asks.Peek().Price) / 2
+	ob.submissionMid[o.ID] = slippageRef{mid: mid, side: o.Side}
+	ob.submissionOrder = append(ob.submissionOrder, o.ID)
+	for len(ob.submissionMid) > submissionMidLimit {
+		delete(ob.submissionMid, ob.submissionOrder[0])
+		ob.submissionOrder = ob.submissionOrder[1:]
+	}
+	// 已移除的紀錄仍留在隊列中，累積過多時壓縮
+	if len(ob.submissionOrder) > 2*submissionMidLimit {
+		live := make([]string, 0, len(ob.submissionMid))
+		for _, id := range ob.submissionOrder {
+			if _, ok := ob.submissionMid[id]; ok {
+				live = append(live, id)
+			}
+		}
+		ob.submissionOrder = live
+	}
+}
+
+// 訂單沒有任何成交就結束（取消或拒絕）時移除受理時的中間價，滑價無從計算；
+// 有成交的訂單保留紀錄供 OrderSlippage 查詢（呼叫者需持有鎖）
+func (ob *OrderBook) forgetSubmissionMid(o *Order) {
+	if o.Status == Cancelled && o.FilledQuantity == 0 {
+		delete(ob.submissionMid, o.ID)
+	}
+}
+
+// 成交的參考中間價：該筆成交套用前訂單簿最佳買賣價的中間值，同一吃單掃過多個層級時逐筆更新；
+// 單邊、空簿或交叉的訂單簿（如集合競價）沒有中間價，返回 0（呼叫者需持有鎖）
+func (ob *OrderBook) fillMid() Decimal {
+	ob.pruneStaleTops()
+	if ob.Bids.Len() == 0 || ob.Asks.Len() == 0 {
+		return 0
+	}
+	bid, ask := ob.Bids.Peek().Price, ob.Asks.Peek().Price
+	if bid > ask {
+		return 0
+	}
+	return (bid + ask) / 2
+}
+
+// RealizedSpreadCapture 估計用戶作為 maker 在最近 window 內賺取的價差（報價幣金額）：
+// 每筆被動成交按成交價與當時中間價的差計算，買在中間價之下、賣在中間價之上為正。
+// 沒有中間價紀錄的成交（如集合競價）不計入
+func (ob *OrderBook) RealizedSpreadCapture(userID string, window time.Duration) Decimal {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	cutoff := ob.Clock.Now().Add(-window)
+	var capture Decimal
+	for i := len(ob.Trades) - 1; i >= 0; i-- {
+		t := ob.Trades[i]
+		if t.Timestamp.Before(cutoff) {
+			break
+		}
+		if t.Mid == 0 {
+			continue
+		}
+		switch {
+		case t.AggressorSide == Ask && t.BuyUserID == userID:
+			capture += (t.Mid - t.Price).Mul(t.Quantity)
+		case t.AggressorSide == Bid && t.SellUserID == userID:
+			capture += (t.Price - t.Mid).Mul(t.Quantity)
+		}
+	}
+	return capture
+}
+
+// OrderSlippage 計算訂單成交均價相對受理時中間價的滑價（bps），正值表示比中間價差。
+// 受理時沒有雙邊報價、沒有成交就已取消或紀錄已被淘汰的訂單返回 ErrOrderNotFound，
+// 仍在掛單但沒有成交的訂單返回 ErrNoFills
+func (ob *OrderBook) OrderSlippage(orderID string) (float64, error) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	ref, ok := ob.submissionMid[orderID]
+	if !ok {
+		return 0, &OrderError{OrderID: orderID, Err: ErrOrderNotFound}
+	}
+
+	var quantity, notional Decimal
+	for _, t := range ob.tradesByOrder[orderID] {
+		quantity += t.Quantity
+		notional += t.Price.Mul(t.Quantity)
+	}
+	if quantity == 0 {
+		return 0, &OrderError{OrderID: orderID, Err: ErrNoFills}
+	}
+
+	vwap := notional.Float64() / quantity.Float64()
+	mid := ref.mid.Float64()
+	slippage := (vwap - mid) / mid * 10000
+	if ref.side == Ask {
+		slippage = -slippage
+	}
+	return slippage, nil
+}
+
+// CostToMove 計算將對手最佳價推動 ticks 個最小價位所需的成交量與金額
+// side 為主動方向：Bid 表示買入推高最佳賣價，Ask 表示賣出壓低最佳買價。
+// 需吃掉所有價格在目標價之前的對手層級；若對手盤不足則返回全部對手盤的量與金額
+func (ob *OrderBook) CostToMove(side OrderSide, ticks int, tickSize Decimal) (quantity, notional Decimal) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if ticks <= 0 || tickSize <= 0 {
+		return 0, 0
+	}
+
+	opposite := Ask
+	if side == Ask {
+		opposite = Bid
+	}
+	levels := ob.sortedLevels(opposite)
+	if len(levels) == 0 {
+		return 0, 0
+	}
+
+	distance := Decimal(ticks) * tickSize
+	target := levels[0].Price + distance
+	if opposite == Bid {
+		target = levels[0].Price - distance
+	}
+
+	for _, level := range levels {
+		if (opposite == Ask && level.Price >= target) || (opposite == Bid && level.Price <= target) {
+			break
+		}
+		quantity += level.Quantity
+		notional += level.Price.Mul(level.Quantity)
+	}
+	return
+}
+
+// 流動性評分計入的價格範圍（距中間價的基點）
+const liquidityBandBps = 50
+
+// LiquidityScore 流動性評分 = 中間價上下 50bps 內的雙邊掛單量 / 價差（bps，最小以 1bps 計）
+// 越深越窄分數越高；空簿或單邊簿返回 0
+func (ob *OrderBook) LiquidityScore() float64 {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	bids, asks := ob.sortedLevels(Bid), ob.sortedLevels(Ask)
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0
+	}
+	bestBid, bestAsk := bids[0].Price, asks[0].Price
+	mid := (bestBid + bestAsk) / 2
+	if mid <= 0 {
+		return 0
+	}
+
+	band := mid * liquidityBandBps / 10000
+	var quantity Decimal
+	for _, level := range bids {
+		if mid-level.Price > band {
+			break
+		}
+		quantity += level.Quantity
+	}
+	for _, level := range asks {
+		if level.Price-mid > band {
+			break
+		}
+		quantity += level.Quantity
+	}
+
+	spreadBps := (bestAsk - bestBid).Float64() / mid.Float64() * 10000
+	return quantity.Float64() / max(spreadBps, 1)
+}
+
+// FairValue 以多檔深度加權的微觀價格（microprice）估計公允價值：
+//
+//	Qb = Σ 買單量_i × w_i，Qa = Σ 賣單量_i × w_i（各取前 levels 檔）
+//	w_i = 1 / (1 + 距中間價 / 半價差)，最佳價位的權重為 0.5，越遠權重越低
+//	FairValue = (最佳買價 × Qa + 最佳賣價 × Qb) / (Qa + Qb)
+//
+// 結果介於最佳買價與最佳賣價之間；買方深度較重時偏向賣價（買壓推高價格），反之偏向買價。
+// 單邊簿返回該邊最佳價，空簿返回 0
+func (ob *OrderBook) FairValue(levels int) float64 {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	bids, asks := ob.sortedLevels(Bid), ob.sortedLevels(Ask)
+	switch {
+	case len(bids) == 0 && len(asks) == 0:
+		return 0
+	case len(asks) == 0:
+		return bids[0].Price.Float64()
+	case len(bids) == 0:
+		return asks[0].Price.Float64()
+	}
+
+	bestBid, bestAsk := bids[0].Price.Float64(), asks[0].Price.Float64()
+	mid := (bestBid + bestAsk) / 2
+	halfSpread := (bestAsk - bestBid) / 2
+	if halfSpread <= 0 {
+		return mid
+	}
+
+	weighted := func(side []*PriceLevel) float64 {
+		if levels > 0 && len(side) > levels {
+			side = side[:levels]
+		}
+		total := 0.0
+		for _, level := range side {
+			distance := math.Abs(level.Price.Float64() - mid)
+			total += level.Quantity.Float64() / (1 + distance/halfSpread)
+		}
+		return total
+	}
+	qb, qa := weighted(bids), weighted(asks)
+	if qa+qb == 0 {
+		return mid
+	}
+	return (bestBid*qa + bestAsk*qb) / (qa + qb)
+}
+
+// VolumeInWindow 統計最近 d 時間內（以 Clock 為準）的成交量：baseVolume 為成交數量，quoteVolume 為成交金額
+func (ob *OrderBook) VolumeInWindow(d time.Duration) (baseVolume, quoteVolume Decimal) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.volumeInWindow(d)
+}
+
+// 統計時間窗口內的成交量（呼叫者需持有鎖）
+func (ob *OrderBook) volumeInWindow(d time.Duration) (baseVolume, quoteVolume Decimal) {
+	cutoff := ob.Clock.Now().Add(-d)
+	// 成交按時間順序追加，從最新往回找
+	for i := len(ob.Trades) - 1; i >= 0; i-- {
+		t := ob.Trades[i]
+		if t.Timestamp.Before(cutoff) {
+			break
+		}
+		baseVolume += t.Quantity
+		quoteVolume += t.Price.Mul(t.Quantity)
+	}
+	return
+}
+
+// AggressorFlow 統計最近 window 內按主動方向區分的成交量（基礎幣），反映訂單流的買賣失衡；
+// 與掛單量的靜態失衡不同，這裡只看實際成交
+func (ob *OrderBook) AggressorFlow(window time.Duration) (buyInitiated, sellInitiated Decimal) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	cutoff := ob.Clock.Now().Add(-window)
+	for i := len(ob.Trades) - 1; i >= 0; i-- {
+		t := ob.Trades[i]
+		if t.Timestamp.Before(cutoff) {
+			break
+		}
+		if t.AggressorSide == Bid {
+			buyInitiated += t.Quantity
+		} else {
+			sellInitiated += t.Quantity
+		}
+	}
+	return
+}
+
+// FillProbabilityHint 使用的近期成交量統計窗口
+const fillHintWindow = time.Hour
+
+// FillProbabilityHint 粗略估計限價單被成交的機率（0~1），僅供下單參考：
+//
+//	需消耗量 = 同方向更優價格的掛單量 + 同價位排在前面的掛單量 + 訂單自身剩餘量
+//	機率 = 近一小時成交量 / (近一小時成交量 + 需消耗量)
+//
+// 已掛單的訂單按實際隊列位置計算，未掛單的訂單視為排在該價位隊尾；
+// 近一小時沒有成交時無從估計，返回中性值 0.5
+func (ob *OrderBook) FillProbabilityHint(o *Order) float64 {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	recent, _ := ob.volumeInWindow(fillHintWindow)
+	if recent <= 0 {
+		return 0.5
+	}
+
+	var ahead Decimal
+	for _, level := range ob.sortedLevels(o.Side) {
+		better := level.Price > o.Price
+		if o.Side == Ask {
+			better = level.Price < o.Price
+		}
+		if better {
+			ahead += level.Quantity
+			continue
+		}
+		if level.Price == o.Price {
+			for _, queued := range level.OrderList() {
+				if queued.ID == o.ID {
+					break
+				}
+				ahead += queued.Remaining()
+			}
+		}
+		break
+	}
+
+	need := ahead + o.Remaining()
+	return recent.Float64() / (recent + need).Float64()
+}
+
+// PreviewLimitOrder 模擬下一筆限價單而不改變訂單簿：返回會立即成交的部分（按撮合順序，
+// 以掛單方價格成交）、剩餘會掛單的數量及立即成交的均價（沒有成交時為 0）。
+// 與 FOK 檢查共用同一個撮合模擬，套用相同的成交條件（自成交防範、最小成交量、手數取整、
+// 冰山單分段等）；只掛單的訂單會吃單時、FOK 無法全部成交時沒有成交也不掛單，
+// IOC／FOK 及提前停止撮合的剩餘部分不掛單。掛單前的狀態檢查（如排空、鎖定市場）不在模擬範圍內
+func (ob *OrderBook) PreviewLimitOrder(o *Order) (immediateFills []Trade, restingQty Decimal, avgFillPrice float64) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	immediateFills = make([]Trade, 0)
+	if ob.AuctionMode {
+		// 集合競價期間只掛單不撮合
+		return immediateFills, o.Remaining(), 0
+	}
+	opposite := Ask
+	if o.Side == Ask {
+		opposite = Bid
+	}
+	if o.PostOnly {
+		if levels := ob.sortedLevels(opposite); len(levels) > 0 && ob.limitCrosses(o, levels[0].Price) {
+			return immediateFills, 0, 0
+		}
+	}
+
+	sim := ob.simulateTake(o)
+	if o.TimeInForce == FOK && sim.remaining > 0 {
+		return immediateFills, 0, 0
+	}
+	now := ob.Clock.Now()
+	var notional, filled Decimal
+	for _, f := range sim.fills {
+		fill := Trade{AggressorSide: o.Side, Price: f.price, Quantity: f.quantity, Timestamp: now}
+		if o.Side == Bid {
+			fill.BuyOrderId, fill.SellOrderId = o.ID, f.maker.ID
+			fill.BuyUserID, fill.SellUserID = o.UserID, f.maker.UserID
+		} else {
+			fill.BuyOrderId, fill.SellOrderId = f.maker.ID, o.ID
+			fill.BuyUserID, fill.SellUserID = f.maker.UserID, o.UserID
+		}
+		immediateFills = append(immediateFills, fill)
+		filled += f.quantity
+		notional += f.quantity.Mul(f.price)
+	}
+	if filled > 0 {
+		avgFillPrice = notional.Float64() / filled.Float64()
+	}
+	if o.TimeInForce == GTC && !sim.blocked && !ob.droppableRemainder(sim.remaining) {
+		restingQty = sim.remaining
+	}
+	return immediateFills, restingQty, avgFillPrice
+}
+
+// EstimateMarketImpact 估計以市價單按 side 方向成交 quantity 的成交均價與最差成交價，
+// 不改變訂單簿；對手盤不足時 ok 為 false
+func (ob *OrderBook) EstimateMarketImpact(side OrderSide, quantity Decimal) (avgPrice float64, worstPrice Decimal, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.estimateMarketImpact(side, quantity)
+}
+
+// 呼叫者需持有鎖
+func (ob *OrderBook) estimateMarketImpact(side OrderSide, quantity Decimal) (avgPrice float64, worstPrice Decimal, ok bool) {
+	if quantity <= 0 {
+		return 0, 0, false
+	}
+	opposite := Ask
+	if side == Ask {
+		opposite = Bid
+	}
+
+	remaining := quantity
+	var notional Decimal
+	for _, level := range ob.sortedLevels(opposite) {
+		fill := min(remaining, level.Quantity)
+		notional += fill.Mul(level.Price)
+		remaining -= fill
+		worstPrice = level.Price
+		if remaining <= 0 {
+			return notional.Float64() / quantity.Float64(), worstPrice, true
+		}
+	}
+	return 0, 0, false
+}
+
+// RoundTripCost 估計買入 quantity 後立即賣出（雙向跨越價差）的成本：
+// 買入均價、賣出均價，以及二者差額相對中間價的基點；任一方深度不足時 ok 為 false
+func (ob *OrderBook) RoundTripCost(quantity Decimal) (buyAvg, sellAvg, costBps float64, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	buyAvg, _, buyOK := ob.estimateMarketImpact(Bid, quantity)
+	sellAvg, _, sellOK := ob.estimateMarketImpact(Ask, quantity)
+	if !buyOK || !sellOK {
+		return 0, 0, 0, false
+	}
+
+	bestBid, bestAsk := ob.sortedLevels(Bid)[0].Price, ob.sortedLevels(Ask)[0].Price
+	mid := (bestBid + bestAsk).Float64() / 2
+	return buyAvg, sellAvg, (buyAvg - sellAvg) / mid * 10000, true
+}
+
+// NetPositionChange 統計用戶自 since 起（含）的成交所造成的淨部位變化：買入為正、賣出為負；
+// 自成交的買賣互相抵銷
+func (ob *OrderBook) NetPositionChange(userID string, since time.Time) Decimal {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	var net Decimal
+	for i := len(ob.Trades) - 1; i >= 0; i-- {
+		t := ob.Trades[i]
+		if t.Timestamp.Before(since) {
+			break
+		}
+		if t.BuyUserID == userID {
+			net += t.Quantity
+		}
+		if t.SellUserID == userID {
+			net -= t.Quantity
+		}
+	}
+	return net
+}
+
+// ExecutedNotional 統計自 since 起（含）由 side 方向主動成交的報價幣金額，用於比較買賣壓力
+func (ob *OrderBook) ExecutedNotional(side OrderSide, since time.Time) Decimal {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	var notional Decimal
+	for i := len(ob.Trades) - 1; i >= 0; i-- {
+		t := ob.Trades[i]
+		if t.Timestamp.Before(since) {
+			break
+		}
+		if t.AggressorSide == side {
+			notional += t.Price.Mul(t.Quantity)
+		}
+	}
+	return notional
+}
@@ -0,0 +1,139 @@
+package orderbook
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// 集合競價結果
+type AuctionResult struct {
+	Price    Decimal // 成交價，無成交時為 0
+	Quantity Decimal // 總成交量
+	Trades   []*Trade
+}
+
+// 計算集合競價成交價：選擇可成交量最大的價格；
+// 相同時選買賣量差最小者，再相同時選較低價格
+func (ob *OrderBook) auctionPrice() (price, volume Decimal) {
+	candidates := make([]Decimal, 0, len(ob.BidLevels)+len(ob.AskLevels))
+	for p, level := range ob.BidLevels {
+		if !level.isEmpty() {
+			candidates = append(candidates, p)
+		}
+	}
+	for p, level := range ob.AskLevels {
+		if !level.isEmpty() {
+			candidates = append(candidates, p)
+		}
+	}
+	slices.Sort(candidates)
+
+	bestImbalance := Decimal(math.MaxInt64)
+	for _, p := range candidates {
+		var demand, supply Decimal
+		for bp, level := range ob.BidLevels {
+			if bp >= p {
+				demand += level.Quantity
+			}
+		}
+		for ap, level := range ob.AskLevels {
+			if ap <= p {
+				supply += level.Quantity
+			}
+		}
+		executable := min(demand, supply)
+		imbalance := abs(demand - supply)
+		if executable > volume || (executable == volume && executable > 0 && imbalance < bestImbalance) {
+			price, volume, bestImbalance = p, executable, imbalance
+		}
+	}
+	return
+}
+
+// RunAuction 以單一價格撮合目前所有交叉的買賣單（買價 >= 成交價 >= 賣價），
+// 按價格優先、時間優先成交，未成交部分繼續掛單
+func (ob *OrderBook) RunAuction() AuctionResult {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	return ob.runAuction()
+}
+
+// 執行集合競價（呼叫者需持有鎖）
+func (ob *OrderBook) runAuction() AuctionResult {
+	price, volume := ob.auctionPrice()
+	result := AuctionResult{Trades: make([]*Trade, 0)}
+	if volume <= 0 {
+		return result
+	}
+	result.Price = price
+
+	for ob.Bids.Len() > 0 && ob.Asks.Len() > 0 {
+		bestBid, bestAsk := ob.Bids.Peek(), ob.Asks.Peek()
+		if bestBid.Price < price || bestAsk.Price > price {
+			break
+		}
+		buy, sell := bestBid.Front(), bestAsk.Front()
+
+		// 集合競價沒有真正的主動方，以較晚掛單的一方為主動方
+		aggressor := Bid
+		if sell.priority > buy.priority {
+			aggressor = Ask
+		}
+		trade := ob.matchOrders(buy, sell, price, aggressor)
+		if trade == nil {
+			break
+		}
+		ob.recordTrade(trade, buy, sell)
+		result.Trades = append(result.Trades, trade)
+		result.Quantity += trade.Quantity
+
+		ob.cleanupPriceLevel(bestBid, true)
+		ob.cleanupPriceLevel(bestAsk, false)
+	}
+	ob.runTriggeredStops()
+	ob.sequence++
+	ob.afterChange()
+	return result
+}
+
+// DrainOrders 移出所有掛單並按時間優先順序返回，訂單簿清空（成交紀錄保留）
+func (ob *OrderBook) DrainOrders() []*Order {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	orders := make([]*Order, 0, len(ob.UnFilledOrders))
+	for _, o := range ob.UnFilledOrders {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].priority < orders[j].priority })
+
+	bids := make(BidHeap, 0)
+	asks := make(AskHeap, 0)
+	ob.Bids, ob.Asks = &bids, &asks
+	ob.BidLevels = make(map[Decimal]*PriceLevel)
+	ob.AskLevels = make(map[Decimal]*PriceLevel)
+	ob.UnFilledOrders = make(map[string]*Order)
+	ob.pegged = make(map[string]*Order)
+	ob.sequence++
+	ob.afterChange()
+	return orders
+}
+
+// DrainStopOrders 移出所有尚未轉為限價單的停損單並返回：已被成交價觸發的（如集合競價成交）
+// 轉為限價單排在前面，其餘保持停損限價單，均按下單順序
+func (ob *OrderBook) DrainStopOrders() []*Order {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	orders := make([]*Order, 0, len(ob.triggeredStops)+len(ob.stopOrders))
+	for _, o := range ob.triggeredStops {
+		o.Type = Limit
+		orders = append(orders, o)
+	}
+	orders = append(orders, ob.stopOrders...)
+	ob.triggeredStops, ob.stopOrders = nil, nil
+	ob.sequence++
+	return orders
+}
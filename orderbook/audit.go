@@ -0,0 +1,55 @@
+package orderbook
+
+import (
+	"maps"
+	"time"
+)
+
+// 撮合稽核紀錄：每筆訂單撮合前後的最佳買賣價及產生的成交，用於爭議處理
+type AuditRecord struct {
+	OrderID   string
+	Side      OrderSide
+	Type      OrderType
+	Price     Decimal
+	Quantity  Decimal
+	Metadata  map[string]string `json:",omitempty"`
+	Status    OrderStatus       // 撮合後的訂單狀態
+	BidBefore Decimal
+	AskBefore Decimal
+	BidAfter  Decimal
+	AskAfter  Decimal
+	Trades    []Trade
+	Timestamp time.Time
+}
+
+// 稽核紀錄輸出
+type AuditSink interface {
+	Record(rec AuditRecord)
+}
+
+// 記錄撮合前狀態（呼叫者需持有鎖）
+func (ob *OrderBook) beginAudit(o *Order) *AuditRecord {
+	bid, ask, _ := ob.bestBidAsk()
+	return &AuditRecord{
+		OrderID:   o.ID,
+		Side:      o.Side,
+		Type:      o.Type,
+		Price:     o.Price,
+		Quantity:  o.Quantity,
+		Metadata:  maps.Clone(o.Metadata),
+		BidBefore: bid,
+		AskBefore: ask,
+		Timestamp: o.Timestamp,
+	}
+}
+
+// 記錄撮合後狀態並輸出（呼叫者需持有鎖）
+func (ob *OrderBook) finishAudit(rec *AuditRecord, o *Order, trades []*Trade) {
+	rec.BidAfter, rec.AskAfter, _ = ob.bestBidAsk()
+	rec.Status = o.Status
+	rec.Trades = make([]Trade, 0, len(trades))
+	for _, t := range trades {
+		rec.Trades = append(rec.Trades, *t)
+	}
+	ob.AuditSink.Record(*rec)
+}
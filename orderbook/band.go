@@ -0,0 +1,68 @@
+package orderbook
+
+import (
+	"fmt"
+	"time"
+)
+
+// 價格帶異常紀錄預設保留筆數
+const defaultBandBreachLimit = 1000
+
+// BandBreach 一筆偏離前一筆成交價過多的（擬）成交
+type BandBreach struct {
+	Price        Decimal
+	PrevPrice    Decimal
+	DeviationPct float64
+	Halted       bool // 是否因此暫停訂單簿（該筆成交未執行）
+	Timestamp    time.Time
+}
+
+// 以 price 成交是否通過價格帶檢查；偏離時記錄（最多保留 BandBreachLimit 筆），
+// 並在 HaltOnBandBreach 時暫停訂單簿（呼叫者需持有鎖）
+func (ob *OrderBook) tradeBandAllows(price Decimal) bool {
+	if ob.TradeBandPct <= 0 || len(ob.Trades) == 0 {
+		return true
+	}
+	prev := ob.Trades[len(ob.Trades)-1].Price
+	deviation, ok := ob.withinBand(price, prev)
+	if ok {
+		return true
+	}
+
+	ob.bandBreaches = append(ob.bandBreaches, BandBreach{
+		Price:        price,
+		PrevPrice:    prev,
+		DeviationPct: deviation,
+		Halted:       ob.HaltOnBandBreach,
+		Timestamp:    ob.Clock.Now(),
+	})
+	limit := ob.BandBreachLimit
+	if limit <= 0 {
+		limit = defaultBandBreachLimit
+	}
+	if len(ob.bandBreaches) > limit {
+		ob.bandBreaches = ob.bandBreaches[len(ob.bandBreaches)-limit:]
+	}
+	if ob.HaltOnBandBreach {
+		ob.transition(BookHalted, fmt.Sprintf("trade band breach: %s deviates %.2f%% from %s", price, deviation, prev))
+		return false
+	}
+	return true
+}
+
+// BandBreaches 返回保留的價格帶異常紀錄（由舊到新）
+func (ob *OrderBook) BandBreaches() []BandBreach {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return append([]BandBreach(nil), ob.bandBreaches...)
+}
+
+// 成交價相對 prev 的偏離百分比及是否在 TradeBandPct 之內；未設定或 prev 為 0 時視為在內，不記錄也不暫停
+func (ob *OrderBook) withinBand(price, prev Decimal) (deviation float64, ok bool) {
+	if ob.TradeBandPct <= 0 || prev == 0 {
+		return 0, true
+	}
+	deviation = abs(price-prev).Float64() / abs(prev).Float64() * 100
+	return deviation, deviation <= ob.TradeBandPct
+}
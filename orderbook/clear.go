@@ -0,0 +1,83 @@
+package orderbook
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// 成交紀錄歸檔輸出，用於在重置訂單簿時保留稽核資料
+type TradeArchive interface {
+	Archive(symbol Symbol, trades []Trade) error
+}
+
+// CancelAll 以指定原因取消所有掛單及尚未轉為限價單的停損單，每筆發布取消事件；
+// 返回被取消的訂單ID（掛單按ID排序在前，停損單按下單順序在後）
+func (ob *OrderBook) CancelAll(reason CancelReason) []string {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ids := ob.cancelAll(reason)
+	ob.afterChange()
+	return ids
+}
+
+// 取消所有掛單及停損單（呼叫者需持有鎖）
+func (ob *OrderBook) cancelAll(reason CancelReason) []string {
+	ids := make([]string, 0, len(ob.UnFilledOrders))
+	for id := range ob.UnFilledOrders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ob.cancelOrder(id, reason)
+	}
+	for _, queue := range []*[]*Order{&ob.stopOrders, &ob.triggeredStops} {
+		for len(*queue) > 0 {
+			id := (*queue)[0].ID
+			ob.cancelStop(id, reason)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Clear 重置訂單簿：取消所有掛單及尚未轉為限價單的停損單（含已觸發待撮合的），清空成交紀錄，
+// 並重置依附於掛單或成交紀錄的衍生狀態（開盤快照、掛鉤單、雙邊報價、滑價參考、最佳價暫留）。
+// 設定 TradeArchive 時先將成交紀錄按成交順序歸檔，歸檔失敗則不重置並返回錯誤
+func (ob *OrderBook) Clear() error {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if ob.TradeArchive != nil && len(ob.Trades) > 0 {
+		trades := make([]Trade, len(ob.Trades))
+		for i, t := range ob.Trades {
+			trades[i] = *t
+		}
+		if err := ob.TradeArchive.Archive(ob.Symbol, trades); err != nil {
+			return err
+		}
+	}
+
+	ob.cancelAll(BookCleared)
+
+	*ob.Bids = (*ob.Bids)[:0]
+	heap.Init(ob.Bids)
+	*ob.Asks = (*ob.Asks)[:0]
+	heap.Init(ob.Asks)
+	ob.BidLevels = make(map[Decimal]*PriceLevel)
+	ob.AskLevels = make(map[Decimal]*PriceLevel)
+	ob.Trades = make([]*Trade, 0)
+	ob.tradesByOrder = make(map[string][]*Trade)
+	// 開盤快照記錄的成交筆數已不對應新的成交紀錄
+	ob.opening = nil
+	ob.submissionMid = make(map[string]slippageRef)
+	ob.submissionOrder = nil
+	ob.pegged = make(map[string]*Order)
+	ob.lastPegRef, ob.lastRepeg = pegReference{}, time.Time{}
+	ob.quotes = make(map[string]*quote)
+	ob.sticky = stickyState{}
+	ob.sequence++
+	ob.afterChange()
+	return nil
+}
@@ -0,0 +1,60 @@
+package orderbook
+
+import (
+	"sync"
+	"time"
+)
+
+// 時間來源，可注入以便測試
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// SystemClock 使用系統時間
+var SystemClock Clock = systemClock{}
+
+// 手動推進的時鐘（測試用）
+type ManualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{now: t}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Advance 將時間往前推進 d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sleep 不實際等待，直接推進時間
+func (c *ManualClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Set 直接設定目前時間
+func (c *ManualClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = t
+}
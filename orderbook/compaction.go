@@ -0,0 +1,79 @@
+package orderbook
+
+import (
+	"container/heap"
+	"time"
+)
+
+// CompactionStats 最近一次壓縮的統計
+type CompactionStats struct {
+	Runs          int       // 累計壓縮次數
+	LastAt        time.Time // 最近一次壓縮時間
+	LevelsRemoved int       // 最近一次移除的空層級數
+}
+
+// Compact 從堆中移除所有空層級並重建堆，使 Bids.Len()/Asks.Len() 等於實際層級數，返回移除的層級數
+func (ob *OrderBook) Compact() int {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	return ob.compact()
+}
+
+// CompactionStats 返回壓縮統計
+func (ob *OrderBook) CompactionStats() CompactionStats {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.compaction
+}
+
+// 呼叫者需持有鎖
+func (ob *OrderBook) compact() int {
+	removed := ob.Bids.Len() + ob.Asks.Len()
+
+	bids := make(BidHeap, 0, len(ob.BidLevels))
+	for price, level := range ob.BidLevels {
+		if level.isEmpty() {
+			delete(ob.BidLevels, price)
+			continue
+		}
+		bids = append(bids, level)
+	}
+	asks := make(AskHeap, 0, len(ob.AskLevels))
+	for price, level := range ob.AskLevels {
+		if level.isEmpty() {
+			delete(ob.AskLevels, price)
+			continue
+		}
+		asks = append(asks, level)
+	}
+	heap.Init(&bids)
+	heap.Init(&asks)
+	ob.Bids, ob.Asks = &bids, &asks
+
+	removed -= ob.Bids.Len() + ob.Asks.Len()
+	ob.compaction.Runs++
+	ob.compaction.LastAt = ob.Clock.Now()
+	ob.compaction.LevelsRemoved = removed
+	return removed
+}
+
+// 任一邊堆中空層級的比例超過 CompactionThreshold 時自動壓縮（呼叫者需持有鎖）
+func (ob *OrderBook) maybeCompact() {
+	if ob.CompactionThreshold <= 0 {
+		return
+	}
+	if staleRatio(ob.Bids.Len(), len(ob.BidLevels)) > ob.CompactionThreshold ||
+		staleRatio(ob.Asks.Len(), len(ob.AskLevels)) > ob.CompactionThreshold {
+		ob.compact()
+	}
+}
+
+// 堆中不在層級映射內的（已清空）層級比例
+func staleRatio(heapLen, live int) float64 {
+	if heapLen == 0 || heapLen <= live {
+		return 0
+	}
+	return float64(heapLen-live) / float64(heapLen)
+}
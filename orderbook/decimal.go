@@ -0,0 +1,270 @@
+package orderbook
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Decimal 定點十進位數，以 int64 表示 DecimalScale 分之一的整數倍（8 位小數）。
+// 價格與數量一律使用此型別，加減與比較都是精確的整數運算，可直接作為 map 的鍵；
+// 只有比率、均價等衍生統計才轉為 float64
+type Decimal int64
+
+// 小數位數及對應的倍數
+const (
+	decimalPlaces = 8
+	DecimalScale  = 100_000_000
+)
+
+// 字串不是合法的十進位數，或小數位數超過 8 位
+var ErrInvalidDecimal = errors.New("invalid decimal")
+
+// 運算結果超出 Decimal 可表示的範圍
+var ErrDecimalOverflow = errors.New("decimal overflow")
+
+// 除數為 0
+var ErrDivisionByZero = errors.New("decimal division by zero")
+
+// NewDecimal 由 float64 轉換，四捨五入到 8 位小數；僅用於邊界（如設定值、衍生計算）。
+// 超出可表示範圍時飽和到 int64 的最大或最小值，NaN 轉為 0；需要拒絕時使用 CheckedNewDecimal
+func NewDecimal(f float64) Decimal {
+	v, err := CheckedNewDecimal(f)
+	switch {
+	case err == nil:
+		return v
+	case math.IsNaN(f):
+		return 0
+	default:
+		return saturate(f < 0)
+	}
+}
+
+// CheckedNewDecimal 同 NewDecimal，但 NaN 與無窮大返回 ErrInvalidDecimal，超出範圍返回 ErrDecimalOverflow
+func CheckedNewDecimal(f float64) (Decimal, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrInvalidDecimal
+	}
+	// float64(math.MaxInt64) 為 2^63，已超出範圍；-2^63 則可表示
+	r := math.Round(f * DecimalScale)
+	if r >= math.MaxInt64 || r < math.MinInt64 {
+		return 0, ErrDecimalOverflow
+	}
+	return Decimal(r), nil
+}
+
+// DecimalFromInt 由整數轉換
+func DecimalFromInt(n int64) Decimal {
+	return Decimal(n * DecimalScale)
+}
+
+// ParseDecimal 精確解析十進位字串（如 "50150.25"、"-0.001"、"1.5e-3"），不經過 float64；
+// 小數位數超過 8 位返回 ErrInvalidDecimal，超出可表示範圍返回 ErrDecimalOverflow
+func ParseDecimal(s string) (Decimal, error) {
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	exp := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return 0, ErrInvalidDecimal
+		}
+		s, exp = s[:i], e
+	}
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" && (!hasDot || fracPart == "") {
+		return 0, ErrInvalidDecimal
+	}
+	if exp != 0 {
+		// 指數過大時移動小數點會產生大量的 0，直接拒絕
+		if exp > maxExponent {
+			return 0, ErrDecimalOverflow
+		}
+		if exp < -maxExponent {
+			return 0, ErrInvalidDecimal
+		}
+		intPart, fracPart = shiftPoint(intPart, fracPart, exp)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > decimalPlaces {
+		return 0, ErrInvalidDecimal
+	}
+
+	var v uint64
+	for _, part := range []string{intPart, fracPart + strings.Repeat("0", decimalPlaces-len(fracPart))} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, ErrInvalidDecimal
+			}
+			hi, lo := bits.Mul64(v, 10)
+			lo, carry := bits.Add64(lo, uint64(c-'0'), 0)
+			if hi != 0 || carry != 0 || lo > math.MaxInt64 {
+				return 0, ErrDecimalOverflow
+			}
+			v = lo
+		}
+	}
+	if neg {
+		return -Decimal(v), nil
+	}
+	return Decimal(v), nil
+}
+
+// 科學記號可接受的指數絕對值上限，足以涵蓋 int64 的位數加上 8 位小數
+const maxExponent = 40
+
+// 依指數移動小數點，如 ("1", "5", -3) 得到 ("", "0015")
+func shiftPoint(intPart, fracPart string, exp int) (string, string) {
+	digits := intPart + fracPart
+	point := len(intPart) + exp
+	if point < 0 {
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	}
+	if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	return digits[:point], digits[point:]
+}
+
+// Float64 轉為 float64，用於比率、均價等衍生計算
+func (d Decimal) Float64() float64 {
+	return float64(d) / DecimalScale
+}
+
+// String 以最短的十進位字串表示，不含多餘的尾端 0，不使用科學記號
+func (d Decimal) String() string {
+	sign := ""
+	u := uint64(d)
+	if d < 0 {
+		sign, u = "-", uint64(-d)
+	}
+	s := sign + strconv.FormatUint(u/DecimalScale, 10)
+	if frac := u % DecimalScale; frac != 0 {
+		digits := strconv.FormatUint(frac, 10)
+		digits = strings.Repeat("0", decimalPlaces-len(digits)) + digits
+		s += "." + strings.TrimRight(digits, "0")
+	}
+	return s
+}
+
+// MarshalJSON 輸出為 JSON 數字，數值精確不經過 float64
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON 接受 JSON 數字或字串形式的十進位數（含科學記號），一律經 ParseDecimal 精確解析
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Mul 兩數相乘，結果向零截斷到 8 位小數；超出可表示範圍時飽和到 int64 的最大或最小值
+func (d Decimal) Mul(x Decimal) Decimal {
+	v, _ := d.CheckedMul(x)
+	return v
+}
+
+// Div 兩數相除，結果向零截斷到 8 位小數；x 為 0 或結果超出範圍時飽和到 int64 的最大或最小值
+func (d Decimal) Div(x Decimal) Decimal {
+	v, _ := d.CheckedDiv(x)
+	return v
+}
+
+// CheckedMul 兩數相乘，結果超出可表示範圍時返回 ErrDecimalOverflow 及飽和值
+func (d Decimal) CheckedMul(x Decimal) (Decimal, error) {
+	return mulDiv(d, x, DecimalScale)
+}
+
+// CheckedDiv 兩數相除，x 為 0 時返回 ErrDivisionByZero，結果超出範圍時返回 ErrDecimalOverflow，兩者皆附飽和值
+func (d Decimal) CheckedDiv(x Decimal) (Decimal, error) {
+	if x == 0 {
+		return saturate(d < 0), ErrDivisionByZero
+	}
+	return mulDiv(d, DecimalScale, x)
+}
+
+// CheckedAdd 兩數相加，結果超出可表示範圍時返回 ErrDecimalOverflow 及飽和值
+func (d Decimal) CheckedAdd(x Decimal) (Decimal, error) {
+	sum := d + x
+	if (x > 0 && sum < d) || (x < 0 && sum > d) {
+		return saturate(x < 0), ErrDecimalOverflow
+	}
+	return sum, nil
+}
+
+// 以 128 位元中間值計算 a*b/c 並向零截斷，c 不可為 0
+func mulDiv(a, b, c Decimal) (Decimal, error) {
+	neg := (a < 0) != (b < 0) != (c < 0)
+	hi, lo := bits.Mul64(absUint(a), absUint(b))
+	if hi >= absUint(c) {
+		// 商超過 64 位元，bits.Div64 會 panic
+		return saturate(neg), ErrDecimalOverflow
+	}
+	q, _ := bits.Div64(hi, lo, absUint(c))
+	if neg {
+		if q > 1<<63 {
+			return saturate(true), ErrDecimalOverflow
+		}
+		return Decimal(-q), nil
+	}
+	if q > math.MaxInt64 {
+		return saturate(false), ErrDecimalOverflow
+	}
+	return Decimal(q), nil
+}
+
+// 溢位時的飽和值
+func saturate(neg bool) Decimal {
+	if neg {
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// 絕對值的無號表示，math.MinInt64 也能正確轉換
+func absUint(d Decimal) uint64 {
+	if d < 0 {
+		return uint64(-d)
+	}
+	return uint64(d)
+}
+
+// FloorTo 向下取整到 step 的整數倍
+func (d Decimal) FloorTo(step Decimal) Decimal {
+	r := d % step
+	if r < 0 {
+		r += step
+	}
+	return d - r
+}
+
+// CeilTo 向上取整到 step 的整數倍
+func (d Decimal) CeilTo(step Decimal) Decimal {
+	if f := d.FloorTo(step); f != d {
+		return f + step
+	}
+	return d
+}
+
+func abs(d Decimal) Decimal {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,131 @@
+package orderbook
+
+// 深度層級變更的種類
+type LevelAction string
+
+const (
+	LevelAdded   LevelAction = "added"
+	LevelUpdated LevelAction = "updated"
+	LevelRemoved LevelAction = "removed"
+)
+
+// LevelChange 公開深度中一個價格層級的變更，Quantity 為變更後的顯示數量（移除時為 0）
+type LevelChange struct {
+	Sequence uint64
+	Action   LevelAction
+	Side     OrderSide
+	Price    Decimal
+	Quantity Decimal
+}
+
+// 深度增量紀錄：view 為上次記錄時的公開深度，floor 之前的變更已被丟棄；
+// touched 為自上次記錄後有變更的價格層級，記錄時只比對這些層級
+type depthDeltaState struct {
+	changes []LevelChange
+	view    map[levelKey]Decimal
+	floor   uint64
+	touched []*PriceLevel
+}
+
+// 登記層級的內容已變更，待下次記錄深度增量時比對
+func (pl *PriceLevel) touch() {
+	if pl.deltas != nil && !pl.dirty {
+		pl.dirty = true
+		pl.deltas.touched = append(pl.deltas.touched, pl)
+	}
+}
+
+// 建立某一邊的價格層級，並登記到深度增量紀錄以追蹤其變更
+func (ob *OrderBook) newLevel(side OrderSide, price Decimal) *PriceLevel {
+	level := newPriceLevel(price)
+	level.side, level.deltas = side, &ob.depthDelta
+	return level
+}
+
+// 比對有變更的層級與上次記錄的公開深度，按目前序號記錄層級變更；
+// 超過 DepthDeltaLimit 時丟棄最舊的變更。剛啟用時以目前的公開深度為基準，
+// 之前的序號需重新取得快照（呼叫者需持有鎖）
+func (ob *OrderBook) recordDepthDelta() {
+	d := &ob.depthDelta
+	touched := d.touched
+	for _, level := range touched {
+		level.dirty = false
+	}
+	d.touched = touched[:0]
+	if ob.DepthDeltaLimit <= 0 {
+		d.view, d.changes = nil, nil
+		return
+	}
+	if d.view == nil {
+		d.view = ob.publicDepthView()
+		d.changes, d.floor = nil, ob.sequence
+		return
+	}
+
+	for _, level := range touched {
+		key := levelKey{side: level.side, price: level.Price}
+		levels := ob.BidLevels
+		if level.side == Ask {
+			levels = ob.AskLevels
+		}
+		// 同一價格的層級可能已被移除或由新層級取代，以目前在訂單簿中的層級為準
+		var qty Decimal
+		current, visible := levels[level.Price]
+		visible = visible && !current.isEmpty() && ob.publiclyVisible(current)
+		if visible {
+			qty = current.displayedQuantity()
+		}
+		prev, existed := d.view[key]
+		switch {
+		case visible && !existed:
+			d.changes = append(d.changes, LevelChange{Sequence: ob.sequence, Action: LevelAdded, Side: key.side, Price: key.price, Quantity: qty})
+			d.view[key] = qty
+		case visible && prev != qty:
+			d.changes = append(d.changes, LevelChange{Sequence: ob.sequence, Action: LevelUpdated, Side: key.side, Price: key.price, Quantity: qty})
+			d.view[key] = qty
+		case !visible && existed:
+			d.changes = append(d.changes, LevelChange{Sequence: ob.sequence, Action: LevelRemoved, Side: key.side, Price: key.price})
+			delete(d.view, key)
+		}
+	}
+
+	if over := len(d.changes) - ob.DepthDeltaLimit; over > 0 {
+		d.floor = d.changes[over-1].Sequence
+		d.changes = append([]LevelChange(nil), d.changes[over:]...)
+	}
+}
+
+// 目前的公開深度（呼叫者需持有鎖）
+func (ob *OrderBook) publicDepthView() map[levelKey]Decimal {
+	view := make(map[levelKey]Decimal, len(ob.BidLevels)+len(ob.AskLevels))
+	collect := func(side OrderSide, levels map[Decimal]*PriceLevel) {
+		for price, level := range levels {
+			if !level.isEmpty() && ob.publiclyVisible(level) {
+				view[levelKey{side: side, price: price}] = level.displayedQuantity()
+			}
+		}
+	}
+	collect(Bid, ob.BidLevels)
+	collect(Ask, ob.AskLevels)
+	return view
+}
+
+// DepthDelta 返回序號 since 之後公開深度的層級變更（按發生順序）及目前序號，
+// 供輪詢客戶端在完整快照之後套用增量。未啟用 DepthDeltaLimit 或 since 早於保留範圍時
+// ok 為 false，客戶端應重新取得完整快照
+func (ob *OrderBook) DepthDelta(since uint64) (changes []LevelChange, sequence uint64, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	d := ob.depthDelta
+	if ob.DepthDeltaLimit <= 0 || since < d.floor || since > ob.sequence {
+		return nil, ob.sequence, false
+	}
+	changes = make([]LevelChange, 0)
+	for _, c := range d.changes {
+		if c.Sequence > since {
+			changes = append(changes, c)
+		}
+	}
+	return changes, ob.sequence, true
+}
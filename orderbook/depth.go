@@ -0,0 +1,222 @@
+package orderbook
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// 深度檔位：價格層級的彙總資訊，不含內部訂單指標
+type DepthLevel struct {
+	Price    Decimal
+	Quantity Decimal
+	Orders   int // 該價格的訂單數
+}
+
+// 公開深度只顯示冰山單的顯示部分
+func toDepthLevel(pl *PriceLevel) DepthLevel {
+	return DepthLevel{Price: pl.Price, Quantity: pl.displayedQuantity(), Orders: pl.Len()}
+}
+
+// 按價格優先順序返回某一邊的所有非空價格層級：買單由高到低，賣單由低到高（呼叫者需持有鎖）
+func (ob *OrderBook) sortedLevels(side OrderSide) []*PriceLevel {
+	levels := ob.BidLevels
+	if side == Ask {
+		levels = ob.AskLevels
+	}
+
+	sorted := make([]*PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		if !level.isEmpty() {
+			sorted = append(sorted, level)
+		}
+	}
+
+	if side == Bid {
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price > sorted[j].Price })
+	} else {
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+	}
+	return sorted
+}
+
+// 公開深度是否顯示該層級：顯示數量未達 MinVisibleLevelQuantity 的層級隱藏，撮合不受影響
+func (ob *OrderBook) publiclyVisible(pl *PriceLevel) bool {
+	return pl.displayedQuantity() >= ob.MinVisibleLevelQuantity
+}
+
+// 按價格優先順序返回某一邊公開深度可見的層級（呼叫者需持有鎖）
+func (ob *OrderBook) publicLevels(side OrderSide) []*PriceLevel {
+	levels := ob.sortedLevels(side)
+	if ob.MinVisibleLevelQuantity <= 0 {
+		return levels
+	}
+	visible := levels[:0]
+	for _, level := range levels {
+		if ob.publiclyVisible(level) {
+			visible = append(visible, level)
+		}
+	}
+	return visible
+}
+
+// GetDepthInRange 返回某一邊價格在 [minPrice, maxPrice] 內的所有檔位，按價格優先順序排列
+func (ob *OrderBook) GetDepthInRange(side OrderSide, minPrice, maxPrice Decimal) []DepthLevel {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	depth := make([]DepthLevel, 0)
+	for _, level := range ob.publicLevels(side) {
+		if level.Price >= minPrice && level.Price <= maxPrice {
+			depth = append(depth, toDepthLevel(level))
+		}
+	}
+	return depth
+}
+
+// 取前 limit 檔（limit <= 0 表示全部）
+func topDepth(levels []*PriceLevel, limit int) []DepthLevel {
+	if limit > 0 && len(levels) > limit {
+		levels = levels[:limit]
+	}
+	depth := make([]DepthLevel, 0, len(levels))
+	for _, level := range levels {
+		depth = append(depth, toDepthLevel(level))
+	}
+	return depth
+}
+
+// DepthSnapshot 在同一把鎖下返回按價格聚合的雙邊深度（每邊最多 limit 檔）及目前序號，
+// 供客戶端以快照加增量方式同步
+func (ob *OrderBook) DepthSnapshot(limit int) (bids, asks []DepthLevel, sequence uint64) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if limit > 0 {
+		return topDepth(ob.topLevels(Bid, limit), 0), topDepth(ob.topLevels(Ask, limit), 0), ob.sequence
+	}
+	return topDepth(ob.publicLevels(Bid), 0), topDepth(ob.publicLevels(Ask), 0), ob.sequence
+}
+
+// LevelQuantity 返回某價格層級的精確剩餘數量（即時從訂單重新加總），層級不存在時返回 0。
+// PriceLevel.Quantity 以增減維護，大量掛單/撤單後可能有微小誤差，需要精確值時使用此方法
+func (ob *OrderBook) LevelQuantity(price Decimal, side OrderSide) Decimal {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	levels := ob.BidLevels
+	if side == Ask {
+		levels = ob.AskLevels
+	}
+	level, ok := levels[price]
+	if !ok {
+		return 0
+	}
+	return level.ExactQuantity()
+}
+
+// 完整快照附帶的最近成交筆數
+const snapshotTradeLimit = 100
+
+// BookSnapshot 同一時間點的深度與成交紀錄
+type BookSnapshot struct {
+	Sequence uint64
+	Bids     []DepthLevel
+	Asks     []DepthLevel
+	Trades   []Trade // 最近的成交，按時間先後排列
+}
+
+// FullSnapshot 在同一次加鎖內取得全部深度與最近成交，二者共用同一序號，
+// 客戶端不會看到未反映在深度中的成交，反之亦然
+func (ob *OrderBook) FullSnapshot() BookSnapshot {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	recent := ob.Trades
+	if len(recent) > snapshotTradeLimit {
+		recent = recent[len(recent)-snapshotTradeLimit:]
+	}
+	trades := make([]Trade, 0, len(recent))
+	for _, t := range recent {
+		trades = append(trades, *t)
+	}
+
+	return BookSnapshot{
+		Sequence: ob.sequence,
+		Bids:     topDepth(ob.publicLevels(Bid), 0),
+		Asks:     topDepth(ob.publicLevels(Ask), 0),
+		Trades:   trades,
+	}
+}
+
+// 堆陣列中的候選索引，按價格優先排序
+type indexHeap struct {
+	idx  []int
+	less func(i, j int) bool
+}
+
+func (h *indexHeap) Len() int           { return len(h.idx) }
+func (h *indexHeap) Less(i, j int) bool { return h.less(h.idx[i], h.idx[j]) }
+func (h *indexHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *indexHeap) Push(x any)         { h.idx = append(h.idx, x.(int)) }
+func (h *indexHeap) Pop() any {
+	n := len(h.idx)
+	x := h.idx[n-1]
+	h.idx = h.idx[:n-1]
+	return x
+}
+
+// 按價格優先返回前 n 個公開可見的層級。沿著堆的結構展開候選節點，
+// 只需 O(n log n)，不必排序整個層級集合（呼叫者需持有鎖）
+func (ob *OrderBook) topLevels(side OrderSide, n int) []*PriceLevel {
+	var levels []*PriceLevel
+	var live map[Decimal]*PriceLevel
+	var better func(a, b *PriceLevel) bool
+	if side == Bid {
+		levels, live = *ob.Bids, ob.BidLevels
+		better = func(a, b *PriceLevel) bool { return a.Price > b.Price }
+	} else {
+		levels, live = *ob.Asks, ob.AskLevels
+		better = func(a, b *PriceLevel) bool { return a.Price < b.Price }
+	}
+
+	if n <= 0 || len(levels) == 0 {
+		return []*PriceLevel{}
+	}
+	if n > len(live) {
+		n = len(live)
+	}
+	top := make([]*PriceLevel, 0, n)
+	candidates := &indexHeap{
+		idx:  append(make([]int, 0, 2*n+1), 0),
+		less: func(i, j int) bool { return better(levels[i], levels[j]) },
+	}
+	for candidates.Len() > 0 && len(top) < n {
+		i := heap.Pop(candidates).(int)
+		// 跳過堆中殘留的空層級及未達顯示門檻的層級
+		if level := levels[i]; !level.isEmpty() && live[level.Price] == level && ob.publiclyVisible(level) {
+			top = append(top, level)
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(levels) {
+				heap.Push(candidates, child)
+			}
+		}
+	}
+	return top
+}
+
+// BestN 以平行陣列返回某一方前 n 檔的價格與（顯示）數量，按價格優先排序；
+// 不建立層級結構，適合對延遲敏感的消費者
+func (ob *OrderBook) BestN(side OrderSide, n int) (prices, quantities []Decimal) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	levels := ob.topLevels(side, n)
+	prices = make([]Decimal, len(levels))
+	quantities = make([]Decimal, len(levels))
+	for i, level := range levels {
+		prices[i] = level.Price
+		quantities[i] = level.displayedQuantity()
+	}
+	return prices, quantities
+}
@@ -0,0 +1,59 @@
+package orderbook
+
+import (
+	"fmt"
+	"io"
+)
+
+// Dump 將訂單簿以固定格式寫入 w：最佳價格、層級與掛單數，以及前 levels 檔賣盤與買盤
+// （皆按價格由高到低，賣盤在上）。輸出只取決於訂單簿狀態，可用於除錯及 golden 測試；
+// levels <= 0 表示輸出全部層級
+func (ob *OrderBook) Dump(w io.Writer, levels int) error {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	bids, asks := ob.sortedLevels(Bid), ob.sortedLevels(Ask)
+	if levels > 0 && len(bids) > levels {
+		bids = bids[:levels]
+	}
+	if levels > 0 && len(asks) > levels {
+		asks = asks[:levels]
+	}
+	var bestBid, bestAsk Decimal
+	if len(bids) > 0 {
+		bestBid = bids[0].Price
+	}
+	if len(asks) > 0 {
+		bestAsk = asks[0].Price
+	}
+	stats := ob.stats()
+
+	p := &dumpWriter{w: w}
+	p.printf("訂單簿 %s (序號 %d)\n", ob.Symbol, ob.sequence)
+	p.printf("  最佳買價: %.2f\n", bestBid.Float64())
+	p.printf("  最佳賣價: %.2f\n", bestAsk.Float64())
+	p.printf("  買方層級: %d 賣方層級: %d\n", stats.BidLevels, stats.AskLevels)
+	p.printf("  買單: %d 賣單: %d 未成交訂單: %d\n", stats.TotalBidOrders, stats.TotalAskOrders, stats.TotalResting)
+	p.printf("  賣盤:\n")
+	for i := len(asks) - 1; i >= 0; i-- {
+		p.printf("    %.2f -> %.4f (%d)\n", asks[i].Price.Float64(), asks[i].Quantity.Float64(), asks[i].Len())
+	}
+	p.printf("  買盤:\n")
+	for _, level := range bids {
+		p.printf("    %.2f -> %.4f (%d)\n", level.Price.Float64(), level.Quantity.Float64(), level.Len())
+	}
+	return p.err
+}
+
+// 記錄第一個寫入錯誤，之後的寫入略過
+type dumpWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *dumpWriter) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
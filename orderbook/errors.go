@@ -0,0 +1,79 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// 交易對不存在
+	ErrUnknownSymbol = errors.New("unknown symbol")
+	// 限價單價格不合法
+	ErrInvalidPrice = errors.New("invalid price")
+	// 停損限價單的觸發價不合法
+	ErrInvalidStopPrice = errors.New("invalid stop price")
+	// 訂單數量不合法
+	ErrInvalidQuantity = errors.New("invalid quantity")
+	// 市價單進入時對手盤沒有任何流動性
+	ErrNoLiquidity = errors.New("no liquidity")
+	// 對手盤流動性不足以滿足訂單要求
+	ErrInsufficientLiquidity = errors.New("insufficient liquidity")
+	// 訂單不存在或已不在訂單簿中
+	ErrOrderNotFound = errors.New("order not found")
+	// 訂單ID已存在
+	ErrDuplicateOrderID = errors.New("duplicate order id")
+	// 訂單簿已暫停交易
+	ErrBookHalted = errors.New("order book halted")
+	// 訂單簿排空中，不接受新掛單
+	ErrBookDraining = errors.New("order book draining")
+	// 集合競價期間不接受市價單
+	ErrMarketOrderInAuction = errors.New("market orders not accepted during auction")
+	// 價差過寬，拒絕市價單
+	ErrSpreadTooWide = errors.New("spread too wide for market order")
+	// 用戶掛單數已達上限
+	ErrTooManyOpenOrders = errors.New("too many open orders")
+	// 成交價偏離參考價格超過容忍範圍
+	ErrPriceDeviation = errors.New("fill price deviates from reference price")
+	// 掛單會造成買賣價相同的鎖定市場
+	ErrWouldLock = errors.New("order would lock the market")
+	// 改善最佳價的幅度不足 MinImprovementTicks
+	ErrInsufficientImprovement = errors.New("insufficient price improvement")
+	// 價格層級的掛單數已達上限
+	ErrLevelFull = errors.New("price level full")
+	// 訂單沒有任何成交
+	ErrNoFills = errors.New("order has no fills")
+	// 事件序號出現缺口，需要重新同步
+	ErrSequenceGap = errors.New("event sequence gap")
+	// 事件緩衝區已滿，事件被丟棄
+	ErrEventBufferFull = errors.New("event buffer full")
+	// 事件輸出已關閉，事件被丟棄
+	ErrEventSinkClosed = errors.New("event sink closed")
+	// 掛鉤單的參考價格不存在（如對應一邊沒有掛單）
+	ErrNoPegReference = errors.New("no reference price for pegged order")
+	// 撮合不變量被破壞（如同一訂單同時出現在買賣兩邊），訂單被拒絕
+	ErrMatchInvariant = errors.New("matching invariant violated")
+	// 進場訂單與同一用戶的掛單相遇，按自成交防範策略取消
+	ErrSelfTradePrevented = errors.New("self-trade prevented")
+	// 只做 maker 的訂單進場時會與對手盤成交
+	ErrPostOnlyWouldTake = errors.New("post-only order would take liquidity")
+	// 沒有足夠的成交或報價可計算結算價
+	ErrNoSettlementPrice = errors.New("no data for settlement price")
+	// 最小價格變動單位不合法
+	ErrInvalidTickSize = errors.New("invalid tick size")
+	// 價格乘以數量超出可表示的範圍
+	ErrNotionalOverflow = errors.New("price times quantity overflows")
+)
+
+// 與特定訂單相關的錯誤，Err 為上面的哨兵錯誤之一
+type OrderError struct {
+	OrderID string
+	Err     error
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("order %s: %v", e.OrderID, e.Err)
+}
+
+func (e *OrderError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,252 @@
+package orderbook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 事件類型，同時作為訊息主題的一部分
+type EventType string
+
+const (
+	EventTrade       EventType = "trade"        // 產生一筆成交
+	EventOrderUpdate EventType = "order_update" // 下單處理完成（掛單、成交或被拒）
+	EventOrderCancel EventType = "order_cancel" // 掛單被取消
+)
+
+// 訂單簿事件，Sequence 在同一訂單簿內嚴格遞增，下游可據此檢查遺漏或亂序
+type Event struct {
+	Sequence uint64
+	Type     EventType
+	Symbol   Symbol
+	Trade    *Trade `json:",omitempty"`
+	Order    *Order `json:",omitempty"`
+	// 取消事件專用：取消前已成交的數量及被取消的剩餘數量
+	FilledQuantity    Decimal `json:",omitempty"`
+	CancelledQuantity Decimal `json:",omitempty"`
+	// 成交事件專用：買賣雙方訂單截至此筆成交的累計成交量與均價
+	BuyFill   *OrderFill `json:",omitempty"`
+	SellFill  *OrderFill `json:",omitempty"`
+	Timestamp time.Time
+}
+
+// 訂單的累計成交進度
+type OrderFill struct {
+	OrderID        string
+	FilledQuantity Decimal
+	AvgPrice       float64 // 累計成交均價（VWAP）
+	Remaining      Decimal
+}
+
+func newOrderFill(o *Order) *OrderFill {
+	return &OrderFill{
+		OrderID:        o.ID,
+		FilledQuantity: o.FilledQuantity,
+		AvgPrice:       o.AvgFillPrice(),
+		Remaining:      o.Remaining(),
+	}
+}
+
+// 事件輸出，在持有訂單簿鎖的情況下呼叫，實作不應阻塞；需要網路 I/O 時請以 AsyncEventSink 包裝
+type EventSink interface {
+	Publish(ev Event) error
+}
+
+// 發布事件（呼叫者需持有鎖）。輸出失敗不影響撮合，事件直接丟棄
+func (ob *OrderBook) publishEvent(typ EventType, trade *Trade, o *Order) {
+	if !ob.hasEventListeners() {
+		return
+	}
+	ob.emit(ob.newEvent(typ, trade, o))
+}
+
+// 發布成交事件，附帶雙方訂單的累計成交量與均價（呼叫者需持有鎖）
+func (ob *OrderBook) publishTrade(trade *Trade, buy, sell *Order) {
+	if !ob.hasEventListeners() {
+		return
+	}
+	ev := ob.newEvent(EventTrade, trade, nil)
+	ev.BuyFill, ev.SellFill = newOrderFill(buy), newOrderFill(sell)
+	ob.emit(ev)
+}
+
+func (ob *OrderBook) newEvent(typ EventType, trade *Trade, o *Order) Event {
+	ev := Event{
+		Type:      typ,
+		Symbol:    ob.Symbol,
+		Trade:     trade,
+		Timestamp: ob.Clock.Now(),
+	}
+	if o != nil {
+		// 複製一份，避免下游讀取時訂單仍被撮合修改
+		snapshot := *o
+		ev.Order = &snapshot
+		if typ == EventOrderCancel {
+			ev.FilledQuantity = o.FilledQuantity
+			ev.CancelledQuantity = o.Remaining()
+		}
+	}
+	return ev
+}
+
+// 是否有 EventSink 或訂閱者（呼叫者需持有鎖）
+func (ob *OrderBook) hasEventListeners() bool {
+	return ob.EventSink != nil || len(ob.subscribers) > 0
+}
+
+// 分配序號並送出事件；訂閱者的緩衝區滿時丟棄該事件，不阻塞撮合
+func (ob *OrderBook) emit(ev Event) {
+	ob.eventSeq++
+	ev.Sequence = ob.eventSeq
+	if ob.EventSink != nil {
+		_ = ob.EventSink.Publish(ev)
+	}
+	for sub := range ob.subscribers {
+		select {
+		case sub <- ev:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents 訂閱訂單簿事件，返回容量為 buffer 的通道及取消訂閱函數；
+// 取消後通道會被關閉。讀取太慢時緩衝區滿的事件會被丟棄，可由 Sequence 的缺口發現
+func (ob *OrderBook) SubscribeEvents(buffer int) (<-chan Event, func()) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	sub := make(chan Event, buffer)
+	if ob.subscribers == nil {
+		ob.subscribers = make(map[chan Event]struct{})
+	}
+	ob.subscribers[sub] = struct{}{}
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			ob.mutex.Lock()
+			defer ob.mutex.Unlock()
+			delete(ob.subscribers, sub)
+			close(sub)
+		})
+	}
+}
+
+// 串流輸出使用的訂閱緩衝區大小
+const streamBufferSize = 1024
+
+// StreamEventsJSONL 訂閱事件並逐筆以 JSON 行（JSONL）寫入 w，直到 ctx 被取消；
+// 取消時先寫完已收到的事件再返回。寫入失敗時返回該錯誤
+func (ob *OrderBook) StreamEventsJSONL(w io.Writer, ctx context.Context) error {
+	events, unsubscribe := ob.SubscribeEvents(streamBufferSize)
+	defer unsubscribe()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-events:
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			unsubscribe()
+			for ev := range events {
+				if err := enc.Encode(ev); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// AsyncEventSink 以有界緩衝非同步轉發事件，緩衝區滿時丟棄新事件，保證撮合不被下游拖慢
+type AsyncEventSink struct {
+	next    EventSink
+	events  chan Event
+	done    chan struct{}
+	mu      sync.RWMutex // 保護 closed，避免 Publish 寫入已關閉的通道
+	closed  bool
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func NewAsyncEventSink(next EventSink, bufferSize int) *AsyncEventSink {
+	s := &AsyncEventSink{
+		next:   next,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *AsyncEventSink) run() {
+	defer close(s.done)
+	for ev := range s.events {
+		if err := s.next.Publish(ev); err != nil {
+			s.failed.Add(1)
+		}
+	}
+}
+
+// Publish 放入緩衝區，不會阻塞；緩衝區滿時丟棄並返回 ErrEventBufferFull，Close 之後丟棄並返回 ErrEventSinkClosed
+func (s *AsyncEventSink) Publish(ev Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return ErrEventSinkClosed
+	}
+	select {
+	case s.events <- ev:
+		return nil
+	default:
+		s.dropped.Add(1)
+		return ErrEventBufferFull
+	}
+}
+
+// Close 停止接收並等待緩衝區內的事件送出，可重複呼叫
+func (s *AsyncEventSink) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// 因緩衝區滿或已關閉而丟棄的事件數
+func (s *AsyncEventSink) Dropped() uint64 { return s.dropped.Load() }
+
+// 下游輸出失敗的事件數
+func (s *AsyncEventSink) Failed() uint64 { return s.failed.Load() }
+
+// 訊息代理的發布介面，*nats.Conn 等客戶端可直接使用
+type BrokerPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// BrokerSink 將事件編碼為 JSON 發布到訊息代理，主題為 <Prefix>.<symbol>.<type>
+type BrokerSink struct {
+	Conn   BrokerPublisher
+	Prefix string
+}
+
+func (s *BrokerSink) Publish(ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	subject := string(ev.Symbol) + "." + string(ev.Type)
+	if s.Prefix != "" {
+		subject = s.Prefix + "." + subject
+	}
+	return s.Conn.Publish(subject, data)
+}
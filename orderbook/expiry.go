@@ -0,0 +1,29 @@
+package orderbook
+
+import "sort"
+
+// SweepExpired 取消所有存活時間超過 MaxOrderAge 的掛單，返回被取消的訂單ID（按ID排序）
+func (ob *OrderBook) SweepExpired() []string {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ids := make([]string, 0)
+	if ob.MaxOrderAge <= 0 {
+		return ids
+	}
+
+	cutoff := ob.Clock.Now().Add(-ob.MaxOrderAge)
+	for id, o := range ob.UnFilledOrders {
+		if !o.Timestamp.After(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ob.cancelOrder(id, Expired)
+	}
+	if len(ids) > 0 {
+		ob.afterChange()
+	}
+	return ids
+}
@@ -0,0 +1,18 @@
+package orderbook
+
+// 手續費率設定（按成交額絕對值計算，以報價幣計價，負價格亦適用）
+// MakerRate 為負數時表示返佣
+type FeeSchedule struct {
+	MakerRate Decimal
+	TakerRate Decimal
+}
+
+// TakerFee 計算吃單方手續費
+func (f FeeSchedule) TakerFee(price, quantity Decimal) Decimal {
+	return abs(price.Mul(quantity)).Mul(f.TakerRate)
+}
+
+// MakerFee 計算掛單方手續費（負數為返佣）
+func (f FeeSchedule) MakerFee(price, quantity Decimal) Decimal {
+	return abs(price.Mul(quantity)).Mul(f.MakerRate)
+}
@@ -0,0 +1,37 @@
+package orderbook
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// FlushSide 緊急情況下取消某一邊的所有掛單（例如崩盤時撤下全部買單），另一邊不受影響。
+// 返回取消數量及被取消的訂單ID（按ID排序）
+func (ob *OrderBook) FlushSide(side OrderSide) (int, []string) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ids := make([]string, 0)
+	for id, o := range ob.UnFilledOrders {
+		if o.Side == side {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ob.cancelOrder(id, SideFlushed)
+	}
+
+	// 清除堆中殘留的空層級，該邊的堆與層級表完全清空
+	if side == Bid {
+		*ob.Bids = (*ob.Bids)[:0]
+		heap.Init(ob.Bids)
+		ob.BidLevels = make(map[Decimal]*PriceLevel)
+	} else {
+		*ob.Asks = (*ob.Asks)[:0]
+		heap.Init(ob.Asks)
+		ob.AskLevels = make(map[Decimal]*PriceLevel)
+	}
+	ob.afterChange()
+	return len(ids), ids
+}
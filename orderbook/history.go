@@ -0,0 +1,75 @@
+package orderbook
+
+import "time"
+
+// 每個價格層級保留的預設樣本數上限
+const defaultLevelHistoryLimit = 1000
+
+// 某時間點的價格層級掛單量
+type QuantitySample struct {
+	Time     time.Time
+	Quantity Decimal
+}
+
+type levelKey struct {
+	side  OrderSide
+	price Decimal
+}
+
+// RecordLevelSnapshot 記錄目前所有價格層級的掛單量，供定時呼叫；
+// 曾有紀錄但現在已清空的層級記為 0。未啟用 LevelHistoryEnabled 時不做任何事
+func (ob *OrderBook) RecordLevelSnapshot() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if !ob.LevelHistoryEnabled {
+		return
+	}
+	if ob.levelHistory == nil {
+		ob.levelHistory = make(map[levelKey][]QuantitySample)
+	}
+	now := ob.Clock.Now()
+
+	seen := make(map[levelKey]bool)
+	record := func(side OrderSide, levels map[Decimal]*PriceLevel) {
+		for price, level := range levels {
+			if level.isEmpty() {
+				continue
+			}
+			key := levelKey{side: side, price: price}
+			seen[key] = true
+			ob.appendLevelSample(key, QuantitySample{Time: now, Quantity: level.Quantity})
+		}
+	}
+	record(Bid, ob.BidLevels)
+	record(Ask, ob.AskLevels)
+
+	for key, samples := range ob.levelHistory {
+		if !seen[key] && samples[len(samples)-1].Quantity != 0 {
+			ob.appendLevelSample(key, QuantitySample{Time: now, Quantity: 0})
+		}
+	}
+}
+
+func (ob *OrderBook) appendLevelSample(key levelKey, sample QuantitySample) {
+	limit := ob.LevelHistoryLimit
+	if limit <= 0 {
+		limit = defaultLevelHistoryLimit
+	}
+	samples := append(ob.levelHistory[key], sample)
+	if len(samples) > limit {
+		samples = samples[len(samples)-limit:]
+	}
+	ob.levelHistory[key] = samples
+}
+
+// LevelHistory 返回某價格層級掛單量隨時間的變化，按時間先後排列
+func (ob *OrderBook) LevelHistory(price Decimal, side OrderSide) []QuantitySample {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	samples := ob.levelHistory[levelKey{side: side, price: price}]
+	out := make([]QuantitySample, len(samples))
+	copy(out, samples)
+	return out
+}
@@ -0,0 +1,68 @@
+package orderbook
+
+// 隨機數來源，*rand.Rand 即滿足此介面
+type RandomSource interface {
+	Float64() float64
+}
+
+// IsIceberg 是否為冰山單
+func (o *Order) IsIceberg() bool {
+	return o.DisplayQuantity > 0
+}
+
+// Visible 返回訂單目前顯示的數量；非冰山單即為剩餘數量
+func (o *Order) Visible() Decimal {
+	if o.IsIceberg() && o.visible > 0 {
+		return min(o.visible, o.Remaining())
+	}
+	if o.IsIceberg() {
+		return 0
+	}
+	return o.Remaining()
+}
+
+// 本次撮合可成交的數量：掛單中的冰山單只能成交顯示部分，主動進場的訂單可成交全部剩餘
+func (o *Order) matchable() Decimal {
+	if o.IsIceberg() && o.visible > 0 {
+		return min(o.visible, o.Remaining())
+	}
+	return o.Remaining()
+}
+
+// 扣減冰山單的顯示數量
+func (o *Order) consumeVisible(quantity Decimal) {
+	if o.IsIceberg() && o.visible > 0 {
+		o.visible -= quantity
+	}
+}
+
+// 計算冰山單下一次顯示的數量，按 DisplayVariancePct 在基準值上下浮動，不超過剩餘數量
+func (ob *OrderBook) nextDisplayQuantity(o *Order) Decimal {
+	display := o.DisplayQuantity
+	if o.DisplayVariancePct > 0 && ob.Rand != nil {
+		variance := (ob.Rand.Float64()*2 - 1) * o.DisplayVariancePct / 100
+		display = NewDecimal(display.Float64() * (1 + variance))
+	}
+	return min(display, o.Remaining())
+}
+
+// 隊首冰山單的顯示部分成交完後，從隱藏部分補充並排到隊尾（失去時間優先權）
+func (ob *OrderBook) refillIceberg(level *PriceLevel) {
+	front := level.Front()
+	if front == nil || !front.IsIceberg() || front.visible > 0 || front.IsFilled() {
+		return
+	}
+	level.RemoveOrder(front.ID)
+	front.visible = ob.nextDisplayQuantity(front)
+	front.priority = ob.nextPriority()
+	level.AddOrder(front)
+}
+
+// 價格層級中對外顯示的總量（冰山單只計顯示部分）
+func (pl *PriceLevel) displayedQuantity() Decimal {
+	var total Decimal
+	for e := pl.Orders.Front(); e != nil; e = e.Next() {
+		total += e.Value.(*Order).Visible()
+	}
+	return total
+}
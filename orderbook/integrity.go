@@ -0,0 +1,139 @@
+package orderbook
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// CheckIntegrity 檢查堆、價格層級索引及未成交訂單之間是否一致，返回發現的問題
+func (ob *OrderBook) CheckIntegrity() []string {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.checkIntegrity()
+}
+
+func (ob *OrderBook) checkIntegrity() []string {
+	issues := make([]string, 0)
+
+	check := func(name string, levels map[Decimal]*PriceLevel, heapLevels []*PriceLevel, side OrderSide) {
+		inHeap := make(map[*PriceLevel]bool, len(heapLevels))
+		for _, level := range heapLevels {
+			inHeap[level] = true
+			if !level.isEmpty() && levels[level.Price] != level {
+				issues = append(issues, fmt.Sprintf("%s level %s in heap but not in map", name, level.Price))
+			}
+		}
+
+		for price, level := range levels {
+			if !inHeap[level] {
+				issues = append(issues, fmt.Sprintf("%s level %s in map but not in heap", name, price))
+			}
+			var sum Decimal
+			for _, o := range level.OrderList() {
+				sum += o.Remaining()
+				if ob.UnFilledOrders[o.ID] != o {
+					issues = append(issues, fmt.Sprintf("%s level %s holds order %s not in unfilled orders", name, price, o.ID))
+				}
+				if o.Side != side || o.Price != price {
+					issues = append(issues, fmt.Sprintf("order %s misplaced in %s level %s", o.ID, name, price))
+				}
+			}
+			if sum != level.Quantity {
+				issues = append(issues, fmt.Sprintf("%s level %s quantity %s != sum of orders %s", name, price, level.Quantity, sum))
+			}
+		}
+	}
+	check("bid", ob.BidLevels, *ob.Bids, Bid)
+	check("ask", ob.AskLevels, *ob.Asks, Ask)
+
+	for id, o := range ob.UnFilledOrders {
+		if o.IsFilled() || o.Status == Filled || o.Status == Cancelled {
+			issues = append(issues, fmt.Sprintf("order %s is %s but still unfilled", id, GetStatusName(o.Status)))
+			continue
+		}
+		levels := ob.BidLevels
+		if o.Side == Ask {
+			levels = ob.AskLevels
+		}
+		level, ok := levels[o.Price]
+		if !ok {
+			issues = append(issues, fmt.Sprintf("order %s has no price level", id))
+			continue
+		}
+		if _, ok := level.nodes[id]; !ok {
+			issues = append(issues, fmt.Sprintf("order %s missing from level %s", id, o.Price))
+		}
+	}
+
+	sort.Strings(issues)
+	return issues
+}
+
+// 修復報告
+type RepairReport struct {
+	Issues        []string // 修復前發現的問題
+	OrdersDropped int      // 已成交或已取消而被移出的訂單數
+	BidLevels     int      // 重建後的買單層級數
+	AskLevels     int      // 重建後的賣單層級數
+}
+
+// Repair 以 UnFilledOrders 為準重建堆及價格層級索引，並重新計算各層級數量；
+// 層級內按原時間優先順序排列
+func (ob *OrderBook) Repair() RepairReport {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	report := RepairReport{Issues: ob.checkIntegrity()}
+
+	orders := make([]*Order, 0, len(ob.UnFilledOrders))
+	for id, o := range ob.UnFilledOrders {
+		if o.IsFilled() || o.Status == Filled || o.Status == Cancelled {
+			delete(ob.UnFilledOrders, id)
+			delete(ob.pegged, id)
+			report.OrdersDropped++
+			continue
+		}
+		orders = append(orders, o)
+	}
+	ob.rebuildLevels(orders)
+
+	report.BidLevels = len(ob.BidLevels)
+	report.AskLevels = len(ob.AskLevels)
+	return report
+}
+
+// 以給定訂單重建堆及價格層級索引，同一價格的訂單按時間優先序號排列（呼叫者需持有鎖）
+func (ob *OrderBook) rebuildLevels(orders []*Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].priority != orders[j].priority {
+			return orders[i].priority < orders[j].priority
+		}
+		return orders[i].Timestamp.Before(orders[j].Timestamp)
+	})
+
+	bids := make(BidHeap, 0, len(ob.BidLevels))
+	asks := make(AskHeap, 0, len(ob.AskLevels))
+	ob.Bids, ob.Asks = &bids, &asks
+	ob.BidLevels = make(map[Decimal]*PriceLevel)
+	ob.AskLevels = make(map[Decimal]*PriceLevel)
+
+	for _, o := range orders {
+		levels := ob.BidLevels
+		if o.Side == Ask {
+			levels = ob.AskLevels
+		}
+		level, ok := levels[o.Price]
+		if !ok {
+			level = ob.newLevel(o.Side, o.Price)
+			levels[o.Price] = level
+			if o.Side == Bid {
+				heap.Push(ob.Bids, level)
+			} else {
+				heap.Push(ob.Asks, level)
+			}
+		}
+		level.AddOrder(o)
+	}
+}
@@ -0,0 +1,29 @@
+package orderbook
+
+import "encoding/json"
+
+// 通用 L2 深度格式：價格與數量以字串表示的十進位數，[["price","qty"],...]
+type l2Book struct {
+	Bids     [][2]string `json:"bids"`
+	Asks     [][2]string `json:"asks"`
+	Sequence uint64      `json:"sequence"`
+}
+
+func l2Levels(depth []DepthLevel) [][2]string {
+	levels := make([][2]string, 0, len(depth))
+	for _, level := range depth {
+		levels = append(levels, [2]string{level.Price.String(), level.Quantity.String()})
+	}
+	return levels
+}
+
+// MarshalL2JSON 以主流交易所通用的 L2 格式輸出目前深度（每邊最多 levels 檔，levels <= 0 表示全部），
+// 供客戶端遷移對接使用，與內部 JSON 格式無關
+func (ob *OrderBook) MarshalL2JSON(levels int) ([]byte, error) {
+	bids, asks, sequence := ob.DepthSnapshot(levels)
+	return json.Marshal(l2Book{
+		Bids:     l2Levels(bids),
+		Asks:     l2Levels(asks),
+		Sequence: sequence,
+	})
+}
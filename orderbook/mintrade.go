@@ -0,0 +1,51 @@
+package orderbook
+
+// 與 maker 可成交的數量是否低於 MinTradeSize，或按手數取整後為 0；
+// 撮合迴圈據此停止，不會反覆嘗試不足一手的成交（呼叫者需持有鎖）
+func (ob *OrderBook) belowMinTrade(taker, maker *Order) bool {
+	quantity := min(taker.matchable(), maker.matchable())
+	if ob.roundFill(quantity) <= 0 {
+		return true
+	}
+	return ob.MinTradeSize > 0 && quantity < ob.MinTradeSize
+}
+
+// 啟用 RoundFillsToLot 時將成交數量向下取整到一手的整數倍
+func (ob *OrderBook) roundFill(quantity Decimal) Decimal {
+	if !ob.RoundFillsToLot || ob.LotSize <= 0 {
+		return quantity
+	}
+	return quantity.FloorTo(ob.LotSize)
+}
+
+// 掛單剩餘不足 MinTradeSize 時按 SmallResidualPolicy 取消，按手數取整後為 0 時按 DustPolicy 取消，
+// 讓撮合繼續與後面的掛單進行；返回是否已取消（呼叫者需持有鎖）
+func (ob *OrderBook) dropSmallMaker(maker *Order) bool {
+	if !ob.droppableMaker(maker) {
+		return false
+	}
+	return ob.cancelOrder(maker.ID, DustRemainder)
+}
+
+// dropSmallMaker 是否會取消該掛單（呼叫者需持有鎖）
+func (ob *OrderBook) droppableMaker(maker *Order) bool {
+	belowMin := ob.SmallResidualPolicy == DustCancel && maker.Remaining() < ob.MinTradeSize
+	belowLot := ob.DustPolicy == DustCancel && ob.roundFill(maker.Remaining()) <= 0
+	return belowMin || belowLot
+}
+
+// 進場訂單剩餘不足 MinTradeSize 時不再掛單（永遠無法成交），按 SmallResidualPolicy 取消；
+// 返回是否已取消（呼叫者需持有鎖）
+func (ob *OrderBook) dropSmallRemainder(o *Order) bool {
+	if !ob.droppableRemainder(o.Remaining()) {
+		return false
+	}
+	o.Status = Cancelled
+	o.CancelReason = DustRemainder
+	return true
+}
+
+// 進場訂單剩餘 quantity 時 dropSmallRemainder 是否會取消它
+func (ob *OrderBook) droppableRemainder(quantity Decimal) bool {
+	return ob.MinTradeSize > 0 && ob.SmallResidualPolicy == DustCancel && quantity < ob.MinTradeSize
+}
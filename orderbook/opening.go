@@ -0,0 +1,87 @@
+package orderbook
+
+import "time"
+
+// OpeningSnapshot 交易時段開始時的訂單簿狀態，作為結算與日內漲跌的參考
+type OpeningSnapshot struct {
+	Sequence   uint64
+	Bids       []DepthLevel
+	Asks       []DepthLevel
+	Mid        Decimal // 單邊或空簿為 0
+	LastPrice  Decimal // 開盤前最新成交價，尚無成交為 0
+	CapturedAt time.Time
+	tradeCount int // 擷取時的成交筆數，用於計算開盤後成交量
+}
+
+// 開盤參考價：優先使用開盤前最新成交價，沒有成交時使用中間價
+func (s *OpeningSnapshot) referencePrice() Decimal {
+	if s.LastPrice != 0 {
+		return s.LastPrice
+	}
+	return s.Mid
+}
+
+// SetOpeningSnapshot 在交易時段開始時擷取目前的完整深度作為開盤快照，覆蓋前一次的快照
+func (ob *OrderBook) SetOpeningSnapshot() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	bids, asks := topDepth(ob.sortedLevels(Bid), 0), topDepth(ob.sortedLevels(Ask), 0)
+	snap := &OpeningSnapshot{
+		Sequence:   ob.sequence,
+		Bids:       bids,
+		Asks:       asks,
+		CapturedAt: ob.Clock.Now(),
+		tradeCount: len(ob.Trades),
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		snap.Mid = (bids[0].Price + asks[0].Price) / 2
+	}
+	if len(ob.Trades) > 0 {
+		snap.LastPrice = ob.Trades[len(ob.Trades)-1].Price
+	}
+	ob.opening = snap
+}
+
+// OpeningDepth 返回開盤快照，尚未擷取時 ok 為 false
+func (ob *OrderBook) OpeningDepth() (snap OpeningSnapshot, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if ob.opening == nil {
+		return OpeningSnapshot{}, false
+	}
+	return *ob.opening, true
+}
+
+// IntradayChange 返回最新成交價相對開盤參考價的漲跌及漲跌幅（百分比）；
+// 尚未擷取開盤快照、開盤參考價為 0 或開盤後尚無成交時 ok 為 false
+func (ob *OrderBook) IntradayChange() (change Decimal, changePct float64, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if ob.opening == nil || len(ob.Trades) <= ob.opening.tradeCount {
+		return 0, 0, false
+	}
+	ref := ob.opening.referencePrice()
+	if ref == 0 {
+		return 0, 0, false
+	}
+	change = ob.Trades[len(ob.Trades)-1].Price - ref
+	return change, change.Float64() / ref.Float64() * 100, true
+}
+
+// VolumeSinceOpen 返回開盤快照後的成交量（基礎幣與報價幣），尚未擷取開盤快照時為 0
+func (ob *OrderBook) VolumeSinceOpen() (baseVolume, quoteVolume Decimal) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if ob.opening == nil {
+		return 0, 0
+	}
+	for _, t := range ob.Trades[ob.opening.tradeCount:] {
+		baseVolume += t.Quantity
+		quoteVolume += t.Price.Mul(t.Quantity)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package orderbook
+
+import "time"
+
+// 開盤集合競價進度
+type openingAuctionState struct {
+	started  bool
+	finished bool
+	ends     time.Time
+	result   AuctionResult
+}
+
+// 第一筆訂單進入時開始開盤集合競價，時間到後執行競價並轉為連續競價（呼叫者需持有鎖）
+func (ob *OrderBook) advanceOpeningAuction() {
+	if ob.OpeningAuctionWindow <= 0 || ob.openingAuction.finished {
+		return
+	}
+	now := ob.Clock.Now()
+	if !ob.openingAuction.started {
+		ob.openingAuction.started = true
+		ob.openingAuction.ends = now.Add(ob.OpeningAuctionWindow)
+		ob.AuctionMode = true
+		return
+	}
+	if now.Before(ob.openingAuction.ends) {
+		return
+	}
+	ob.openingAuction.result = ob.runAuction()
+	ob.openingAuction.finished = true
+	ob.AuctionMode = false
+	// 競價期間被觸發的停損單在轉為連續競價後撮合
+	ob.runTriggeredStops()
+}
+
+// CheckOpeningAuction 開盤集合競價時間已到但沒有新訂單觸發時，由定時器（Exchange.RunOpeningAuctionTimer）呼叫以完成競價
+func (ob *OrderBook) CheckOpeningAuction() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if ob.openingAuction.started {
+		ob.advanceOpeningAuction()
+	}
+}
+
+// OpeningAuctionResult 返回開盤集合競價結果，尚未完成時 ok 為 false
+func (ob *OrderBook) OpeningAuctionResult() (result AuctionResult, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.openingAuction.result, ob.openingAuction.finished
+}
@@ -2,7 +2,12 @@ package orderbook
 
 import (
 	"container/heap"
+	"container/list"
 	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -28,6 +33,16 @@ type OrderType int
 const (
 	Limit OrderType = iota
 	Market
+	StopLimit // 停損限價單：成交價觸及 StopPrice 後轉為限價單
+)
+
+// 限價單的有效期限
+type TimeInForce int
+
+const (
+	GTC TimeInForce = iota // 掛單直到成交或取消（預設）
+	IOC                    // 立即成交，未能成交的部分取消而不掛單
+	FOK                    // 全部立即成交，否則整筆取消且不改變訂單簿
 )
 
 // 訂單狀態
@@ -40,68 +55,307 @@ const (
 	Cancelled
 )
 
+// 取消原因
+type CancelReason int
+
+const (
+	NoCancelReason     CancelReason = iota
+	UserRequested                   // 用戶主動取消
+	NoLiquidity                     // 市價單進入時對手盤為空
+	MarketRemainder                 // 市價單部分成交後剩餘部分取消
+	Rejected                        // 訂單未通過驗證
+	DustRemainder                   // 成交後剩餘不足一手被自動取消
+	LevelInsufficient               // 單一價位全部成交的條件無法滿足
+	PriceProtection                 // 成交價偏離參考價格超過容忍範圍，剩餘部分取消
+	Expired                         // 掛單超過最長存活時間被清除
+	SideFlushed                     // 緊急清空單邊掛單
+	BookCleared                     // 訂單簿被重置清空
+	IOCRemainder                    // IOC 訂單未能立即成交的部分取消
+	FOKUnfilled                     // FOK 訂單無法全部立即成交而整筆取消
+	SelfTradePrevented              // 與同一用戶的訂單相遇，按自成交防範策略取消
+	CrossingRemainder               // 與對手最佳掛單的可成交數量過小而無法撮合，剩餘部分掛單會交叉，因此取消
+	AuctionCancelled                // 集合競價未執行即休市，競價簿中的訂單取消
+)
+
+// 成交後剩餘不足一手（碎股）的處理方式
+type DustPolicy int
+
+const (
+	DustKeep   DustPolicy = iota // 保留在訂單簿中
+	DustCancel                   // 自動取消
+)
+
+// 受保護市價單到達最差成交價後剩餘部分的處理方式
+type ProtectionRemainderPolicy int
+
+const (
+	ProtectionCancel ProtectionRemainderPolicy = iota // 取消剩餘部分
+	ProtectionRest                                    // 以最差成交價轉為限價單掛單
+)
+
 // 訂單
 type Order struct {
 	ID             string
+	UserID         string // 下單用戶
 	Symbol         Symbol
 	Side           OrderSide
 	Type           OrderType
+	TimeInForce    TimeInForce // 限價單的有效期限，預設 GTC
+	PostOnly       bool        // 只做 maker：進場時會與對手盤成交則整筆拒絕，不改變訂單簿
 	Status         OrderStatus
-	Price          float64
-	Quantity       float64
-	FilledQuantity float64 // 已成交數量
+	CancelReason   CancelReason // 狀態為 Cancelled 時的原因
+	Price          Decimal
+	StopPrice      Decimal // 停損限價單的觸發價
+	Quantity       Decimal
+	FilledQuantity Decimal // 已成交數量
 	Timestamp      time.Time
+	// 掛鉤單：限價由參考價格加上 PegOffset 決定，下單時忽略 Price
+	Peg       PegType
+	PegOffset Decimal
+	// 客戶自訂的附加資訊（如策略ID、標籤），原樣帶到成交紀錄上供歸因
+	Metadata map[string]string `json:",omitempty"`
+
+	// 冰山單：每次只顯示 DisplayQuantity，顯示部分成交完後從隱藏部分補充並排到隊尾
+	DisplayQuantity    Decimal // 0 表示非冰山單
+	DisplayVariancePct float64 // 每次補充時顯示數量的隨機浮動範圍（±百分比）
+	visible            Decimal // 掛單中目前顯示且可成交的數量
+	priority           uint64  // 時間優先序號，越小越優先；排到隊尾時重新分配
+
+	// 單一價位全部成交：只在最佳對手層級能單獨完全成交時撮合（不逐檔吃單），
+	// 否則不與對手盤交叉的限價單直接掛單，會交叉的限價單及市價單取消；僅在進場時檢查
+	AONLevel bool
+
+	// 參考價格保護（如指數價格）：可成交限價單的成交價偏離 ReferencePrice 超過
+	// ReferenceToleranceBps 時停止撮合並取消剩餘部分；ReferencePrice 為 0 表示不啟用
+	ReferencePrice        Decimal
+	ReferenceToleranceBps float64
+
+	// 市價單以報價幣金額指定：買單為花費上限、賣單為目標收入，達到後停止撮合；
+	// Quantity 仍為基礎幣數量上限。0 表示不啟用，限價單忽略
+	QuoteTarget Decimal
+	quoteFilled Decimal // 已成交的報價幣金額
+
+	// 受保護市價單的最差成交價（買單為上限、賣單為下限），對手價超過時停止撮合，
+	// 剩餘部分按 ProtectionRemainder 處理；0 表示不限制
+	ProtectionPrice Decimal
 }
 
 // Remaining 返回剩餘未成交數量
-func (o *Order) Remaining() float64 {
+func (o *Order) Remaining() Decimal {
 	return o.Quantity - o.FilledQuantity
 }
+
+// 對手價是否在受保護市價單的最差成交價之內
+func (o *Order) withinProtection(price Decimal) bool {
+	if o.Type != Market || o.ProtectionPrice == 0 {
+		return true
+	}
+	if o.Side == Bid {
+		return price <= o.ProtectionPrice
+	}
+	return price >= o.ProtectionPrice
+}
+
+// 報價幣目標已達成：剩餘金額為 0，或按目前成交均價已不足以再成交一個最小數量單位
+func (o *Order) quoteTargetReached() bool {
+	if o.Type != Market || o.QuoteTarget <= 0 {
+		return false
+	}
+	rest := o.QuoteTarget - o.quoteFilled
+	if rest <= 0 {
+		return true
+	}
+	if o.FilledQuantity <= 0 {
+		return false
+	}
+	avg, err := o.quoteFilled.CheckedDiv(o.FilledQuantity)
+	if err != nil {
+		// 均價超出可表示範圍，剩餘金額不可能再買到一個單位
+		return true
+	}
+	units, err := rest.CheckedDiv(avg)
+	return err == nil && units == 0
+}
+
+// 以 price 成交時，報價幣目標允許的最大成交數量（向下截斷，成交金額不會超過目標）
+func (o *Order) quoteCap(price Decimal) Decimal {
+	if o.Type != Market || o.QuoteTarget <= 0 || price <= 0 {
+		return math.MaxInt64
+	}
+	quantity, err := max(o.QuoteTarget-o.quoteFilled, 0).CheckedDiv(price)
+	if err != nil {
+		// 可成交數量超出可表示範圍，等同不受目標限制
+		return math.MaxInt64
+	}
+	return quantity
+}
+
+// AvgFillPrice 已成交部分的成交均價（VWAP），尚未成交時為 0
+func (o *Order) AvgFillPrice() float64 {
+	if o.FilledQuantity <= 0 {
+		return 0
+	}
+	return o.quoteFilled.Float64() / o.FilledQuantity.Float64()
+}
+
 func (o *Order) IsFilled() bool {
 	return o.FilledQuantity >= o.Quantity
 }
 
 // 一筆成交紀錄
 type Trade struct {
-	ID          string
-	SellOrderId string
-	BuyOrderId  string
-	Price       float64
-	Quantity    float64
-	Timestamp   time.Time
+	ID            string
+	SellOrderId   string
+	BuyOrderId    string
+	SellUserID    string
+	BuyUserID     string
+	AggressorSide OrderSide // 主動成交（吃單）方向
+	Price         Decimal
+	Quantity      Decimal
+	Timestamp     time.Time
+	Mid           Decimal `json:",omitempty"` // 成交前訂單簿的中間價，當時沒有雙邊報價或訂單簿交叉時為 0
+	// 買賣雙方訂單的 Metadata
+	BuyMetadata  map[string]string `json:",omitempty"`
+	SellMetadata map[string]string `json:",omitempty"`
 }
 
 // 價格層級 包含某價格的所有訂單
+// Orders 為雙向鏈結串列（元素為 *Order），按時間優先排列，
+// 搭配 nodes 索引可在 O(1) 內取出隊首、追加隊尾及移除中間的訂單
 type PriceLevel struct {
-	Price    float64
-	Orders   []*Order
-	Quantity float64 // 該價格層級的總量
+	Price    Decimal
+	Orders   *list.List
+	Quantity Decimal // 該價格層級的總量
+	nodes    map[string]*list.Element
+	pegged   int // 其中掛鉤單的筆數，用於不排序地找出含非掛鉤單的最佳層級
+
+	side   OrderSide        // 所屬的一邊，用於記錄深度增量
+	deltas *depthDeltaState // 非 nil 時變更會登記到深度增量紀錄
+	dirty  bool             // 是否已登記、尚未記錄
+}
+
+func newPriceLevel(price Decimal) *PriceLevel {
+	return &PriceLevel{
+		Price:  price,
+		Orders: list.New(),
+		nodes:  make(map[string]*list.Element),
+	}
 }
 
 func (p *PriceLevel) isEmpty() bool {
-	return len(p.Orders) == 0 || p.Quantity <= 0
+	return p.Orders.Len() == 0 || p.Quantity <= 0
+}
+
+// Len 返回該價格層級的訂單數
+func (pl *PriceLevel) Len() int {
+	return pl.Orders.Len()
 }
 
-// AddOrder 添加訂單到價格層級
+// Front 返回隊首（最早）的訂單
+func (pl *PriceLevel) Front() *Order {
+	if e := pl.Orders.Front(); e != nil {
+		return e.Value.(*Order)
+	}
+	return nil
+}
+
+// OrderList 按時間優先順序返回該層級的所有訂單
+func (pl *PriceLevel) OrderList() []*Order {
+	orders := make([]*Order, 0, pl.Orders.Len())
+	for e := pl.Orders.Front(); e != nil; e = e.Next() {
+		orders = append(orders, e.Value.(*Order))
+	}
+	return orders
+}
+
+// AddOrder 添加訂單到價格層級隊尾
 func (pl *PriceLevel) AddOrder(order *Order) {
-	pl.Orders = append(pl.Orders, order)
+	pl.nodes[order.ID] = pl.Orders.PushBack(order)
 	pl.Quantity += order.Remaining()
+	pl.touch()
+	if order.Peg != PegNone {
+		pl.pegged++
+	}
+}
+
+// 將訂單插到 next 之前；next 為 nil 或不在此層級時追加到隊尾
+func (pl *PriceLevel) insertBefore(order, next *Order) {
+	if next != nil {
+		if mark, ok := pl.nodes[next.ID]; ok {
+			pl.nodes[order.ID] = pl.Orders.InsertBefore(order, mark)
+			pl.Quantity += order.Remaining()
+			pl.touch()
+			if order.Peg != PegNone {
+				pl.pegged++
+			}
+			return
+		}
+	}
+	pl.AddOrder(order)
+}
+
+// PopFront 取出隊首訂單
+func (pl *PriceLevel) PopFront() *Order {
+	e := pl.Orders.Front()
+	if e == nil {
+		return nil
+	}
+	order := pl.Orders.Remove(e).(*Order)
+	delete(pl.nodes, order.ID)
+	pl.Quantity -= order.Remaining()
+	pl.touch()
+	if order.Peg != PegNone {
+		pl.pegged--
+	}
+	return order
+}
+
+// RemoveOrder 從價格層級中移除指定訂單
+func (pl *PriceLevel) RemoveOrder(orderID string) (*Order, bool) {
+	e, ok := pl.nodes[orderID]
+	if !ok {
+		return nil, false
+	}
+	order := pl.Orders.Remove(e).(*Order)
+	delete(pl.nodes, orderID)
+	pl.Quantity -= order.Remaining()
+	pl.touch()
+	if order.Peg != PegNone {
+		pl.pegged--
+	}
+	return order, true
+}
+
+// ExactQuantity 按隊列順序重新加總剩餘數量，可用於核對增減維護的 Quantity
+func (pl *PriceLevel) ExactQuantity() Decimal {
+	var total Decimal
+	for e := pl.Orders.Front(); e != nil; e = e.Next() {
+		total += e.Value.(*Order).Remaining()
+	}
+	return total
 }
 
 // 【修正】移除已成交的訂單並更新數量
 func (pl *PriceLevel) RemoveFilledOrders() {
-	newOrders := make([]*Order, 0)
-	newQuantity := 0.0
-
-	for _, order := range pl.Orders {
-		if !order.IsFilled() {
-			newOrders = append(newOrders, order)
+	var newQuantity Decimal
+
+	for e := pl.Orders.Front(); e != nil; {
+		next := e.Next()
+		order := e.Value.(*Order)
+		if order.IsFilled() {
+			pl.Orders.Remove(e)
+			delete(pl.nodes, order.ID)
+			if order.Peg != PegNone {
+				pl.pegged--
+			}
+		} else {
 			newQuantity += order.Remaining()
 		}
+		e = next
 	}
 
-	pl.Orders = newOrders
 	pl.Quantity = newQuantity
+	pl.touch()
 }
 
 // 買單堆:最大堆（價格由高到低）
@@ -169,49 +423,399 @@ type OrderBook struct {
 	Symbol         Symbol
 	Bids           *BidHeap
 	Asks           *AskHeap
-	BidLevels      map[float64]*PriceLevel
-	AskLevels      map[float64]*PriceLevel
+	BidLevels      map[Decimal]*PriceLevel
+	AskLevels      map[Decimal]*PriceLevel
 	UnFilledOrders map[string]*Order
 	mutex          sync.RWMutex
 	Trades         []*Trade
+	tradesByOrder  map[string][]*Trade // 訂單ID -> 參與的成交
+	sequence       uint64              // 每次狀態變更遞增的序號
+	quotes         map[string]*quote   // 用戶ID -> 目前的雙邊報價
+	prioritySeq    uint64              // 時間優先序號計數器
+	replication    replicationState    // 熱備援複製進度
+	Clock          Clock               // 時間來源，預設為系統時間
+	Fees           FeeSchedule         // 該交易對的手續費率
+	AuditEnabled   bool                // 是否記錄撮合稽核紀錄（有額外開銷）
+	AuditSink      AuditSink           // 稽核紀錄輸出
+	// Clear 重置訂單簿前將既有成交紀錄歸檔到此處，nil 表示直接丟棄
+	TradeArchive TradeArchive
+	// 是否允許負價格（如價差合約），預設不允許
+	AllowNegativePrice bool
+	// 同一訂單連續成交之間的間隔（模擬限速執行），0 表示不限速
+	FillCooldown time.Duration
+	// 限價單價格等於對手最佳價時是否撮合，預設為 true；部分交易所要求嚴格優於對手價
+	CrossOnEqual bool
+	// 隨機數來源（冰山單顯示數量浮動），可注入固定種子以便測試
+	Rand RandomSource
+	// 成交ID改用訂單簿內遞增序號（trade_1, trade_2, ...），配合 ManualClock 可完全重現撮合結果
+	SequentialTradeIDs bool
+	tradeSeq           uint64
+	// 最佳價格層級被清空後，GetBestBidAsk 繼續顯示舊價格的時間，減少介面閃爍；0 表示關閉
+	StickyBestWindow time.Duration
+	sticky           stickyState
+	// 最小交易單位（一手），0 表示不限制
+	LotSize Decimal
+	// 成交數量向下取整到 LotSize 的整數倍，不足一手的部分留在雙方訂單上
+	RoundFillsToLot bool
+	// 單筆成交的最小數量，避免兩筆訂單僅些微重疊時產生碎量成交；0 表示不限制。
+	// 剩餘不足此數量的一方按 SmallResidualPolicy 保留或取消
+	MinTradeSize        Decimal
+	SmallResidualPolicy DustPolicy
+	// 掛單成交後剩餘不足一手時的處理方式
+	DustPolicy DustPolicy
+	// 是否記錄價格層級掛單量歷史，及每個層級保留的樣本數上限（0 使用預設值）
+	LevelHistoryEnabled bool
+	LevelHistoryLimit   int
+	levelHistory        map[levelKey][]QuantitySample
+	// 最佳價格變動時自動將掛鉤單移到新的參考價格，兩次重新掛鉤至少間隔 RepegMinInterval
+	AutoRepeg        bool
+	RepegMinInterval time.Duration
+	pegged           map[string]*Order
+	lastPegRef       pegReference
+	lastRepeg        time.Time
+	// 交易狀態：正常、排空（只撮合不掛單）或暫停
+	state BookState
+	// 交易狀態變更時在持有鎖的情況下呼叫，用於記錄停止撮合的原因；不可回呼訂單簿
+	OnStateChange func(c StateChange)
+	// 改單、重新量化等操作造成訂單簿交叉時自動撮合交叉部分
+	AutoUncross bool
+	// 新訂單簿的開盤集合競價時長：第一筆訂單起此時間內的訂單只累積不撮合，
+	// 時間到後以單一開盤價撮合並轉為連續競價；0 表示不啟用
+	OpeningAuctionWindow time.Duration
+	openingAuction       openingAuctionState
+	// 集合競價模式：限價單只掛單不撮合，市價單被拒絕，由 RunAuction 統一撮合
+	AuctionMode bool
+	// 價差（基點）超過此值時拒絕市價單，避免在流動性稀薄時以極差價格成交；0 表示不限制
+	MaxSpreadBpsForMarket float64
+	// 每筆成交記錄後在持有鎖的情況下呼叫，用於與成交原子地結算；不可回呼訂單簿
+	OnTrade func(t *Trade)
+	// 事件輸出（成交及訂單狀態變更），nil 表示不發布
+	EventSink       EventSink
+	eventSeq        uint64
+	submissionMid   map[string]slippageRef // 訂單ID -> 受理時的中間價，用於計算滑價
+	submissionOrder []string               // submissionMid 的訂單ID，按受理順序，用於淘汰最早的紀錄
+	// 拒絕掛單價格等於對手最佳價而不撮合（鎖定市場）的限價單，例如 CrossOnEqual 為 false 時
+	RejectLockingOrders bool
+	// 堆中空層級比例超過此值時自動壓縮（0~1），0 表示只能手動呼叫 Compact
+	CompactionThreshold float64
+	compaction          CompactionStats
+	// 是否記錄被拒絕的下單及保留筆數上限（0 使用預設值）
+	RejectionLogEnabled bool
+	RejectionLogLimit   int
+	rejections          []RejectionRecord
+	// 成交價偏離前一筆成交價超過此百分比時視為異常成交並記錄；0 表示不檢查。
+	// HaltOnBandBreach 為 true 時不執行該筆成交，並暫停訂單簿直到 Resume；
+	// 只標記不暫停時每筆偏離的成交都會記錄，最多保留 BandBreachLimit 筆（0 使用預設值）
+	TradeBandPct     float64
+	HaltOnBandBreach bool
+	BandBreachLimit  int
+	bandBreaches     []BandBreach
+	// 改單時數量增幅（相對原數量的百分比）低於此值視為可忽略，保留時間優先；0 表示任何增加都重新排隊
+	AmendPriorityResetPct float64
+	// 記錄異常狀況（如撮合不變量被破壞），nil 時使用標準 log 套件
+	Logf func(format string, args ...any)
+	// 事件訂閱者（SubscribeEvents）
+	subscribers map[chan Event]struct{}
+	// 交易時段開始時擷取的開盤快照
+	opening *OpeningSnapshot
+	// 結算價的計算方式及 VWAP 回溯時間，SetSettlement 擷取的結果存於 settlement
+	SettlementMethod SettlementMethod
+	SettlementWindow time.Duration
+	settlement       *Settlement
+	// 撤單時所在層級的掛單數統計
+	cancelStats CancelLevelStats
+	// 公開深度中層級的最低顯示數量，未達此數量的層級暫不顯示，直到累積足夠；0 表示全部顯示
+	MinVisibleLevelQuantity Decimal
+	// 受保護市價單到達最差成交價後剩餘部分的處理方式
+	ProtectionRemainder ProtectionRemainderPolicy
+	// 最小價位，及新掛單改善同方向最佳價時至少需改善的價位數（防止以一個價位插隊）；0 表示不限制
+	TickSize            Decimal
+	MinImprovementTicks int
+	// 單一價格層級的掛單數上限，限制單層級 O(n) 操作的成本；0 表示不限制
+	MaxOrdersPerLevel int
+	// 掛單最長存活時間，超過後由 SweepExpired 強制取消；0 表示不限制
+	MaxOrderAge time.Duration
+	// 同一用戶的買賣單相遇時的處理方式，預設允許自成交
+	SelfTradePolicy SelfTradePolicy
+	// 每個用戶的掛單數上限，達到上限時拒絕新訂單；0 表示不限制，未帶 UserID 的訂單不受限
+	MaxOpenOrdersPerUser int
+	// 保留的深度層級變更筆數上限，供 DepthDelta 增量輪詢；0 表示不記錄
+	DepthDeltaLimit int
+	depthDelta      depthDeltaState
+	// 尚未觸發的停損限價單（按下單順序），及已觸發、待轉為限價單撮合的停損單
+	stopOrders     []*Order
+	triggeredStops []*Order
 }
 
 func NewOrderBook(symbol Symbol) *OrderBook {
-	bidHeap := &BidHeap{}
-	askHeap := &AskHeap{}
-	heap.Init(bidHeap)
-	heap.Init(askHeap)
+	return NewOrderBookWithCapacity(symbol, 0, 0)
+}
+
+// NewOrderBookWithCapacity 預先分配容量，減少熱門交易對暖機期間的重新分配
+// levelHint 為每邊預估價格層級數，orderHint 為預估未成交訂單數
+func NewOrderBookWithCapacity(symbol Symbol, levelHint, orderHint int) *OrderBook {
+	bidHeap := make(BidHeap, 0, levelHint)
+	askHeap := make(AskHeap, 0, levelHint)
+	heap.Init(&bidHeap)
+	heap.Init(&askHeap)
 
 	return &OrderBook{
 		Symbol:         symbol,
-		Bids:           bidHeap,
-		Asks:           askHeap,
-		BidLevels:      make(map[float64]*PriceLevel),
-		AskLevels:      make(map[float64]*PriceLevel),
-		UnFilledOrders: make(map[string]*Order),
+		Bids:           &bidHeap,
+		Asks:           &askHeap,
+		BidLevels:      make(map[Decimal]*PriceLevel, levelHint),
+		AskLevels:      make(map[Decimal]*PriceLevel, levelHint),
+		UnFilledOrders: make(map[string]*Order, orderHint),
 		Trades:         make([]*Trade, 0),
+		tradesByOrder:  make(map[string][]*Trade),
+		quotes:         make(map[string]*quote),
+		submissionMid:  make(map[string]slippageRef),
+		pegged:         make(map[string]*Order),
+		Clock:          SystemClock,
+		CrossOnEqual:   true,
+		AutoUncross:    true,
+		Rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
+// CloneConfig 以相同的交易對及設定（價格與數量規則、撮合行為、風控限制、回呼等）建立新的空訂單簿，
+// 用於如開盤前競價簿等需要沿用同一套規則的訂單簿。不含掛單、成交紀錄、交易狀態及統計；
+// EventSink 與 OnStateChange 不複製，以免兩個訂單簿的事件序號或狀態通知混在同一個輸出
+func (ob *OrderBook) CloneConfig() *OrderBook {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	c := NewOrderBook(ob.Symbol)
+	c.Clock = ob.Clock
+	c.Fees = ob.Fees
+	c.AuditEnabled, c.AuditSink = ob.AuditEnabled, ob.AuditSink
+	c.TradeArchive = ob.TradeArchive
+	c.AllowNegativePrice = ob.AllowNegativePrice
+	c.FillCooldown = ob.FillCooldown
+	c.CrossOnEqual = ob.CrossOnEqual
+	c.Rand = ob.Rand
+	c.SequentialTradeIDs = ob.SequentialTradeIDs
+	c.StickyBestWindow = ob.StickyBestWindow
+	c.LotSize, c.RoundFillsToLot = ob.LotSize, ob.RoundFillsToLot
+	c.MinTradeSize, c.SmallResidualPolicy, c.DustPolicy = ob.MinTradeSize, ob.SmallResidualPolicy, ob.DustPolicy
+	c.LevelHistoryEnabled, c.LevelHistoryLimit = ob.LevelHistoryEnabled, ob.LevelHistoryLimit
+	c.AutoRepeg, c.RepegMinInterval = ob.AutoRepeg, ob.RepegMinInterval
+	c.AutoUncross = ob.AutoUncross
+	c.OpeningAuctionWindow = ob.OpeningAuctionWindow
+	c.AuctionMode = ob.AuctionMode
+	c.MaxSpreadBpsForMarket = ob.MaxSpreadBpsForMarket
+	c.OnTrade = ob.OnTrade
+	c.RejectLockingOrders = ob.RejectLockingOrders
+	c.CompactionThreshold = ob.CompactionThreshold
+	c.RejectionLogEnabled, c.RejectionLogLimit = ob.RejectionLogEnabled, ob.RejectionLogLimit
+	c.TradeBandPct, c.HaltOnBandBreach, c.BandBreachLimit = ob.TradeBandPct, ob.HaltOnBandBreach, ob.BandBreachLimit
+	c.AmendPriorityResetPct = ob.AmendPriorityResetPct
+	c.Logf = ob.Logf
+	c.SettlementMethod, c.SettlementWindow = ob.SettlementMethod, ob.SettlementWindow
+	c.MinVisibleLevelQuantity = ob.MinVisibleLevelQuantity
+	c.ProtectionRemainder = ob.ProtectionRemainder
+	c.TickSize, c.MinImprovementTicks = ob.TickSize, ob.MinImprovementTicks
+	c.MaxOrdersPerLevel = ob.MaxOrdersPerLevel
+	c.MaxOrderAge = ob.MaxOrderAge
+	c.SelfTradePolicy = ob.SelfTradePolicy
+	c.MaxOpenOrdersPerUser = ob.MaxOpenOrdersPerUser
+	c.DepthDeltaLimit = ob.DepthDeltaLimit
+	return c
+}
+
 // 下單
-func (ob *OrderBook) PlaceOrder(o *Order) []*Trade {
-	o.Status = Pending
-	o.Timestamp = time.Now()
+// 返回的成交按撮合順序排列：先價格最優的層級，同一層級內按時間優先（先掛先成交）
+// 訂單被拒絕時返回 *OrderError，可用 errors.Is 判斷具體原因；
+// 市價單遇到空的對手盤時為 ErrNoLiquidity，訂單狀態為 Cancelled；
+// 此筆下單使掛鉤單重新掛鉤（AutoRepeg）而產生的成交附在最後
+func (ob *OrderBook) PlaceOrder(o *Order) ([]*Trade, error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	trades, err := ob.placeOrder(o)
+	trades = append(trades, ob.repegOrders()...)
+	return trades, err
+}
 
+// 批次下單中單筆訂單的結果
+type PlaceResult struct {
+	Trades []*Trade
+	Err    error
+}
+
+// PlaceOrders 在一次持有鎖的情況下依序下單，每筆的結果與逐筆呼叫 PlaceOrder 相同；
+// 供單一寫入者批次處理佇列中的訂單，減少鎖的取得次數
+func (ob *OrderBook) PlaceOrders(orders []*Order) []PlaceResult {
 	ob.mutex.Lock()
 	defer ob.mutex.Unlock()
 
-	if o.Type == Limit {
-		return ob.processLimitOrder(o)
+	results := make([]PlaceResult, len(orders))
+	for i, o := range orders {
+		trades, err := ob.placeOrder(o)
+		results[i] = PlaceResult{Trades: append(trades, ob.repegOrders()...), Err: err}
+	}
+	return results
+}
+
+// 下單（呼叫者需持有鎖）
+func (ob *OrderBook) placeOrder(o *Order) ([]*Trade, error) {
+	ob.advanceOpeningAuction()
+	o.Status = Pending
+	o.Timestamp = ob.Clock.Now()
+
+	// 市價單不使用價格，清除可能殘留的價格以免進入成交
+	if o.Type == Market {
+		o.Price = 0
+	}
+
+	if err := ob.prepareOrder(o); err != nil {
+		o.Status = Cancelled
+		o.CancelReason = Rejected
+		ob.recordRejection(o, err)
+		return nil, &OrderError{OrderID: o.ID, Err: err}
+	}
+	ob.sequence++
+	ob.recordSubmissionMid(o)
+
+	var record *AuditRecord
+	if ob.AuditEnabled && ob.AuditSink != nil {
+		record = ob.beginAudit(o)
+	}
+
+	var trades []*Trade
+	var err error
+	if o.Type == StopLimit {
+		// 停損單觸發前不撮合也不進入訂單簿
+		ob.holdStop(o)
+	} else if ob.AuctionMode {
+		// 集合競價期間只掛單不撮合
+		ob.addToOrderBook(o)
+	} else if o.Type == Limit {
+		trades, err = ob.processLimitOrder(o)
 	} else {
-		return ob.processMarketOrder(o)
+		trades, err = ob.processMarketOrder(o)
+	}
+
+	if record != nil {
+		ob.finishAudit(record, o, trades)
+	}
+	if err != nil {
+		ob.recordRejection(o, err)
+	}
+	ob.untrackPeg(o)
+	ob.forgetSubmissionMid(o)
+	ob.publishEvent(EventOrderUpdate, nil, o)
+	ob.runTriggeredStops()
+	ob.afterChange()
+	return trades, err
+}
+
+// 決定掛鉤單價格後驗證訂單（呼叫者需持有鎖）
+func (ob *OrderBook) prepareOrder(o *Order) error {
+	if err := ob.applyPeg(o); err != nil {
+		return err
+	}
+	if err := ob.validateOrder(o); err != nil {
+		return err
+	}
+	ob.trackPeg(o)
+	return nil
+}
+
+// 驗證訂單（呼叫者需持有鎖）
+func (ob *OrderBook) validateOrder(o *Order) error {
+	if o.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	if ob.AuctionMode && o.Type == Market {
+		return ErrMarketOrderInAuction
+	}
+	// 限價單價格必須為正（允許負價格的交易對除外）；市價單忽略價格
+	if (o.Type == Limit || o.Type == StopLimit) && o.Price <= 0 && !ob.AllowNegativePrice {
+		return ErrInvalidPrice
+	}
+	if o.Type == StopLimit && o.StopPrice <= 0 {
+		return ErrInvalidStopPrice
+	}
+	// 成交金額（價格 × 數量）必須可以表示，撮合時才不會溢位
+	if o.Type != Market {
+		if _, err := o.Price.CheckedMul(o.Quantity); err != nil {
+			return ErrNotionalOverflow
+		}
+	}
+	if ob.state == BookHalted {
+		return ErrBookHalted
+	}
+	if _, exists := ob.UnFilledOrders[o.ID]; exists || ob.hasStop(o.ID) {
+		return ErrDuplicateOrderID
+	}
+	if ob.MaxOpenOrdersPerUser > 0 && o.UserID != "" && ob.openOrderCount(o.UserID) >= ob.MaxOpenOrdersPerUser {
+		return ErrTooManyOpenOrders
+	}
+	return nil
+}
+
+// 某用戶目前的掛單數（呼叫者需持有鎖）
+func (ob *OrderBook) openOrderCount(userID string) int {
+	count := 0
+	for _, o := range ob.UnFilledOrders {
+		if o.UserID == userID {
+			count++
+		}
 	}
+	return count
+}
+
+// UserBestBidAsk 返回某用戶自己掛單中的最高買價與最低賣價，對應一邊沒有掛單時 ok 為 false
+func (ob *OrderBook) UserBestBidAsk(userID string) (bestBid, bestAsk Decimal, bidOK, askOK bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	for _, o := range ob.UnFilledOrders {
+		if o.UserID != userID || o.Remaining() <= 0 {
+			continue
+		}
+		if o.Side == Bid && (!bidOK || o.Price > bestBid) {
+			bestBid, bidOK = o.Price, true
+		}
+		if o.Side == Ask && (!askOK || o.Price < bestAsk) {
+			bestAsk, askOK = o.Price, true
+		}
+	}
+	return
 }
 
 // 處理限價單
-func (ob *OrderBook) processLimitOrder(o *Order) []*Trade {
+func (ob *OrderBook) processLimitOrder(o *Order) ([]*Trade, error) {
 	trades := make([]*Trade, 0)
 
+	if o.TimeInForce == FOK && !ob.fokSatisfiable(o) {
+		ob.killUnfilled(o)
+		return trades, nil
+	}
+
+	if o.PostOnly && ob.wouldTake(o) {
+		return trades, ob.rejectResting(o, ErrPostOnlyWouldTake)
+	}
+
+	// 單一價位全部成交：最佳對手層級不足以完全成交時不撮合；
+	// 與對手最佳價交叉的訂單掛單會造成交叉的訂單簿，因此取消，否則直接掛單
+	if o.AONLevel && !ob.aonLevelSatisfied(o) {
+		if ob.wouldTake(o) {
+			o.Status = Cancelled
+			o.CancelReason = LevelInsufficient
+			return trades, &OrderError{OrderID: o.ID, Err: ErrInsufficientLiquidity}
+		}
+		if err := ob.restingError(o); err != nil {
+			return trades, ob.rejectResting(o, err)
+		}
+		ob.addToOrderBook(o)
+		return trades, nil
+	}
+
+	deviated, halted, invalid, crossing := false, false, false, false
+	var restErr error
+
 	if o.Side == Bid {
 		// 買單，先嘗試與賣單撮合
 		for o.Remaining() > 0 && ob.Asks.Len() > 0 {
@@ -223,14 +827,41 @@ func (ob *OrderBook) processLimitOrder(o *Order) []*Trade {
 				continue
 			}
 
-			if o.Price >= bestAsk.Price {
-				// 只有當買價 >= 賣價時才能撮合
-				trade := ob.matchOrders(o, bestAsk.Orders[0], bestAsk.Price)
-
-				if trade != nil {
-					trades = append(trades, trade)
-					ob.Trades = append(ob.Trades, trade)
+			if ob.limitCrosses(o, bestAsk.Price) {
+				if !withinReference(o, bestAsk.Price) {
+					// 成交價偏離參考價格過多，停止撮合
+					deviated = true
+					break
+				}
+				if !ob.tradeBandAllows(bestAsk.Price) {
+					halted = true
+					break
+				}
+				// 只有當買價 >= 賣價時才能撮合（CrossOnEqual 為 false 時需嚴格大於）
+				ob.waitFillCooldown(len(trades))
+				maker := bestAsk.Front()
+				if ob.selfTrade(o, maker) {
+					if ob.preventSelfTrade(o, maker) {
+						continue
+					}
+					break
 				}
+				if ob.belowMinTrade(o, maker) {
+					if ob.dropSmallMaker(maker) {
+						continue
+					}
+					// 與最佳掛單無法成交，剩餘部分若掛單會與對手盤交叉
+					crossing = true
+					break
+				}
+				trade := ob.matchOrders(o, maker, bestAsk.Price, Bid)
+				if trade == nil {
+					// 撮合被拒絕（訂單簿狀態異常），不再撮合也不掛單
+					invalid = true
+					break
+				}
+				trades = append(trades, trade)
+				ob.recordTrade(trade, o, maker)
 				// 撮合後清理已成交訂單並更新heap
 				ob.cleanupPriceLevel(bestAsk, false)
 			} else {
@@ -240,8 +871,10 @@ func (ob *OrderBook) processLimitOrder(o *Order) []*Trade {
 		}
 
 		// 如果還有剩餘，加入買單簿
-		if o.Remaining() > 0 {
-			ob.AddBidToOrderBook(o)
+		if o.Remaining() > 0 && !deviated && !halted && !invalid && !crossing && o.Status != Cancelled && o.TimeInForce == GTC && !ob.dropSmallRemainder(o) {
+			if restErr = ob.restingError(o); restErr == nil {
+				ob.AddBidToOrderBook(o)
+			}
 		}
 	} else {
 		// 賣單，先嘗試與買單撮合
@@ -254,15 +887,42 @@ func (ob *OrderBook) processLimitOrder(o *Order) []*Trade {
 				continue
 			}
 
-			if o.Price <= bestBid.Price {
-				// 只有當買價 >= 賣價時才能撮合
-				trade := ob.matchOrders(bestBid.Orders[0], o, bestBid.Price)
-
-				if trade != nil {
-					trades = append(trades, trade)
-					// 【修正】將成交記錄添加到訂單簿
-					ob.Trades = append(ob.Trades, trade)
+			if ob.limitCrosses(o, bestBid.Price) {
+				if !withinReference(o, bestBid.Price) {
+					// 成交價偏離參考價格過多，停止撮合
+					deviated = true
+					break
+				}
+				if !ob.tradeBandAllows(bestBid.Price) {
+					halted = true
+					break
+				}
+				// 只有當買價 >= 賣價時才能撮合（CrossOnEqual 為 false 時需嚴格大於）
+				ob.waitFillCooldown(len(trades))
+				maker := bestBid.Front()
+				if ob.selfTrade(o, maker) {
+					if ob.preventSelfTrade(o, maker) {
+						continue
+					}
+					break
+				}
+				if ob.belowMinTrade(o, maker) {
+					if ob.dropSmallMaker(maker) {
+						continue
+					}
+					// 與最佳掛單無法成交，剩餘部分若掛單會與對手盤交叉
+					crossing = true
+					break
 				}
+				trade := ob.matchOrders(maker, o, bestBid.Price, Ask)
+				if trade == nil {
+					// 撮合被拒絕（訂單簿狀態異常），不再撮合也不掛單
+					invalid = true
+					break
+				}
+				trades = append(trades, trade)
+				// 【修正】將成交記錄添加到訂單簿
+				ob.recordTrade(trade, maker, o)
 				// 撮合後清理已成交訂單並更新heap
 				ob.cleanupPriceLevel(bestBid, true)
 			} else {
@@ -272,20 +932,332 @@ func (ob *OrderBook) processLimitOrder(o *Order) []*Trade {
 		}
 
 		// 如果還有剩餘，加入賣單簿
-		if o.Remaining() > 0 {
-			ob.AddAskToOrderBook(o)
+		if o.Remaining() > 0 && !deviated && !halted && !invalid && !crossing && o.Status != Cancelled && o.TimeInForce == GTC && !ob.dropSmallRemainder(o) {
+			if restErr = ob.restingError(o); restErr == nil {
+				ob.AddAskToOrderBook(o)
+			}
 		}
 	}
-	return trades
+
+	if halted {
+		return trades, ob.rejectResting(o, ErrBookHalted)
+	}
+	if invalid {
+		return trades, ob.rejectResting(o, ErrMatchInvariant)
+	}
+	if restErr != nil {
+		return trades, ob.rejectResting(o, restErr)
+	}
+
+	// 參考價格保護觸發：剩餘部分若掛單仍會與對手盤交叉，因此取消；完全未成交時視為拒絕
+	if deviated && o.Remaining() > 0 {
+		o.Status = Cancelled
+		o.CancelReason = PriceProtection
+		if len(trades) == 0 {
+			return trades, &OrderError{OrderID: o.ID, Err: ErrPriceDeviation}
+		}
+	}
+	// 剩餘部分無法與對手最佳掛單成交又不能交叉掛單：不足最小成交量時按 SmallResidualPolicy 處理，否則取消
+	if crossing && o.TimeInForce == GTC && o.Remaining() > 0 && o.Status != Cancelled && !ob.dropSmallRemainder(o) {
+		o.Status = Cancelled
+		o.CancelReason = CrossingRemainder
+	}
+	// IOC／FOK：撮合後的剩餘部分直接取消，返回已發生的成交
+	if o.TimeInForce != GTC && o.Remaining() > 0 && o.Status != Cancelled {
+		o.Status = Cancelled
+		o.CancelReason = IOCRemainder
+		if o.TimeInForce == FOK {
+			o.CancelReason = FOKUnfilled
+		}
+	}
+	return trades, nil
+}
+
+// 剩餘部分掛單前的檢查，返回拒絕原因；nil 表示可以掛單（呼叫者需持有鎖）
+func (ob *OrderBook) restingError(o *Order) error {
+	if ob.state == BookDraining {
+		return ErrBookDraining
+	}
+	ob.pruneStaleTops()
+	if ob.wouldLock(o) {
+		return ErrWouldLock
+	}
+	if !ob.improvesEnough(o) {
+		return ErrInsufficientImprovement
+	}
+	if ob.levelFull(o) {
+		return ErrLevelFull
+	}
+	return nil
+}
+
+// 訂單所在價格層級的掛單數是否已達 MaxOrdersPerLevel
+func (ob *OrderBook) levelFull(o *Order) bool {
+	if ob.MaxOrdersPerLevel <= 0 {
+		return false
+	}
+	levels := ob.BidLevels
+	if o.Side == Ask {
+		levels = ob.AskLevels
+	}
+	level, ok := levels[o.Price]
+	return ok && level.Len() >= ob.MaxOrdersPerLevel
+}
+
+// 掛單價格是否會與對手最佳價相同而造成鎖定市場
+func (ob *OrderBook) wouldLock(o *Order) bool {
+	if !ob.RejectLockingOrders {
+		return false
+	}
+	if o.Side == Bid {
+		return ob.Asks.Len() > 0 && ob.Asks.Peek().Price == o.Price
+	}
+	return ob.Bids.Len() > 0 && ob.Bids.Peek().Price == o.Price
+}
+
+// 改善同方向最佳價時，改善幅度是否達到 MinImprovementTicks 個最小價位；不改善最佳價的掛單不受限
+func (ob *OrderBook) improvesEnough(o *Order) bool {
+	if ob.MinImprovementTicks <= 0 || ob.TickSize <= 0 {
+		return true
+	}
+	var improvement Decimal
+	if o.Side == Bid {
+		if ob.Bids.Len() == 0 {
+			return true
+		}
+		improvement = o.Price - ob.Bids.Peek().Price
+	} else {
+		if ob.Asks.Len() == 0 {
+			return true
+		}
+		improvement = ob.Asks.Peek().Price - o.Price
+	}
+	if improvement <= 0 {
+		return true
+	}
+	return improvement >= Decimal(ob.MinImprovementTicks)*ob.TickSize
+}
+
+// 拒絕剩餘部分的掛單，已成交部分保留
+func (ob *OrderBook) rejectResting(o *Order, err error) error {
+	o.Status = Cancelled
+	o.CancelReason = Rejected
+	return &OrderError{OrderID: o.ID, Err: err}
+}
+
+// 受保護市價單到達最差成交價：取消剩餘部分，或以最差成交價轉為限價單掛單（呼叫者需持有鎖）
+func (ob *OrderBook) handleProtectedRemainder(o *Order, filled bool) error {
+	if ob.ProtectionRemainder == ProtectionRest {
+		o.Type = Limit
+		o.Price = o.ProtectionPrice
+		if err := ob.restingError(o); err != nil {
+			return ob.rejectResting(o, err)
+		}
+		ob.addToOrderBook(o)
+		if filled {
+			o.Status = Partial
+		}
+		return nil
+	}
+	o.Status = Cancelled
+	o.CancelReason = PriceProtection
+	return nil
+}
+
+// 成交價是否在訂單的參考價格容忍範圍內
+func withinReference(o *Order, price Decimal) bool {
+	if o.ReferencePrice == 0 {
+		return true
+	}
+	deviationBps := abs(price-o.ReferencePrice).Float64() / abs(o.ReferencePrice).Float64() * 10000
+	return deviationBps <= o.ReferenceToleranceBps
+}
+
+// 最佳對手層級能否單獨完全成交該訂單（限價單還需價格可撮合）
+func (ob *OrderBook) aonLevelSatisfied(o *Order) bool {
+	var best *PriceLevel
+	if o.Side == Bid {
+		best = ob.Asks.Peek()
+	} else {
+		best = ob.Bids.Peek()
+	}
+	if best == nil || best.isEmpty() {
+		return false
+	}
+	if o.Type == Limit && !ob.limitCrosses(o, best.Price) {
+		return false
+	}
+	return best.Quantity >= o.Remaining()
+}
+
+// 模擬撮合中的一筆成交，以掛單方價格成交
+type simulatedFill struct {
+	maker    *Order
+	price    Decimal
+	quantity Decimal
+}
+
+// 唯讀模擬的結果：remaining 為吃單後的剩餘數量；blocked 表示撮合因參考價格、市價保護、
+// 價格帶暫停、自成交防範或最小成交量而提前停止，剩餘部分不會掛單
+type takeSimulation struct {
+	fills     []simulatedFill
+	remaining Decimal
+	blocked   bool
+}
+
+// 進場訂單吃單的唯讀模擬：按撮合迴圈的價格、時間優先及同樣的限制（限價及受保護市價單的最差成交價、
+// 參考價格、價格帶暫停、自成交防範、最小成交量、手數取整、碎量掛單的取消）逐筆推演。
+// 冰山單先以顯示數量成交，其餘按 DisplayQuantity 分段排到層級隊尾
+// （不含顯示數量的隨機浮動）（呼叫者需持有鎖）
+func (ob *OrderBook) simulateTake(o *Order) takeSimulation {
+	opposite := Ask
+	if o.Side == Ask {
+		opposite = Bid
+	}
+	type chunk struct {
+		maker    *Order
+		quantity Decimal
+	}
+	sim := takeSimulation{remaining: o.Remaining()}
+	var lastPrice Decimal
+	if len(ob.Trades) > 0 {
+		lastPrice = ob.Trades[len(ob.Trades)-1].Price
+	}
+	for _, level := range ob.sortedLevels(opposite) {
+		price := level.Price
+		if o.Type == Limit && !ob.limitCrosses(o, price) {
+			return sim
+		}
+		if (o.Type == Limit && !withinReference(o, price)) || !o.withinProtection(price) {
+			sim.blocked = true
+			return sim
+		}
+		if _, ok := ob.withinBand(price, lastPrice); !ok && ob.HaltOnBandBreach {
+			sim.blocked = true
+			return sim
+		}
+
+		queue := make([]chunk, 0, level.Len())
+		hidden := make(map[*Order]Decimal)
+		for _, maker := range level.OrderList() {
+			queue = append(queue, chunk{maker: maker, quantity: maker.matchable()})
+			hidden[maker] = maker.Remaining() - maker.matchable()
+		}
+		for i := 0; i < len(queue); i++ {
+			c := queue[i]
+			if ob.selfTrade(o, c.maker) {
+				if ob.SelfTradePolicy == STPCancelResting {
+					continue
+				}
+				sim.blocked = true
+				return sim
+			}
+			quantity := min(sim.remaining, c.quantity)
+			fill := ob.roundFill(quantity)
+			if fill <= 0 || (ob.MinTradeSize > 0 && quantity < ob.MinTradeSize) {
+				if ob.droppableMaker(c.maker) {
+					continue
+				}
+				sim.blocked = true
+				return sim
+			}
+			sim.fills = append(sim.fills, simulatedFill{maker: c.maker, price: price, quantity: fill})
+			sim.remaining -= fill
+			lastPrice = price
+			if sim.remaining <= 0 {
+				return sim
+			}
+			if leftover := c.quantity - fill; leftover > 0 {
+				// 取整後的剩餘部分仍在隊首，不足一手且按 DustPolicy 取消時跳過
+				if ob.DustPolicy == DustCancel && ob.LotSize > 0 && leftover+hidden[c.maker] < ob.LotSize {
+					continue
+				}
+				queue[i].quantity = leftover
+				i--
+				continue
+			}
+			if rest := hidden[c.maker]; rest > 0 {
+				next := min(c.maker.DisplayQuantity, rest)
+				hidden[c.maker] = rest - next
+				queue = append(queue, chunk{maker: c.maker, quantity: next})
+			}
+		}
+	}
+	return sim
+}
+
+// FOK 進場前檢查：模擬吃單可以全部成交時返回 true（呼叫者需持有鎖）
+func (ob *OrderBook) fokSatisfiable(o *Order) bool {
+	return ob.simulateTake(o).remaining <= 0
+}
+
+// 限價單進場時是否會與對手最佳價成交（價格相同時依 CrossOnEqual）（呼叫者需持有鎖）
+func (ob *OrderBook) wouldTake(o *Order) bool {
+	ob.pruneStaleTops()
+	best := ob.Asks.Peek()
+	if o.Side == Ask {
+		best = ob.Bids.Peek()
+	}
+	return best != nil && ob.limitCrosses(o, best.Price)
+}
+
+// FOK 無法全部成交：整筆取消，訂單簿不變
+func (ob *OrderBook) killUnfilled(o *Order) {
+	o.Status = Cancelled
+	o.CancelReason = FOKUnfilled
+}
+
+// 將訂單掛到所屬一邊的訂單簿
+func (ob *OrderBook) addToOrderBook(o *Order) {
+	if o.Side == Bid {
+		ob.AddBidToOrderBook(o)
+	} else {
+		ob.AddAskToOrderBook(o)
+	}
+}
+
+// 限價單是否能與對手價撮合
+func (ob *OrderBook) limitCrosses(o *Order, oppositePrice Decimal) bool {
+	if o.Price == oppositePrice {
+		return ob.CrossOnEqual
+	}
+	if o.Side == Bid {
+		return o.Price > oppositePrice
+	}
+	return o.Price < oppositePrice
 }
 
 // 處理市價單
-func (ob *OrderBook) processMarketOrder(o *Order) []*Trade {
+func (ob *OrderBook) processMarketOrder(o *Order) ([]*Trade, error) {
 	trades := make([]*Trade, 0)
 
+	if (o.Side == Bid && !ob.hasLiquidity(Ask)) || (o.Side == Ask && !ob.hasLiquidity(Bid)) {
+		o.Status = Cancelled
+		o.CancelReason = NoLiquidity
+		return trades, &OrderError{OrderID: o.ID, Err: ErrNoLiquidity}
+	}
+
+	if ob.spreadTooWide() {
+		o.Status = Cancelled
+		o.CancelReason = Rejected
+		return trades, &OrderError{OrderID: o.ID, Err: ErrSpreadTooWide}
+	}
+
+	if o.TimeInForce == FOK && !ob.fokSatisfiable(o) {
+		ob.killUnfilled(o)
+		return trades, nil
+	}
+
+	if o.AONLevel && !ob.aonLevelSatisfied(o) {
+		o.Status = Cancelled
+		o.CancelReason = LevelInsufficient
+		return trades, &OrderError{OrderID: o.ID, Err: ErrInsufficientLiquidity}
+	}
+
+	protected, halted := false, false
 	if o.Side == Bid {
 		// 買單，與最低價賣單撮合
-		for o.Remaining() > 0 && ob.Asks.Len() > 0 {
+		for o.Remaining() > 0 && !o.quoteTargetReached() && ob.Asks.Len() > 0 {
 			bestAsk := ob.Asks.Peek()
 
 			if bestAsk.isEmpty() {
@@ -293,93 +1265,302 @@ func (ob *OrderBook) processMarketOrder(o *Order) []*Trade {
 				delete(ob.AskLevels, bestAsk.Price)
 				continue
 
+			} else if !o.withinProtection(bestAsk.Price) {
+				protected = true
+				break
+			} else if !ob.tradeBandAllows(bestAsk.Price) {
+				halted = true
+				break
 			} else {
-				trade := ob.matchOrders(o, bestAsk.Orders[0], bestAsk.Price)
-				if trade != nil {
-					trades = append(trades, trade)
-					// 將成交記錄添加到訂單簿
-					ob.Trades = append(ob.Trades, trade)
+				ob.waitFillCooldown(len(trades))
+				maker := bestAsk.Front()
+				if ob.selfTrade(o, maker) {
+					if ob.preventSelfTrade(o, maker) {
+						continue
+					}
+					break
+				}
+				if ob.belowMinTrade(o, maker) {
+					if ob.dropSmallMaker(maker) {
+						continue
+					}
+					break
 				}
+				trade := ob.matchOrders(o, maker, bestAsk.Price, Bid)
+				if trade == nil {
+					// 撮合被拒絕（不應發生），停止撮合以免無限循環
+					break
+				}
+				trades = append(trades, trade)
+				// 將成交記錄添加到訂單簿
+				ob.recordTrade(trade, o, maker)
 			}
 			// 撮合後清理已成交訂單並更新heap
 			ob.cleanupPriceLevel(bestAsk, false)
 		}
 	} else {
 		// 賣單 ，與最高價買單撮合
-		for o.Remaining() > 0 && ob.Bids.Len() > 0 {
+		for o.Remaining() > 0 && !o.quoteTargetReached() && ob.Bids.Len() > 0 {
 			bestBid := ob.Bids.Peek()
 
 			if bestBid.isEmpty() {
 				heap.Pop(ob.Bids)
 				delete(ob.BidLevels, bestBid.Price)
 				continue
+			} else if !o.withinProtection(bestBid.Price) {
+				protected = true
+				break
+			} else if !ob.tradeBandAllows(bestBid.Price) {
+				halted = true
+				break
 			} else {
-				trade := ob.matchOrders(o, bestBid.Orders[0], bestBid.Price)
-				if trade != nil {
-					trades = append(trades, trade)
-					// 將成交記錄添加到訂單簿
-					ob.Trades = append(ob.Trades, trade)
+				ob.waitFillCooldown(len(trades))
+				maker := bestBid.Front()
+				if ob.selfTrade(o, maker) {
+					if ob.preventSelfTrade(o, maker) {
+						continue
+					}
+					break
+				}
+				if ob.belowMinTrade(o, maker) {
+					if ob.dropSmallMaker(maker) {
+						continue
+					}
+					break
+				}
+				trade := ob.matchOrders(maker, o, bestBid.Price, Ask)
+				if trade == nil {
+					// 撮合被拒絕（不應發生），停止撮合以免無限循環
+					break
 				}
+				trades = append(trades, trade)
+				// 將成交記錄添加到訂單簿
+				ob.recordTrade(trade, maker, o)
 
 			}
 			// 撮合後清理已成交訂單並更新heap
-			ob.cleanupPriceLevel(bestBid, false)
+			ob.cleanupPriceLevel(bestBid, true)
 		}
 	}
 
+	// 報價幣目標達成即視為完全成交
+	if o.quoteTargetReached() {
+		o.Status = Filled
+		return trades, nil
+	}
+
+	if halted {
+		return trades, ob.rejectResting(o, ErrBookHalted)
+	}
+	if protected && o.Remaining() > 0 {
+		return trades, ob.handleProtectedRemainder(o, len(trades) > 0)
+	}
+
 	// 市價單如果沒有完全成交，剩餘部分取消
-	if o.Remaining() > 0 {
+	if o.Remaining() > 0 && o.Status != Cancelled {
 		o.Status = Cancelled
+		o.CancelReason = MarketRemainder
 	}
 
-	return trades
+	return trades, nil
 }
 
-// 撮合兩個訂單
-func (ob *OrderBook) matchOrders(buyOrder, sellOrder *Order, price float64) *Trade {
-	quantity := min(buyOrder.Remaining(), sellOrder.Remaining())
+// 某一邊是否有非空的價格層級
+func (ob *OrderBook) hasLiquidity(side OrderSide) bool {
+	if side == Bid {
+		for _, level := range *ob.Bids {
+			if !level.isEmpty() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, level := range *ob.Asks {
+		if !level.isEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// 同一訂單第二筆及之後的成交前等待 FillCooldown（持有鎖期間等待）
+func (ob *OrderBook) waitFillCooldown(fills int) {
+	if ob.FillCooldown > 0 && fills > 0 {
+		ob.Clock.Sleep(ob.FillCooldown)
+	}
+}
+
+// 記錄異常狀況
+func (ob *OrderBook) logf(format string, args ...any) {
+	if ob.Logf != nil {
+		ob.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
 
+// 撮合兩個訂單，aggressor 為主動進場訂單的方向。
+// 雙方剩餘數量相同時兩者都完全成交並移出未成交訂單；
+// 兩個市價單之間沒有可用的成交價、雙方為同一訂單、或成交金額溢位時，不應發生，防禦性地返回 nil 且不修改任何狀態
+func (ob *OrderBook) matchOrders(buyOrder, sellOrder *Order, price Decimal, aggressor OrderSide) *Trade {
+	if buyOrder.Type == Market && sellOrder.Type == Market {
+		return nil
+	}
+	if buyOrder == sellOrder || buyOrder.ID == sellOrder.ID {
+		// 同一訂單同時出現在買賣兩邊，表示訂單簿狀態已損壞，拒絕自我成交
+		ob.logf("orderbook %s: order %s on both sides of a match, skipped", ob.Symbol, buyOrder.ID)
+		return nil
+	}
+	quantity := min(buyOrder.matchable(), sellOrder.matchable())
+	quantity = min(quantity, min(buyOrder.quoteCap(price), sellOrder.quoteCap(price)))
+	if quantity = ob.roundFill(quantity); quantity <= 0 {
+		return nil
+	}
+	// 修改任何訂單前先算好成交金額，溢位時不成交
+	notional, err := quantity.CheckedMul(price)
+	if err != nil {
+		ob.logf("orderbook %s: notional of %s x %s overflows, match skipped", ob.Symbol, quantity, price)
+		return nil
+	}
+	buyQuote, buyErr := buyOrder.quoteFilled.CheckedAdd(notional)
+	sellQuote, sellErr := sellOrder.quoteFilled.CheckedAdd(notional)
+	if buyErr != nil || sellErr != nil {
+		ob.logf("orderbook %s: filled notional of %s or %s overflows, match skipped", ob.Symbol, buyOrder.ID, sellOrder.ID)
+		return nil
+	}
+
+	mid := ob.fillMid()
 	buyOrder.FilledQuantity += quantity
 	sellOrder.FilledQuantity += quantity
+	ob.reduceLevelQuantity(buyOrder, quantity)
+	ob.reduceLevelQuantity(sellOrder, quantity)
+	buyOrder.quoteFilled = buyQuote
+	sellOrder.quoteFilled = sellQuote
+	buyOrder.consumeVisible(quantity)
+	sellOrder.consumeVisible(quantity)
 
 	// 更新訂單狀態
 	if buyOrder.IsFilled() {
 		buyOrder.Status = Filled
 		delete(ob.UnFilledOrders, buyOrder.ID)
+		delete(ob.pegged, buyOrder.ID)
 	} else {
 		buyOrder.Status = Partial
 	}
 	if sellOrder.IsFilled() {
 		sellOrder.Status = Filled
 		delete(ob.UnFilledOrders, sellOrder.ID)
+		delete(ob.pegged, sellOrder.ID)
 	} else {
 		sellOrder.Status = Partial
 	}
 
 	// 創建成交記錄
 	trade := &Trade{
-		ID:          GenerateTradeID(),
-		BuyOrderId:  buyOrder.ID,
-		SellOrderId: sellOrder.ID,
-		Price:       price,
-		Quantity:    quantity,
-		Timestamp:   time.Now(),
+		ID:            ob.nextTradeID(),
+		BuyOrderId:    buyOrder.ID,
+		SellOrderId:   sellOrder.ID,
+		BuyUserID:     buyOrder.UserID,
+		SellUserID:    sellOrder.UserID,
+		AggressorSide: aggressor,
+		Price:         price,
+		Quantity:      quantity,
+		Timestamp:     ob.Clock.Now(),
+		Mid:           mid,
+		BuyMetadata:   maps.Clone(buyOrder.Metadata),
+		SellMetadata:  maps.Clone(sellOrder.Metadata),
 	}
+	ob.checkStops(price)
 
 	return trade
 }
 
+// 分配成交ID（呼叫者需持有鎖）
+func (ob *OrderBook) nextTradeID() string {
+	if !ob.SequentialTradeIDs {
+		return GenerateTradeID()
+	}
+	ob.tradeSeq++
+	return fmt.Sprintf("trade_%d", ob.tradeSeq)
+}
+
+// 分配下一個時間優先序號（呼叫者需持有鎖）
+func (ob *OrderBook) nextPriority() uint64 {
+	ob.prioritySeq++
+	return ob.prioritySeq
+}
+
+// 記錄成交並按買賣雙方訂單ID建立索引
+func (ob *OrderBook) recordTrade(trade *Trade, buy, sell *Order) {
+	ob.Trades = append(ob.Trades, trade)
+	ob.tradesByOrder[trade.BuyOrderId] = append(ob.tradesByOrder[trade.BuyOrderId], trade)
+	ob.tradesByOrder[trade.SellOrderId] = append(ob.tradesByOrder[trade.SellOrderId], trade)
+	if ob.OnTrade != nil {
+		ob.OnTrade(trade)
+	}
+	ob.publishTrade(trade, buy, sell)
+}
+
+// LastTradePrice 返回最新成交價，尚無成交時 ok 為 false
+func (ob *OrderBook) LastTradePrice() (price Decimal, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if len(ob.Trades) == 0 {
+		return 0, false
+	}
+	return ob.Trades[len(ob.Trades)-1].Price, true
+}
+
+// TradesForOrder 返回某訂單作為買方或賣方參與的所有成交，按成交順序排列
+func (ob *OrderBook) TradesForOrder(orderID string) []Trade {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	trades := make([]Trade, 0, len(ob.tradesByOrder[orderID]))
+	for _, t := range ob.tradesByOrder[orderID] {
+		trades = append(trades, *t)
+	}
+	return trades
+}
+
+// OrderSnapshot 在持有讀鎖的情況下複製訂單，供外部在撮合進行中安全讀取訂單狀態與成交量
+func (ob *OrderBook) OrderSnapshot(o *Order) Order {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return *o
+}
+
+// OrderFills 返回某訂單參與的所有成交、總成交量及成交均價（VWAP）
+func (ob *OrderBook) OrderFills(orderID string) (trades []Trade, totalQty Decimal, avgPrice float64) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	var notional Decimal
+	for _, t := range ob.tradesByOrder[orderID] {
+		trades = append(trades, *t)
+		totalQty += t.Quantity
+		notional += t.Price.Mul(t.Quantity)
+	}
+	if totalQty > 0 {
+		avgPrice = notional.Float64() / totalQty.Float64()
+	}
+	return
+}
+
 func (ob *OrderBook) AddBidToOrderBook(o *Order) {
 	ob.UnFilledOrders[o.ID] = o
+	o.priority = ob.nextPriority()
+	if o.IsIceberg() {
+		o.visible = ob.nextDisplayQuantity(o)
+	}
 
 	if level, exists := ob.BidLevels[o.Price]; exists {
 		level.AddOrder(o)
 	} else {
-		newLevel := &PriceLevel{
-			Price:    o.Price,
-			Orders:   []*Order{o},
-			Quantity: o.Remaining(),
-		}
+		newLevel := ob.newLevel(Bid, o.Price)
+		newLevel.AddOrder(o)
 		ob.BidLevels[o.Price] = newLevel
 		heap.Push(ob.Bids, newLevel)
 	}
@@ -387,15 +1568,16 @@ func (ob *OrderBook) AddBidToOrderBook(o *Order) {
 
 func (ob *OrderBook) AddAskToOrderBook(o *Order) {
 	ob.UnFilledOrders[o.ID] = o
+	o.priority = ob.nextPriority()
+	if o.IsIceberg() {
+		o.visible = ob.nextDisplayQuantity(o)
+	}
 
 	if level, exists := ob.AskLevels[o.Price]; exists {
 		level.AddOrder(o)
 	} else {
-		newLevel := &PriceLevel{
-			Price:    o.Price,
-			Orders:   []*Order{o},
-			Quantity: o.Remaining(),
-		}
+		newLevel := ob.newLevel(Ask, o.Price)
+		newLevel.AddOrder(o)
 		ob.AskLevels[o.Price] = newLevel
 		heap.Push(ob.Asks, newLevel)
 	}
@@ -403,15 +1585,89 @@ func (ob *OrderBook) AddAskToOrderBook(o *Order) {
 
 // 【新增】清理價格層級中的已成交訂單
 func (ob *OrderBook) cleanupPriceLevel(level *PriceLevel, isBid bool) {
-	level.RemoveFilledOrders()
+	// 撮合從隊首開始，已完全成交的訂單只會在隊首
+	for front := level.Front(); front != nil && front.IsFilled(); front = level.Front() {
+		level.PopFront()
+	}
+	ob.cancelDust(level)
+	ob.refillIceberg(level)
 
 	if level.isEmpty() {
-		// 移除空的價格層級
+		// 移除空的價格層級；不在堆頂的空層級留在堆中，待其到達堆頂時再彈出
 		if isBid {
-			heap.Pop(ob.Bids)
-			delete(ob.BidLevels, level.Price)
+			if ob.BidLevels[level.Price] == level {
+				delete(ob.BidLevels, level.Price)
+			}
 		} else {
-			heap.Pop(ob.Asks)
+			if ob.AskLevels[level.Price] == level {
+				delete(ob.AskLevels, level.Price)
+			}
+		}
+		ob.pruneStaleTops()
+		ob.maybeCompact()
+	}
+}
+
+// 掛單成交後扣減所在價格層級的總量；訂單不在層級中（如吃單方）時不變（呼叫者需持有鎖）
+func (ob *OrderBook) reduceLevelQuantity(o *Order, quantity Decimal) {
+	level := ob.BidLevels[o.Price]
+	if o.Side == Ask {
+		level = ob.AskLevels[o.Price]
+	}
+	if level == nil {
+		return
+	}
+	if _, ok := level.nodes[o.ID]; ok {
+		level.Quantity -= quantity
+		level.touch()
+	}
+}
+
+// 按 DustPolicy 取消隊首剩餘不足一手的掛單（呼叫者需持有鎖）
+func (ob *OrderBook) cancelDust(level *PriceLevel) {
+	if ob.DustPolicy != DustCancel || ob.LotSize <= 0 {
+		return
+	}
+	front := level.Front()
+	if front == nil || front.IsFilled() || front.Remaining() >= ob.LotSize {
+		return
+	}
+	level.RemoveOrder(front.ID)
+	front.Status = Cancelled
+	front.CancelReason = DustRemainder
+	delete(ob.UnFilledOrders, front.ID)
+	delete(ob.pegged, front.ID)
+	ob.forgetSubmissionMid(front)
+}
+
+// 當前價差是否超過 MaxSpreadBpsForMarket；單邊簿無法計算價差，不視為過寬（呼叫者需持有鎖）
+func (ob *OrderBook) spreadTooWide() bool {
+	if ob.MaxSpreadBpsForMarket <= 0 {
+		return false
+	}
+	ob.pruneStaleTops()
+	if ob.Bids.Len() == 0 || ob.Asks.Len() == 0 {
+		return false
+	}
+	bestBid, bestAsk := ob.Bids.Peek().Price, ob.Asks.Peek().Price
+	mid := (bestBid + bestAsk) / 2
+	if mid <= 0 {
+		return false
+	}
+	return (bestAsk-bestBid).Float64()/mid.Float64()*10000 > ob.MaxSpreadBpsForMarket
+}
+
+// 彈出堆頂的空層級，確保堆頂永遠是有效的最佳價格
+func (ob *OrderBook) pruneStaleTops() {
+	for ob.Bids.Len() > 0 && ob.Bids.Peek().isEmpty() {
+		level := heap.Pop(ob.Bids).(*PriceLevel)
+		if ob.BidLevels[level.Price] == level {
+			delete(ob.BidLevels, level.Price)
+		}
+	}
+	for ob.Asks.Len() > 0 && ob.Asks.Peek().isEmpty() {
+		level := heap.Pop(ob.Asks).(*PriceLevel)
+		if ob.AskLevels[level.Price] == level {
 			delete(ob.AskLevels, level.Price)
 		}
 	}
@@ -422,13 +1678,25 @@ func (ob *OrderBook) CancelOrder(orderID string) bool {
 	ob.mutex.Lock()
 	defer ob.mutex.Unlock()
 
+	ok := ob.cancelOrder(orderID, UserRequested)
+	ob.afterChange()
+	ob.repegOrders()
+	return ok
+}
+
+// 以指定原因取消訂單（呼叫者需持有鎖）
+func (ob *OrderBook) cancelOrder(orderID string, reason CancelReason) bool {
 	order, exists := ob.UnFilledOrders[orderID]
 	if !exists {
-		return false
+		return ob.cancelStop(orderID, reason)
 	}
 
 	order.Status = Cancelled
+	order.CancelReason = reason
 	delete(ob.UnFilledOrders, orderID)
+	delete(ob.pegged, orderID)
+	ob.forgetSubmissionMid(order)
+	ob.sequence++
 
 	// 從價格層級中移除該訂單
 	var level *PriceLevel
@@ -443,30 +1711,73 @@ func (ob *OrderBook) CancelOrder(orderID string) bool {
 	}
 
 	if level != nil {
-		// 移除訂單
-		newOrders := make([]*Order, 0)
-		for _, o := range level.Orders {
-			if o.ID != orderID {
-				newOrders = append(newOrders, o)
-			}
-		}
-		level.Orders = newOrders
-		level.Quantity = 0
-		for _, o := range newOrders {
-			level.Quantity += o.Remaining()
-		}
-
+		ob.cancelStats.record(level.Len())
+		level.RemoveOrder(orderID)
 		ob.cleanupPriceLevel(level, isBid)
 	}
+	ob.publishEvent(EventOrderCancel, nil, order)
 
 	return true
 }
 
+// CancelUserOrders 取消某用戶的所有未成交訂單（含尚未轉為限價單的停損單），返回被取消的訂單ID
+func (ob *OrderBook) CancelUserOrders(userID string) []string {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ids := make([]string, 0)
+	for id, o := range ob.UnFilledOrders {
+		if o.UserID == userID {
+			ids = append(ids, id)
+		}
+	}
+	for _, queue := range [][]*Order{ob.stopOrders, ob.triggeredStops} {
+		for _, o := range queue {
+			if o.UserID == userID {
+				ids = append(ids, o.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		ob.cancelOrder(id, UserRequested)
+	}
+	ob.afterChange()
+	return ids
+}
+
+// Sequence 返回訂單簿目前的序號（每次下單或撤單遞增）
+func (ob *OrderBook) Sequence() uint64 {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.sequence
+}
+
 // 【新增】獲取最佳買賣價
-func (ob *OrderBook) GetBestBidAsk() (bestBid, bestAsk float64, ok bool) {
+func (ob *OrderBook) GetBestBidAsk() (bestBid, bestAsk Decimal, ok bool) {
 	ob.mutex.RLock()
 	defer ob.mutex.RUnlock()
 
+	if ob.StickyBestWindow <= 0 {
+		return ob.bestBidAsk()
+	}
+
+	// 顯示用：套用最佳價格暫留
+	now := ob.Clock.Now()
+	bidOK, askOK := ob.Bids.Len() > 0, ob.Asks.Len() > 0
+	if bidOK {
+		bestBid = ob.Bids.Peek().Price
+	}
+	if askOK {
+		bestAsk = ob.Asks.Peek().Price
+	}
+	bestBid, bidOK = ob.sticky.bid.display(bestBid, bidOK, now)
+	bestAsk, askOK = ob.sticky.ask.display(bestAsk, askOK, now)
+	return bestBid, bestAsk, bidOK || askOK
+}
+
+// 最佳買賣價（呼叫者需持有鎖）
+func (ob *OrderBook) bestBidAsk() (bestBid, bestAsk Decimal, ok bool) {
 	if ob.Bids.Len() > 0 {
 		bestBid = ob.Bids.Peek().Price
 		ok = true
@@ -481,31 +1792,16 @@ func (ob *OrderBook) GetBestBidAsk() (bestBid, bestAsk float64, ok bool) {
 }
 
 // 【新增】獲取市場深度
-func (ob *OrderBook) GetDepth(levels int) (bids, asks []PriceLevel) {
-	ob.mutex.RLock()
-	defer ob.mutex.RUnlock()
-
-	// 獲取買單深度
-	bidCount := 0
-	for i := 0; i < ob.Bids.Len() && bidCount < levels; i++ {
-		level := (*ob.Bids)[i]
-		if !level.isEmpty() {
-			bids = append(bids, *level)
-			bidCount++
-		}
+// 按價格優先排序（買盤由高到低、賣盤由低到高），返回不含訂單指標的輕量層級
+func (ob *OrderBook) GetDepth(levels int) (bids, asks []DepthLevel) {
+	if levels <= 0 {
+		return nil, nil
 	}
 
-	// 獲取賣單深度
-	askCount := 0
-	for i := 0; i < ob.Asks.Len() && askCount < levels; i++ {
-		level := (*ob.Asks)[i]
-		if !level.isEmpty() {
-			asks = append(asks, *level)
-			askCount++
-		}
-	}
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
 
-	return
+	return topDepth(ob.topLevels(Bid, levels), 0), topDepth(ob.topLevels(Ask, levels), 0)
 }
 
 // 生成交易ID的輔助函數
@@ -513,24 +1809,21 @@ func GenerateTradeID() string {
 	return fmt.Sprintf("trade_%d", time.Now().UnixNano())
 }
 
-// min 輔助函數
-func min(a, b float64) float64 {
-	if a < b {
-		return a
-	}
-	return b
+// 生成訂單ID的輔助函數
+func GenerateOrderID() string {
+	return fmt.Sprintf("order_%d", time.Now().UnixNano())
 }
 
 // 【新增】輔助函數 - 格式化訂單信息
 func (o *Order) String() string {
-	return fmt.Sprintf("Order[ID=%s, %s %s, Price=%.2f, Qty=%.4f, Filled=%.4f, Status=%s]",
+	return fmt.Sprintf("Order[ID=%s, %s %s, Price=%s, Qty=%s, Filled=%s, Status=%s]",
 		o.ID, GetSideName(o.Side), GetTypeName(o.Type),
 		o.Price, o.Quantity, o.FilledQuantity, GetStatusName(o.Status))
 }
 
 // 【新增】輔助函數 - 格式化成交信息
 func (t *Trade) String() string {
-	return fmt.Sprintf("Trade[ID=%s, Price=%.2f, Qty=%.4f, Buy=%s, Sell=%s, Time=%s]",
+	return fmt.Sprintf("Trade[ID=%s, Price=%s, Qty=%s, Buy=%s, Sell=%s, Time=%s]",
 		t.ID, t.Price, t.Quantity, t.BuyOrderId, t.SellOrderId,
 		t.Timestamp.Format("15:04:05.000"))
 }
@@ -1,7 +1,19 @@
 package orderbook
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -11,6 +23,11 @@ func generateOrderID(prefix string, id int) string {
 	return fmt.Sprintf("%s_%d_%d", prefix, id, time.Now().UnixNano()%10000)
 }
 
+// 測試用的十進位數字面值
+func dec(f float64) Decimal {
+	return NewDecimal(f)
+}
+
 // 測試基本限價單撮合
 func TestLimitOrderMatching(t *testing.T) {
 	// 創建BTC/USDT訂單簿
@@ -20,15 +37,15 @@ func TestLimitOrderMatching(t *testing.T) {
 
 	// 創建一些賣單 (Ask)
 	askOrders := []*Order{
-		{ID: generateOrderID("ASK", 1), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: 50100, Quantity: 0.5},
-		{ID: generateOrderID("ASK", 2), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: 50200, Quantity: 1.0},
-		{ID: generateOrderID("ASK", 3), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: 50150, Quantity: 0.8},
-		{ID: generateOrderID("ASK", 4), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: 50300, Quantity: 2.0},
+		{ID: generateOrderID("ASK", 1), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: dec(50100), Quantity: dec(0.5)},
+		{ID: generateOrderID("ASK", 2), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: dec(50200), Quantity: dec(1.0)},
+		{ID: generateOrderID("ASK", 3), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: dec(50150), Quantity: dec(0.8)},
+		{ID: generateOrderID("ASK", 4), Symbol: "BTCUSDT", Side: Ask, Type: Limit, Price: dec(50300), Quantity: dec(2.0)},
 	}
 
 	// 下賣單
 	for _, order := range askOrders {
-		trades := ob.PlaceOrder(order)
+		trades, _ := ob.PlaceOrder(order)
 		fmt.Printf("下單: %s\n", order.String())
 		if len(trades) > 0 {
 			fmt.Printf("  成交: %d筆\n", len(trades))
@@ -43,14 +60,14 @@ func TestLimitOrderMatching(t *testing.T) {
 
 	// 創建一些買單 (Bid)
 	bidOrders := []*Order{
-		{ID: generateOrderID("BID", 1), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: 50000, Quantity: 1.0},
-		{ID: generateOrderID("BID", 2), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: 49950, Quantity: 0.5},
-		{ID: generateOrderID("BID", 3), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: 50050, Quantity: 1.5},
+		{ID: generateOrderID("BID", 1), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: dec(50000), Quantity: dec(1.0)},
+		{ID: generateOrderID("BID", 2), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: dec(49950), Quantity: dec(0.5)},
+		{ID: generateOrderID("BID", 3), Symbol: "BTCUSDT", Side: Bid, Type: Limit, Price: dec(50050), Quantity: dec(1.5)},
 	}
 
 	fmt.Println("\n下買單:")
 	for _, order := range bidOrders {
-		trades := ob.PlaceOrder(order)
+		trades, _ := ob.PlaceOrder(order)
 		fmt.Printf("下單: %s\n", order.String())
 		if len(trades) > 0 {
 			fmt.Printf("  成交: %d筆\n", len(trades))
@@ -71,12 +88,12 @@ func TestLimitOrderMatching(t *testing.T) {
 		Symbol:   "BTCUSDT",
 		Side:     Bid,
 		Type:     Limit,
-		Price:    50150, // 能與50100和50150的賣單撮合
-		Quantity: 1.2,
+		Price:    dec(50150), // 能與50100和50150的賣單撮合
+		Quantity: dec(1.2),
 	}
 
 	fmt.Printf("下撮合買單: %s\n", matchingBuyOrder.String())
-	trades := ob.PlaceOrder(matchingBuyOrder)
+	trades, _ := ob.PlaceOrder(matchingBuyOrder)
 
 	if len(trades) > 0 {
 		fmt.Printf("成功撮合 %d 筆交易:\n", len(trades))
@@ -96,11 +113,11 @@ func TestLimitOrderMatching(t *testing.T) {
 		Symbol:   "BTCUSDT",
 		Side:     Bid,
 		Type:     Market,
-		Quantity: 0.3,
+		Quantity: dec(0.3),
 	}
 
 	fmt.Printf("下市價買單: %s\n", marketBuyOrder.String())
-	trades = ob.PlaceOrder(marketBuyOrder)
+	trades, _ = ob.PlaceOrder(marketBuyOrder)
 
 	if len(trades) > 0 {
 		fmt.Printf("市價單成交 %d 筆:\n", len(trades))
@@ -128,8 +145,8 @@ func TestLimitOrderMatching(t *testing.T) {
 	fmt.Println("\n=== 測試5: 獲取最佳價格 ===")
 	bestBid, bestAsk, ok := ob.GetBestBidAsk()
 	if ok {
-		fmt.Printf("最佳買價: %.2f, 最佳賣價: %.2f\n", bestBid, bestAsk)
-		fmt.Printf("價差: %.2f\n", bestAsk-bestBid)
+		fmt.Printf("最佳買價: %s, 最佳賣價: %s\n", bestBid, bestAsk)
+		fmt.Printf("價差: %s\n", bestAsk-bestBid)
 	}
 
 	fmt.Println("\n=== 測試6: 市場深度 ===")
@@ -137,14 +154,14 @@ func TestLimitOrderMatching(t *testing.T) {
 
 	fmt.Println("買單深度 (前5檔):")
 	for i, bid := range bids {
-		fmt.Printf("  %d. 價格: %.2f, 數量: %.4f, 訂單數: %d\n",
-			i+1, bid.Price, bid.Quantity, len(bid.Orders))
+		fmt.Printf("  %d. 價格: %s, 數量: %s, 訂單數: %d\n",
+			i+1, bid.Price, bid.Quantity, bid.Orders)
 	}
 
 	fmt.Println("賣單深度 (前5檔):")
 	for i, ask := range asks {
-		fmt.Printf("  %d. 價格: %.2f, 數量: %.4f, 訂單數: %d\n",
-			i+1, ask.Price, ask.Quantity, len(ask.Orders))
+		fmt.Printf("  %d. 價格: %s, 數量: %s, 訂單數: %d\n",
+			i+1, ask.Price, ask.Quantity, ask.Orders)
 	}
 
 	fmt.Println("\n=== 測試7: 大額訂單部分撮合 ===")
@@ -155,12 +172,12 @@ func TestLimitOrderMatching(t *testing.T) {
 		Symbol:   "BTCUSDT",
 		Side:     Bid,
 		Type:     Limit,
-		Price:    50500, // 高價，能撮合所有賣單
-		Quantity: 10.0,  // 大量
+		Price:    dec(50500), // 高價，能撮合所有賣單
+		Quantity: dec(10.0),  // 大量
 	}
 
 	fmt.Printf("下大額買單: %s\n", largeBuyOrder.String())
-	trades = ob.PlaceOrder(largeBuyOrder)
+	trades, _ = ob.PlaceOrder(largeBuyOrder)
 
 	fmt.Printf("大額訂單成交 %d 筆:\n", len(trades))
 	for _, trade := range trades {
@@ -177,46 +194,4052 @@ func TestLimitOrderMatching(t *testing.T) {
 	fmt.Printf("未成交訂單數: %d\n", len(ob.UnFilledOrders))
 
 	// 計算總成交金額
-	totalVolume := 0.0
-	totalAmount := 0.0
+	var totalVolume, totalAmount Decimal
 	for _, trade := range ob.Trades {
 		totalVolume += trade.Quantity
-		totalAmount += trade.Price * trade.Quantity
+		totalAmount += trade.Price.Mul(trade.Quantity)
 	}
-	fmt.Printf("總成交量: %.4f BTC\n", totalVolume)
-	fmt.Printf("總成交額: %.2f USDT\n", totalAmount)
+	fmt.Printf("總成交量: %s BTC\n", totalVolume)
+	fmt.Printf("總成交額: %s USDT\n", totalAmount)
 	if totalVolume > 0 {
-		fmt.Printf("平均成交價: %.2f USDT\n", totalAmount/totalVolume)
+		fmt.Printf("平均成交價: %s USDT\n", totalAmount.Div(totalVolume))
 	}
 }
 
 // 輔助函數 - 打印訂單簿狀態
 func printOrderBook(ob *OrderBook) {
-	bestBid, bestAsk, _ := ob.GetBestBidAsk()
+	ob.Dump(os.Stdout, 3)
+	fmt.Println()
+}
+
+// 暖機期間下單：大量不同價格的掛單
+func benchmarkWarmup(b *testing.B, newBook func() *OrderBook) {
+	const orders = 10000
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ob := newBook()
+		for j := 0; j < orders; j++ {
+			side := Bid
+			price := DecimalFromInt(int64(10000 - j%1000))
+			if j%2 == 1 {
+				side = Ask
+				price = DecimalFromInt(int64(20000 + j%1000))
+			}
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("o%d", j), Side: side, Type: Limit, Price: price, Quantity: dec(1)})
+		}
+	}
+}
 
-	fmt.Printf("訂單簿 %s:\n", ob.Symbol)
-	fmt.Printf("  最佳買價: %.2f\n", bestBid)
-	fmt.Printf("  最佳賣價: %.2f\n", bestAsk)
-	fmt.Printf("  買單數量: %d\n", ob.Bids.Len())
-	fmt.Printf("  賣單數量: %d\n", ob.Asks.Len())
-	fmt.Printf("  未成交訂單: %d\n", len(ob.UnFilledOrders))
+func BenchmarkWarmupDefault(b *testing.B) {
+	benchmarkWarmup(b, func() *OrderBook { return NewOrderBook("BTCUSDT") })
+}
 
-	// 顯示前3檔買賣盤
-	bids, asks := ob.GetDepth(3)
+func BenchmarkWarmupWithCapacity(b *testing.B) {
+	benchmarkWarmup(b, func() *OrderBook { return NewOrderBookWithCapacity("BTCUSDT", 1000, 10000) })
+}
 
-	if len(asks) > 0 {
-		fmt.Println("  賣盤 (前3檔):")
-		for _, ask := range asks {
-			fmt.Printf("    %.2f -> %.4f\n", ask.Price, ask.Quantity)
+// 每邊 1000 個層級、每層 5 筆掛單的深簿
+func newDeepBook() *OrderBook {
+	ob := NewOrderBook("BTCUSDT")
+	for i := 0; i < 1000; i++ {
+		for j := 0; j < 5; j++ {
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d_%d", i, j), Side: Bid, Type: Limit, Price: DecimalFromInt(int64(10000 - i)), Quantity: dec(1)})
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d_%d", i, j), Side: Ask, Type: Limit, Price: DecimalFromInt(int64(10001 + i)), Quantity: dec(1)})
 		}
 	}
+	return ob
+}
+
+// 深簿上查詢前 50 檔深度的耗時與分配
+func BenchmarkGetDepth(b *testing.B) {
+	ob := newDeepBook()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.GetDepth(50)
+	}
+}
+
+// 與 BenchmarkGetDepth 相同條件下以平行陣列取得雙邊前 50 檔
+func BenchmarkBestN(b *testing.B) {
+	ob := newDeepBook()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.BestN(Bid, 50)
+		ob.BestN(Ask, 50)
+	}
+}
+
+// 測試市價單掃過多個價格層級時，成交按價格優先、時間優先排列
+func TestSweepTradeOrdering(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
 
-	if len(bids) > 0 {
-		fmt.Println("  買盤 (前3檔):")
-		for _, bid := range bids {
-			fmt.Printf("    %.2f -> %.4f\n", bid.Price, bid.Quantity)
+	// 故意打亂下單順序
+	ob.PlaceOrder(&Order{ID: "ask_102", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask_100_a", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.5)})
+	ob.PlaceOrder(&Order{ID: "ask_101", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "ask_100_b", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.5)})
+
+	trades, _ := ob.PlaceOrder(&Order{ID: "mkt_buy", Side: Bid, Type: Market, Quantity: dec(3.5)})
+
+	want := []struct {
+		sellID   string
+		price    Decimal
+		quantity Decimal
+	}{
+		{"ask_100_a", dec(100), dec(0.5)},
+		{"ask_100_b", dec(100), dec(0.5)},
+		{"ask_101", dec(101), dec(2)},
+		{"ask_102", dec(102), dec(0.5)},
+	}
+	if len(trades) != len(want) {
+		t.Fatalf("expected %d trades, got %d", len(want), len(trades))
+	}
+	for i, w := range want {
+		tr := trades[i]
+		if tr.SellOrderId != w.sellID || tr.Price != w.price || tr.Quantity != w.quantity {
+			t.Errorf("trade %d: expected %s %s x %s, got %s %s x %s",
+				i, w.sellID, w.price, w.quantity, tr.SellOrderId, tr.Price, tr.Quantity)
+		}
+		if i > 0 && tr.Price < trades[i-1].Price {
+			t.Errorf("trade %d: price %s below previous %s", i, tr.Price, trades[i-1].Price)
 		}
 	}
+}
 
-	fmt.Println()
+// 測試市價單進入空訂單簿時返回 ErrNoLiquidity
+func TestMarketOrderNoLiquidity(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+
+	o := &Order{ID: "mkt_buy", Side: Bid, Type: Market, Quantity: dec(1)}
+	trades, err := ob.PlaceOrder(o)
+	if !errors.Is(err, ErrNoLiquidity) {
+		t.Fatalf("expected ErrNoLiquidity, got %v", err)
+	}
+	if len(trades) != 0 {
+		t.Errorf("expected no trades, got %d", len(trades))
+	}
+	if o.Status != Cancelled || o.CancelReason != NoLiquidity {
+		t.Errorf("expected Cancelled/NoLiquidity, got %s/%s", GetStatusName(o.Status), GetCancelReasonName(o.CancelReason))
+	}
+
+	// 部分成交的市價單不算無流動性
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.5)})
+	partial := &Order{ID: "mkt_buy2", Side: Bid, Type: Market, Quantity: dec(1)}
+	trades, err = ob.PlaceOrder(partial)
+	if err != nil {
+		t.Fatalf("expected no error for partial fill, got %v", err)
+	}
+	if len(trades) != 1 || partial.CancelReason != MarketRemainder {
+		t.Errorf("expected 1 trade and MarketRemainder, got %d trades and %s", len(trades), GetCancelReasonName(partial.CancelReason))
+	}
+}
+
+// 測試用稽核紀錄輸出
+type memoryAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) Record(rec AuditRecord) {
+	s.records = append(s.records, rec)
+}
+
+// 測試稽核紀錄記錄撮合前後的最佳價格
+func TestAuditRecordsTopOfBook(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	sink := &memoryAuditSink{}
+
+	ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	// 未啟用時不記錄
+	ob.AuditSink = sink
+	ob.PlaceOrder(&Order{ID: "ignored", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+	if len(sink.records) != 0 {
+		t.Fatalf("expected no records while disabled, got %d", len(sink.records))
+	}
+
+	ob.AuditEnabled = true
+	ob.PlaceOrder(&Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(sink.records))
+	}
+	rec := sink.records[0]
+	if rec.OrderID != "taker" || rec.Status != Filled {
+		t.Errorf("unexpected record order %s status %s", rec.OrderID, GetStatusName(rec.Status))
+	}
+	if rec.BidBefore != dec(99) || rec.AskBefore != dec(100) {
+		t.Errorf("expected before 99/100, got %s/%s", rec.BidBefore, rec.AskBefore)
+	}
+	if rec.BidAfter != dec(99) || rec.AskAfter != dec(101) {
+		t.Errorf("expected after 99/101, got %s/%s", rec.BidAfter, rec.AskAfter)
+	}
+	if len(rec.Trades) != 1 || rec.Trades[0].SellOrderId != "ask1" || rec.Trades[0].Price != dec(100) {
+		t.Errorf("unexpected trades %v", rec.Trades)
+	}
+}
+
+func levelOrderIDs(pl *PriceLevel) []string {
+	ids := make([]string, 0, pl.Len())
+	for _, o := range pl.OrderList() {
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+// 測試價格層級鏈結串列：隊首取出、隊尾追加（失去優先權）、中間移除
+func TestPriceLevelQueue(t *testing.T) {
+	pl := newPriceLevel(dec(100))
+	for _, id := range []string{"a", "b", "c", "d"} {
+		pl.AddOrder(&Order{ID: id, Price: dec(100), Quantity: dec(1)})
+	}
+
+	if front := pl.PopFront(); front == nil || front.ID != "a" {
+		t.Fatalf("expected front a, got %v", front)
+	}
+
+	// 移除 b 後重新追加，排到隊尾
+	b, ok := pl.RemoveOrder("b")
+	if !ok {
+		t.Fatal("expected b to be removed")
+	}
+	pl.AddOrder(b)
+	if got := fmt.Sprint(levelOrderIDs(pl)); got != "[c d b]" {
+		t.Errorf("expected [c d b] after re-append, got %s", got)
+	}
+
+	// 中間移除
+	if _, ok := pl.RemoveOrder("d"); !ok {
+		t.Fatal("expected d to be removed")
+	}
+	if _, ok := pl.RemoveOrder("d"); ok {
+		t.Error("expected second removal of d to fail")
+	}
+	if got := fmt.Sprint(levelOrderIDs(pl)); got != "[c b]" {
+		t.Errorf("expected [c b] after middle removal, got %s", got)
+	}
+	if pl.Quantity != dec(2) {
+		t.Errorf("expected quantity 2, got %s", pl.Quantity)
+	}
+}
+
+// 測試撮合後只彈出隊首已成交的掛單，層級總量隨成交扣減
+func TestMatchPopsFilledFront(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for _, id := range []string{"a1", "a2", "a3"} {
+		ob.PlaceOrder(&Order{ID: id, Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	}
+
+	ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(3)})
+	level := ob.AskLevels[dec(100)]
+	if got := fmt.Sprint(levelOrderIDs(level)); got != "[a2 a3]" {
+		t.Errorf("expected [a2 a3] after the fill, got %s", got)
+	}
+	if level.Quantity != dec(3) {
+		t.Errorf("expected level quantity 3, got %s", level.Quantity)
+	}
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Errorf("unexpected integrity issues: %v", issues)
+	}
+}
+
+// 測試取消中間訂單後撮合仍按時間優先，且不影響其他層級
+func TestCancelMiddleOrderKeepsPriority(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+
+	if !ob.CancelOrder("a2") {
+		t.Fatal("expected cancel to succeed")
+	}
+	// 取消非堆頂層級的唯一訂單，不應影響最佳賣價
+	if !ob.CancelOrder("b1") {
+		t.Fatal("expected cancel to succeed")
+	}
+	if _, ask, _ := ob.GetBestBidAsk(); ask != dec(100) {
+		t.Fatalf("expected best ask 100, got %s", ask)
+	}
+
+	trades, _ := ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(2)})
+	if len(trades) != 2 || trades[0].SellOrderId != "a1" || trades[1].SellOrderId != "a3" {
+		t.Fatalf("expected fills against a1 then a3, got %v", trades)
+	}
+}
+
+// 測試負價格：預設拒絕，啟用後可正確排序與撮合
+func TestNegativePrices(t *testing.T) {
+	ob := NewOrderBook("SPREAD")
+
+	rejected := &Order{ID: "neg", Side: Bid, Type: Limit, Price: dec(-5), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(rejected); !errors.Is(err, ErrInvalidPrice) {
+		t.Fatalf("expected ErrInvalidPrice, got %v", err)
+	}
+	if rejected.Status != Cancelled || rejected.CancelReason != Rejected {
+		t.Errorf("expected rejected order to be cancelled, got %s", GetStatusName(rejected.Status))
+	}
+
+	ob.AllowNegativePrice = true
+	ob.PlaceOrder(&Order{ID: "bid_-10", Side: Bid, Type: Limit, Price: dec(-10), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "bid_-7", Side: Bid, Type: Limit, Price: dec(-7), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask_-3", Side: Ask, Type: Limit, Price: dec(-3), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask_-5", Side: Ask, Type: Limit, Price: dec(-5), Quantity: dec(1)})
+
+	bid, ask, _ := ob.GetBestBidAsk()
+	if bid != dec(-7) || ask != dec(-5) {
+		t.Fatalf("expected best bid -7 and best ask -5, got %s/%s", bid, ask)
+	}
+
+	trades, err := ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Limit, Price: dec(-4), Quantity: dec(1.5)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 1 || trades[0].Price != dec(-5) || trades[0].Quantity != dec(1) {
+		t.Fatalf("expected one fill at -5, got %v", trades)
+	}
+	// 剩餘 0.5 掛在 -4，成為新的最佳買價
+	bid, ask, _ = ob.GetBestBidAsk()
+	if bid != dec(-4) || ask != dec(-3) {
+		t.Errorf("expected best bid -4 and best ask -3, got %s/%s", bid, ask)
+	}
+
+	fees := FeeSchedule{TakerRate: dec(0.001)}
+	if fee := fees.TakerFee(trades[0].Price, trades[0].Quantity); fee != dec(0.005) {
+		t.Errorf("expected positive fee 0.005 on negative notional, got %s", fee)
+	}
+}
+
+// 測試 OrderFills 彙總訂單的多筆成交及 VWAP
+func TestOrderFills(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+
+	ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Limit, Price: dec(103), Quantity: dec(4)})
+
+	trades, qty, avg := ob.OrderFills("buy")
+	if len(trades) != 3 {
+		t.Fatalf("expected 3 trades, got %d", len(trades))
+	}
+	if qty != dec(4) {
+		t.Errorf("expected total quantity 4, got %s", qty)
+	}
+	wantAvg := (100*1 + 101*2 + 103*1) / 4.0
+	if avg != wantAvg {
+		t.Errorf("expected VWAP %.4f, got %.4f", wantAvg, avg)
+	}
+
+	// 掛單方也能查到
+	if trades, qty, avg := ob.OrderFills("a2"); len(trades) != 1 || qty != dec(2) || avg != 101 {
+		t.Errorf("unexpected maker fills: %d trades, qty %s, avg %.2f", len(trades), qty, avg)
+	}
+	if trades, qty, avg := ob.OrderFills("unknown"); len(trades) != 0 || qty != 0 || avg != 0 {
+		t.Errorf("expected empty result for unknown order")
+	}
+}
+
+// 測試連續成交之間的間隔反映在成交時間戳上
+func TestFillCooldown(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := NewManualClock(start)
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.FillCooldown = 50 * time.Millisecond
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+
+	trades, _ := ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(3)})
+	if len(trades) != 3 {
+		t.Fatalf("expected 3 trades, got %d", len(trades))
+	}
+	// 第一筆不等待
+	if !trades[0].Timestamp.Equal(start) {
+		t.Errorf("expected first trade at start, got %s", trades[0].Timestamp)
+	}
+	for i := 1; i < len(trades); i++ {
+		if gap := trades[i].Timestamp.Sub(trades[i-1].Timestamp); gap != ob.FillCooldown {
+			t.Errorf("trade %d: expected gap %s, got %s", i, ob.FillCooldown, gap)
+		}
+	}
+}
+
+// 測試錯誤可用 errors.Is / errors.As 判斷
+func TestOrderErrors(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "dup", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+	tests := []struct {
+		order *Order
+		want  error
+	}{
+		{&Order{ID: "zero_qty", Side: Bid, Type: Limit, Price: dec(100), Quantity: 0}, ErrInvalidQuantity},
+		{&Order{ID: "neg_price", Side: Bid, Type: Limit, Price: dec(-1), Quantity: dec(1)}, ErrInvalidPrice},
+		{&Order{ID: "dup", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}, ErrDuplicateOrderID},
+		{&Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(1)}, ErrNoLiquidity},
+	}
+	for _, tt := range tests {
+		_, err := ob.PlaceOrder(tt.order)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.order.ID, tt.want, err)
+			continue
+		}
+		var orderErr *OrderError
+		if !errors.As(err, &orderErr) || orderErr.OrderID != tt.order.ID {
+			t.Errorf("%s: expected OrderError carrying the order ID, got %v", tt.order.ID, err)
+		}
+	}
+}
+
+// 測試區間深度只返回價格窗口內的檔位且順序正確
+func TestGetDepthInRange(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for i, price := range []Decimal{dec(95), dec(99), dec(90), dec(97), dec(93), dec(98)} {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("bid%d", i), Side: Bid, Type: Limit, Price: price, Quantity: dec(1)})
+	}
+	for i, price := range []Decimal{dec(105), dec(101), dec(110), dec(103), dec(102)} {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("ask%d", i), Side: Ask, Type: Limit, Price: price, Quantity: dec(1)})
+	}
+	ob.PlaceOrder(&Order{ID: "ask_extra", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(2)})
+
+	prices := func(depth []DepthLevel) []Decimal {
+		out := make([]Decimal, 0, len(depth))
+		for _, d := range depth {
+			out = append(out, d.Price)
+		}
+		return out
+	}
+
+	bids := ob.GetDepthInRange(Bid, dec(93), dec(98))
+	if got := fmt.Sprint(prices(bids)); got != "[98 97 95 93]" {
+		t.Errorf("expected bids [98 97 95 93], got %s", got)
+	}
+
+	asks := ob.GetDepthInRange(Ask, dec(102), dec(105))
+	if got := fmt.Sprint(prices(asks)); got != "[102 103 105]" {
+		t.Errorf("expected asks [102 103 105], got %s", got)
+	}
+	if asks[1].Quantity != dec(3) || asks[1].Orders != 2 {
+		t.Errorf("expected level 103 to aggregate 3 over 2 orders, got %s over %d", asks[1].Quantity, asks[1].Orders)
+	}
+
+	if empty := ob.GetDepthInRange(Ask, dec(200), dec(300)); len(empty) != 0 {
+		t.Errorf("expected no levels outside the book, got %v", empty)
+	}
+}
+
+// 測試價格相等的對手限價單在 CrossOnEqual 開關下的行為
+func TestCrossOnEqual(t *testing.T) {
+	for _, crossOnEqual := range []bool{true, false} {
+		ob := NewOrderBook("BTCUSDT")
+		ob.CrossOnEqual = crossOnEqual
+
+		ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		buyTrades, _ := ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+		sellBook := NewOrderBook("BTCUSDT")
+		sellBook.CrossOnEqual = crossOnEqual
+		sellBook.PlaceOrder(&Order{ID: "bid2", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+		sellTrades, _ := sellBook.PlaceOrder(&Order{ID: "ask2", Side: Ask, Type: Limit, Price: dec(90), Quantity: dec(1)})
+
+		if crossOnEqual {
+			if len(buyTrades) != 1 || len(sellTrades) != 1 {
+				t.Errorf("CrossOnEqual=true: expected equal prices to match, got %d/%d trades", len(buyTrades), len(sellTrades))
+			}
+			continue
+		}
+		if len(buyTrades) != 0 || len(sellTrades) != 0 {
+			t.Errorf("CrossOnEqual=false: expected no trades, got %d/%d", len(buyTrades), len(sellTrades))
+		}
+		if len(ob.UnFilledOrders) != 2 || len(sellBook.UnFilledOrders) != 2 {
+			t.Errorf("CrossOnEqual=false: expected equal-priced orders to rest, got %d/%d", len(ob.UnFilledOrders), len(sellBook.UnFilledOrders))
+		}
+		// 嚴格優於對手價仍會撮合
+		if trades, _ := ob.PlaceOrder(&Order{ID: "bid3", Side: Bid, Type: Limit, Price: dec(100.5), Quantity: dec(1)}); len(trades) != 1 {
+			t.Errorf("CrossOnEqual=false: expected strictly better bid to match, got %d trades", len(trades))
+		}
+	}
+}
+
+// 測試備援落後量
+func TestReplicationLag(t *testing.T) {
+	primary := NewOrderBook("BTCUSDT")
+	standby := NewOrderBook("BTCUSDT")
+
+	for i := 0; i < 10; i++ {
+		primary.PlaceOrder(&Order{ID: fmt.Sprintf("o%d", i), Side: Bid, Type: Limit, Price: DecimalFromInt(int64(100 + i)), Quantity: dec(1)})
+	}
+	if primary.Sequence() != 10 {
+		t.Fatalf("expected primary sequence 10, got %d", primary.Sequence())
+	}
+
+	// 備援套用到 7，中間 8~10 尚未送達
+	for seq := uint64(1); seq <= 7; seq++ {
+		standby.ObservePrimarySequence(seq)
+		standby.MarkApplied(seq)
+	}
+	standby.ObservePrimarySequence(primary.Sequence())
+
+	if lag := standby.ReplicationLag(); lag != 3 {
+		t.Errorf("expected lag 3, got %d", lag)
+	}
+
+	standby.MarkApplied(10)
+	if lag := standby.ReplicationLag(); lag != 0 {
+		t.Errorf("expected lag 0 after catching up, got %d", lag)
+	}
+}
+
+// 逐次吃掉冰山單的顯示部分，返回每次顯示的數量
+func icebergDisplaySequence(seed int64) []Decimal {
+	ob := NewOrderBook("BTCUSDT")
+	ob.Rand = rand.New(rand.NewSource(seed))
+
+	iceberg := &Order{ID: "ice", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(100), DisplayQuantity: dec(10), DisplayVariancePct: 20}
+	ob.PlaceOrder(iceberg)
+
+	displays := make([]Decimal, 0)
+	for i := 0; !iceberg.IsFilled(); i++ {
+		visible := iceberg.Visible()
+		displays = append(displays, visible)
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("buy%d", i), Side: Bid, Type: Market, Quantity: visible})
+	}
+	return displays
+}
+
+// 測試冰山單每次補充的顯示數量在浮動範圍內，且固定種子下可重現
+func TestIcebergDisplayVariance(t *testing.T) {
+	displays := icebergDisplaySequence(42)
+	if len(displays) < 5 {
+		t.Fatalf("expected several refills, got %v", displays)
+	}
+
+	var total Decimal
+	distinct := make(map[Decimal]bool)
+	for i, d := range displays {
+		total += d
+		distinct[d] = true
+		// 最後一次可能被剩餘數量截斷
+		if i == len(displays)-1 {
+			continue
+		}
+		if d < dec(8) || d > dec(12) {
+			t.Errorf("refill %d: display %s outside 8-12 band", i, d)
+		}
+	}
+	if total != dec(100) {
+		t.Errorf("expected displays to sum to 100, got %s", total)
+	}
+	if len(distinct) < 2 {
+		t.Errorf("expected display quantities to vary, got %v", displays)
+	}
+
+	if again := icebergDisplaySequence(42); fmt.Sprint(again) != fmt.Sprint(displays) {
+		t.Errorf("expected same sequence for same seed, got %v vs %v", again, displays)
+	}
+}
+
+// 測試冰山單在深度中只顯示部分數量，補充後排到同價位隊尾
+func TestIcebergHiddenQuantityAndPriority(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "ice", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(50), DisplayQuantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "plain", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(3)})
+
+	depth := ob.GetDepthInRange(Ask, 0, dec(1000))
+	if len(depth) != 1 || depth[0].Quantity != dec(8) {
+		t.Fatalf("expected displayed quantity 8, got %v", depth)
+	}
+
+	trades, _ := ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(7)})
+	if len(trades) != 2 || trades[0].SellOrderId != "ice" || trades[0].Quantity != dec(5) ||
+		trades[1].SellOrderId != "plain" || trades[1].Quantity != dec(2) {
+		t.Fatalf("expected ice 5 then plain 2 after refill moved ice to back, got %v", trades)
+	}
+}
+
+// 測試推動價格所需的量與金額
+func TestCostToMove(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	asks := []struct{ price, qty Decimal }{{dec(100), dec(1)}, {dec(100.5), dec(2)}, {dec(101), dec(1.5)}, {dec(101.5), dec(3)}, {dec(102), dec(4)}}
+	for i, a := range asks {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("ask%d", i), Side: Ask, Type: Limit, Price: a.price, Quantity: a.qty})
+	}
+	ob.PlaceOrder(&Order{ID: "bid1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "bid2", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(5)})
+
+	// 最佳賣價從 100 推到 101.5：需吃掉 100、100.5、101
+	qty, notional := ob.CostToMove(Bid, 3, dec(0.5))
+	if qty != dec(4.5) {
+		t.Errorf("expected quantity 4.5, got %s", qty)
+	}
+	if want := dec(100*1 + 100.5*2 + 101*1.5); notional != want {
+		t.Errorf("expected notional %s, got %s", want, notional)
+	}
+
+	// 賣出將最佳買價從 99 壓到 97：需吃掉 99、98
+	qty, notional = ob.CostToMove(Ask, 2, dec(1))
+	if qty != dec(7) || notional != dec(99*2+98*5) {
+		t.Errorf("expected 7 / %d, got %s / %s", 99*2+98*5, qty, notional)
+	}
+
+	if qty, notional = ob.CostToMove(Bid, 0, dec(0.5)); qty != 0 || notional != 0 {
+		t.Errorf("expected zero cost for zero ticks")
+	}
+}
+
+// 測試最佳價格暫留：層級清空後在窗口內仍顯示舊價格，之後更新
+func TestStickyBestPrice(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.StickyBestWindow = 500 * time.Millisecond
+
+	ob.PlaceOrder(&Order{ID: "ask1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "bid1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+
+	// 吃掉最佳賣價層級
+	ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(1)})
+
+	if _, ask, _ := ob.GetBestBidAsk(); ask != dec(100) {
+		t.Errorf("expected sticky ask 100 inside window, got %s", ask)
+	}
+	// 撮合仍使用實際狀態
+	if trades, _ := ob.PlaceOrder(&Order{ID: "buy2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}); len(trades) != 0 {
+		t.Errorf("expected no match against a display-only price, got %d trades", len(trades))
+	}
+
+	clock.Advance(400 * time.Millisecond)
+	if _, ask, _ := ob.GetBestBidAsk(); ask != dec(100) {
+		t.Errorf("expected sticky ask 100 at 400ms, got %s", ask)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	bid, ask, _ := ob.GetBestBidAsk()
+	if ask != dec(101) {
+		t.Errorf("expected real ask 101 after window, got %s", ask)
+	}
+	// 價格改善立即顯示
+	if bid != dec(100) {
+		t.Errorf("expected improved bid 100 immediately, got %s", bid)
+	}
+}
+
+// 測試限價單零價格被拒絕，市價單的價格被清除
+func TestZeroPriceHandling(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+
+	zero := &Order{ID: "zero", Side: Ask, Type: Limit, Price: 0, Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(zero); !errors.Is(err, ErrInvalidPrice) {
+		t.Fatalf("expected ErrInvalidPrice for zero-priced limit, got %v", err)
+	}
+	if len(ob.UnFilledOrders) != 0 || ob.Asks.Len() != 0 {
+		t.Fatalf("expected rejected order not to rest")
+	}
+
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	market := &Order{ID: "mkt", Side: Bid, Type: Market, Price: dec(42), Quantity: dec(1)}
+	trades, err := ob.PlaceOrder(market)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if market.Price != 0 {
+		t.Errorf("expected market order price to be cleared, got %s", market.Price)
+	}
+	if len(trades) != 1 || trades[0].Price != dec(100) {
+		t.Errorf("expected fill at resting price 100, got %v", trades)
+	}
+}
+
+// 測試重複更新雙邊報價後只保留最新的買賣單
+func TestUpdateQuote(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "other_bid", UserID: "other", Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(1)})
+
+	for i := 0; i < 5; i++ {
+		offset := dec(0.1) * Decimal(i)
+		if _, err := ob.UpdateQuote("mm", dec(98)+offset, dec(1), dec(102)+offset, dec(2)); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+
+	if len(ob.UnFilledOrders) != 3 {
+		t.Fatalf("expected 3 resting orders (2 quote + 1 other), got %d", len(ob.UnFilledOrders))
+	}
+	bids := ob.GetDepthInRange(Bid, 0, dec(1000))
+	asks := ob.GetDepthInRange(Ask, 0, dec(1000))
+	if len(bids) != 2 || bids[0].Price != dec(98.4) || bids[1].Price != dec(95) {
+		t.Errorf("expected bids [98.4 95], got %v", bids)
+	}
+	if len(asks) != 1 || asks[0].Price != dec(102.4) || asks[0].Quantity != dec(2) {
+		t.Errorf("expected single ask 102.4 x 2, got %v", asks)
+	}
+
+	// 新報價穿價時返回成交
+	ob.PlaceOrder(&Order{ID: "seller", UserID: "other", Side: Ask, Type: Limit, Price: dec(99), Quantity: dec(0.5)})
+	trades, err := ob.UpdateQuote("mm", dec(99), dec(1), dec(103), dec(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 || trades[0].SellOrderId != "seller" || trades[0].Quantity != dec(0.5) {
+		t.Errorf("expected quote bid to take 0.5 from seller, got %v", trades)
+	}
+}
+
+// 測試新報價任一邊會被拒絕時整個更新被拒絕，舊報價保持原位且未被撤銷
+func TestUpdateQuoteRejectedKeepsQuote(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	if _, err := ob.UpdateQuote("mm", dec(99), dec(1), dec(101), dec(1)); err != nil {
+		t.Fatal(err)
+	}
+	ob.PlaceOrder(&Order{ID: "mm_extra", UserID: "mm", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+
+	if _, err := ob.UpdateQuote("mm", dec(100), dec(1), 0, dec(1)); !errors.Is(err, ErrInvalidPrice) {
+		t.Fatalf("expected ErrInvalidPrice for the ask, got %v", err)
+	}
+	// 買單通過驗證後計入掛單數，賣單因此超過上限
+	ob.MaxOpenOrdersPerUser = 2
+	if _, err := ob.UpdateQuote("mm", dec(100), dec(1), dec(102), dec(1)); !errors.Is(err, ErrTooManyOpenOrders) {
+		t.Fatalf("expected ErrTooManyOpenOrders for the ask, got %v", err)
+	}
+
+	for _, id := range []string{"quote_mm_bid_1", "quote_mm_ask_1"} {
+		o, ok := ob.UnFilledOrders[id]
+		if !ok || o.Status == Cancelled {
+			t.Fatalf("expected old quote %s to stay resting", id)
+		}
+	}
+	bids := ob.GetDepthInRange(Bid, 0, dec(1000))
+	asks := ob.GetDepthInRange(Ask, 0, dec(1000))
+	if len(bids) != 2 || bids[0].Price != dec(99) || bids[0].Quantity != dec(1) {
+		t.Errorf("expected bids [99 90], got %v", bids)
+	}
+	if len(asks) != 1 || asks[0].Price != dec(101) || asks[0].Quantity != dec(1) {
+		t.Errorf("expected single ask 101, got %v", asks)
+	}
+
+	ob.MaxOpenOrdersPerUser = 0
+	if _, err := ob.UpdateQuote("mm", dec(100), dec(1), dec(102), dec(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ob.UnFilledOrders["quote_mm_bid_2"]; !ok {
+		t.Errorf("expected rejected updates not to consume quote IDs")
+	}
+}
+
+// 測試成交後剩餘不足一手的掛單按 DustPolicy 處理
+func TestDustPolicy(t *testing.T) {
+	for _, policy := range []DustPolicy{DustKeep, DustCancel} {
+		ob := NewOrderBook("BTCUSDT")
+		ob.LotSize = dec(0.1)
+		ob.DustPolicy = policy
+
+		maker := &Order{ID: "maker", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1.05)}
+		ob.PlaceOrder(maker)
+		ob.PlaceOrder(&Order{ID: "next", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+		// maker 剩餘 0.05 < 一手
+		switch policy {
+		case DustKeep:
+			if maker.Status != Partial {
+				t.Errorf("DustKeep: expected maker to stay partial, got %s", GetStatusName(maker.Status))
+			}
+			if _, ok := ob.UnFilledOrders["maker"]; !ok {
+				t.Error("DustKeep: expected dust to remain resting")
+			}
+		case DustCancel:
+			if maker.Status != Cancelled || maker.CancelReason != DustRemainder {
+				t.Errorf("DustCancel: expected maker cancelled for dust, got %s/%s",
+					GetStatusName(maker.Status), GetCancelReasonName(maker.CancelReason))
+			}
+			if _, ok := ob.UnFilledOrders["maker"]; ok {
+				t.Error("DustCancel: expected dust to be removed")
+			}
+			// 同層級其他訂單不受影響，且成為隊首
+			trades, _ := ob.PlaceOrder(&Order{ID: "taker2", Side: Bid, Type: Market, Quantity: dec(0.5)})
+			if len(trades) != 1 || trades[0].SellOrderId != "next" {
+				t.Errorf("DustCancel: expected next order to be matched, got %v", trades)
+			}
+		}
+	}
+}
+
+// 測試 MinTradeSize：兩筆訂單重疊不足最小成交量時不產生成交
+func TestMinTradeSize(t *testing.T) {
+	for _, policy := range []DustPolicy{DustKeep, DustCancel} {
+		ob := NewOrderBook("BTCUSDT")
+		ob.MinTradeSize = dec(0.1)
+		ob.SmallResidualPolicy = policy
+
+		small := &Order{ID: "small", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.05)}
+		ob.PlaceOrder(small)
+		taker := &Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+		trades, err := ob.PlaceOrder(taker)
+		if err != nil {
+			t.Fatalf("policy %d: unexpected error: %v", policy, err)
+		}
+		if len(trades) != 0 {
+			t.Errorf("policy %d: expected no trade below MinTradeSize, got %v", policy, trades)
+		}
+		if taker.FilledQuantity != 0 {
+			t.Errorf("policy %d: expected taker unfilled, got %v", policy, taker.FilledQuantity)
+		}
+
+		switch policy {
+		case DustKeep:
+			if _, ok := ob.UnFilledOrders["small"]; !ok {
+				t.Error("DustKeep: expected small maker to remain resting")
+			}
+			// 進場訂單若掛單會與保留的小額掛單交叉，因此取消而不掛單
+			if taker.Status != Cancelled || taker.CancelReason != CrossingRemainder {
+				t.Errorf("DustKeep: expected taker cancelled as crossing, got %s/%s",
+					GetStatusName(taker.Status), GetCancelReasonName(taker.CancelReason))
+			}
+			if _, ok := ob.UnFilledOrders["taker"]; ok {
+				t.Error("DustKeep: expected crossing taker not to rest")
+			}
+			sell := &Order{ID: "sell", Side: Ask, Type: Limit, Price: dec(98), Quantity: dec(1)}
+			ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(0.05)})
+			ob.PlaceOrder(sell)
+			if sell.Status != Cancelled || sell.CancelReason != CrossingRemainder {
+				t.Errorf("DustKeep: expected crossing sell cancelled, got %s/%s",
+					GetStatusName(sell.Status), GetCancelReasonName(sell.CancelReason))
+			}
+		case DustCancel:
+			if taker.Status != Pending {
+				t.Errorf("DustCancel: expected taker to rest after small maker dropped, got %s", GetStatusName(taker.Status))
+			}
+			if small.Status != Cancelled || small.CancelReason != DustRemainder {
+				t.Errorf("DustCancel: expected small maker cancelled, got %s/%s",
+					GetStatusName(small.Status), GetCancelReasonName(small.CancelReason))
+			}
+			// 進場訂單本身不足最小成交量時不再掛單
+			tiny := &Order{ID: "tiny", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.05)}
+			if trades, _ := ob.PlaceOrder(tiny); len(trades) != 0 {
+				t.Errorf("DustCancel: expected no trade for tiny order, got %v", trades)
+			}
+			if tiny.Status != Cancelled || tiny.CancelReason != DustRemainder {
+				t.Errorf("DustCancel: expected tiny order cancelled, got %s/%s",
+					GetStatusName(tiny.Status), GetCancelReasonName(tiny.CancelReason))
+			}
+		}
+	}
+}
+
+// 測試停損限價單：成交價觸及觸發價前不撮合，觸發後轉為限價單，未成交部分掛單
+func TestStopLimitOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+
+	stop := &Order{ID: "stop", Side: Bid, Type: StopLimit, StopPrice: dec(100), Price: dec(105), Quantity: dec(2)}
+	trades, err := ob.PlaceOrder(stop)
+	if err != nil || len(trades) != 0 {
+		t.Fatalf("expected stop order to be held, got %v, %v", trades, err)
+	}
+	if _, ok := ob.UnFilledOrders["stop"]; ok {
+		t.Fatal("expected pending stop order not to rest on the book")
+	}
+	if len(ob.PendingStopOrders()) != 1 {
+		t.Fatalf("expected 1 pending stop order, got %d", len(ob.PendingStopOrders()))
+	}
+
+	// 成交價 100 觸發停損，轉為限價 105 的買單：吃掉 a2 後剩餘 1 掛單
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Market, Quantity: dec(1)})
+	if stop.Type != Limit || stop.FilledQuantity != dec(1) || stop.Status != Partial {
+		t.Fatalf("expected triggered stop partially filled as limit, got %s %s filled %v",
+			GetTypeName(stop.Type), GetStatusName(stop.Status), stop.FilledQuantity)
+	}
+	if resting, ok := ob.UnFilledOrders["stop"]; !ok || resting.Remaining() != dec(1) || resting.Price != dec(105) {
+		t.Errorf("expected stop remainder resting at 105, got %+v", resting)
+	}
+	if len(ob.PendingStopOrders()) != 0 {
+		t.Errorf("expected no pending stop orders after trigger")
+	}
+
+	// 尚未觸發的停損單可以取消
+	sell := &Order{ID: "sellstop", Side: Ask, Type: StopLimit, StopPrice: dec(90), Price: dec(89), Quantity: dec(1)}
+	ob.PlaceOrder(sell)
+	if !ob.CancelOrder("sellstop") {
+		t.Fatal("expected pending stop order to be cancellable")
+	}
+	if sell.Status != Cancelled || len(ob.PendingStopOrders()) != 0 {
+		t.Errorf("expected stop order cancelled and removed, got %s", GetStatusName(sell.Status))
+	}
+
+	// 觸發價必須為正
+	_, err = ob.PlaceOrder(&Order{ID: "bad", Side: Bid, Type: StopLimit, Price: dec(100), Quantity: dec(1)})
+	if !errors.Is(err, ErrInvalidStopPrice) {
+		t.Errorf("expected ErrInvalidStopPrice, got %v", err)
+	}
+}
+
+// 測試下單以外的撮合路徑觸發的停損單：集合競價期間保留且可查詢、取消，恢復連續競價後撮合
+func TestStopTriggeredOutsidePlaceOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.AuctionMode = true
+	ob.PlaceOrder(&Order{ID: "b1", UserID: "alice", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(2)})
+	stop := &Order{ID: "stop", UserID: "alice", Side: Bid, Type: StopLimit, StopPrice: dec(100), Price: dec(105), Quantity: dec(1)}
+	ob.PlaceOrder(stop)
+	other := &Order{ID: "other", UserID: "bob", Side: Bid, Type: StopLimit, StopPrice: dec(100), Price: dec(105), Quantity: dec(1)}
+	ob.PlaceOrder(other)
+
+	// 競價成交 100 觸發兩筆停損單，集合競價模式下尚不撮合
+	if result := ob.RunAuction(); len(result.Trades) != 1 {
+		t.Fatalf("expected 1 auction trade, got %v", result.Trades)
+	}
+	if len(ob.PendingStopOrders()) != 2 {
+		t.Fatalf("expected triggered stops still listed, got %d", len(ob.PendingStopOrders()))
+	}
+	if ids := ob.CancelUserOrders("alice"); len(ids) != 1 || ids[0] != "stop" {
+		t.Errorf("expected alice's triggered stop cancelled, got %v", ids)
+	}
+	if stop.Status != Cancelled || stop.CancelReason != UserRequested {
+		t.Errorf("expected stop cancelled, got %s", GetStatusName(stop.Status))
+	}
+
+	// 恢復連續競價後，下一次撮合路徑結束時執行剩餘的已觸發停損單
+	ob.AuctionMode = false
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	if other.Type != Limit || other.Status != Filled {
+		t.Errorf("expected triggered stop executed, got %s %s", GetTypeName(other.Type), GetStatusName(other.Status))
+	}
+	if len(ob.PendingStopOrders()) != 0 {
+		t.Errorf("expected no pending stops, got %d", len(ob.PendingStopOrders()))
+	}
+
+	// 暫停期間觸發的停損單在恢復交易時撮合
+	ob = NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	halted := &Order{ID: "halted", Side: Bid, Type: StopLimit, StopPrice: dec(100), Price: dec(100), Quantity: dec(1)}
+	ob.PlaceOrder(halted)
+	ob.Halt("test")
+	ob.checkStops(dec(100))
+	if halted.Type != StopLimit {
+		t.Fatalf("expected stop held while halted")
+	}
+	ob.Resume()
+	if halted.Status != Filled {
+		t.Errorf("expected stop executed on resume, got %s", GetStatusName(halted.Status))
+	}
+}
+
+// 測試 Repair 修復被破壞的內部狀態後撮合仍正確
+func TestRepair(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Fatalf("expected clean book, got %v", issues)
+	}
+
+	// 破壞狀態：數量漂移、層級從堆中遺失、殘留已取消訂單
+	ob.AskLevels[dec(100)].Quantity = dec(2.5)
+	*ob.Bids = (*ob.Bids)[:0]
+	ghost := &Order{ID: "ghost", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1), Status: Cancelled}
+	ob.UnFilledOrders["ghost"] = ghost
+
+	if issues := ob.CheckIntegrity(); len(issues) == 0 {
+		t.Fatal("expected integrity issues after corruption")
+	}
+
+	report := ob.Repair()
+	if len(report.Issues) == 0 || report.OrdersDropped != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.BidLevels != 1 || report.AskLevels != 2 {
+		t.Errorf("expected 1 bid and 2 ask levels, got %d/%d", report.BidLevels, report.AskLevels)
+	}
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Fatalf("expected clean book after repair, got %v", issues)
+	}
+	if bid, ask, _ := ob.GetBestBidAsk(); bid != dec(99) || ask != dec(100) {
+		t.Errorf("expected 99/100 after repair, got %s/%s", bid, ask)
+	}
+
+	// 修復後撮合保留時間優先
+	trades, _ := ob.PlaceOrder(&Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(3.5)})
+	if len(trades) != 3 || trades[0].SellOrderId != "a1" || trades[1].SellOrderId != "a2" || trades[2].SellOrderId != "a3" {
+		t.Errorf("expected fills a1, a2, a3, got %v", trades)
+	}
+}
+
+// 測試吃光整個賣單簿的限價買單，剩餘部分以限價掛為新的最佳買價
+func TestLimitOrderExhaustsBookThenRests(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(1)})
+
+	buy := &Order{ID: "big", Side: Bid, Type: Limit, Price: dec(105), Quantity: dec(5)}
+	trades, _ := ob.PlaceOrder(buy)
+
+	if len(trades) != 3 {
+		t.Fatalf("expected 3 trades, got %d", len(trades))
+	}
+	if ob.Asks.Len() != 0 || len(ob.AskLevels) != 0 {
+		t.Errorf("expected ask side to be fully popped, got heap %d map %d", ob.Asks.Len(), len(ob.AskLevels))
+	}
+	if buy.Status != Partial || buy.Remaining() != dec(2) {
+		t.Errorf("expected partial with 2 remaining, got %s with %s", GetStatusName(buy.Status), buy.Remaining())
+	}
+	if _, ok := ob.UnFilledOrders["big"]; !ok {
+		t.Error("expected remainder to rest")
+	}
+	bid, ask, _ := ob.GetBestBidAsk()
+	if bid != dec(105) || ask != 0 {
+		t.Errorf("expected best bid 105 and no ask, got %s/%s", bid, ask)
+	}
+	if level := ob.BidLevels[dec(105)]; level == nil || level.Quantity != dec(2) {
+		t.Errorf("expected level 105 with quantity 2, got %v", level)
+	}
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Errorf("expected consistent book, got %v", issues)
+	}
+}
+
+// 測試單一價位全部成交：最佳層級足夠時成交，不足時不逐檔吃單
+func TestAONLevel(t *testing.T) {
+	newBook := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1.5)})
+		ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(5)})
+		return ob
+	}
+
+	// 最佳層級 100 共 2.5，足夠
+	ob := newBook()
+	fits := &Order{ID: "fits", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(2), AONLevel: true}
+	trades, _ := ob.PlaceOrder(fits)
+	if len(trades) != 2 || fits.Status != Filled {
+		t.Errorf("expected fill within best level, got %d trades status %s", len(trades), GetStatusName(fits.Status))
+	}
+	for _, tr := range trades {
+		if tr.Price != dec(100) {
+			t.Errorf("expected all fills at 100, got %s", tr.Price)
+		}
+	}
+
+	// 需要 3，最佳層級只有 2.5：會交叉的限價單不撮合也不掛單
+	ob = newBook()
+	tooBig := &Order{ID: "too_big", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(3), AONLevel: true}
+	trades, err := ob.PlaceOrder(tooBig)
+	if !errors.Is(err, ErrInsufficientLiquidity) || len(trades) != 0 || tooBig.FilledQuantity != 0 {
+		t.Errorf("expected ErrInsufficientLiquidity and no fills, got %v / %d trades", err, len(trades))
+	}
+	if tooBig.Status != Cancelled || tooBig.CancelReason != LevelInsufficient {
+		t.Errorf("expected crossing AON limit cancelled, got %s", GetStatusName(tooBig.Status))
+	}
+	if _, ok := ob.UnFilledOrders["too_big"]; ok {
+		t.Error("expected crossing AON limit not to rest")
+	}
+
+	// 不與對手盤交叉的限價單直接掛單
+	passive := &Order{ID: "passive", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(3), AONLevel: true}
+	if _, err := ob.PlaceOrder(passive); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := ob.UnFilledOrders["passive"]; !ok {
+		t.Error("expected non-crossing AON limit to rest")
+	}
+
+	// 市價單則取消
+	ob = newBook()
+	mkt := &Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(3), AONLevel: true}
+	trades, err = ob.PlaceOrder(mkt)
+	if !errors.Is(err, ErrInsufficientLiquidity) || len(trades) != 0 {
+		t.Errorf("expected ErrInsufficientLiquidity and no trades, got %v / %d", err, len(trades))
+	}
+	if mkt.Status != Cancelled || mkt.CancelReason != LevelInsufficient {
+		t.Errorf("expected market AON cancelled, got %s", GetStatusName(mkt.Status))
+	}
+	if ob.AskLevels[dec(100)].Quantity != dec(2.5) {
+		t.Errorf("expected book untouched")
+	}
+}
+
+// 測試價格層級掛單量歷史
+func TestLevelHistory(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := NewManualClock(start)
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.LevelHistoryLimit = 3
+
+	// 未啟用時不記錄
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.RecordLevelSnapshot()
+	if len(ob.LevelHistory(dec(100), Bid)) != 0 {
+		t.Fatal("expected no history while disabled")
+	}
+
+	ob.LevelHistoryEnabled = true
+	ob.RecordLevelSnapshot() // t0: 1
+
+	clock.Advance(time.Minute)
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.RecordLevelSnapshot() // t1: 3
+
+	clock.Advance(time.Minute)
+	ob.CancelOrder("b1")
+	ob.RecordLevelSnapshot() // t2: 2
+
+	history := ob.LevelHistory(dec(100), Bid)
+	want := []Decimal{dec(1), dec(3), dec(2)}
+	if len(history) != len(want) {
+		t.Fatalf("expected %d samples, got %v", len(want), history)
+	}
+	for i, w := range want {
+		if history[i].Quantity != w || !history[i].Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("sample %d: expected %s at +%dm, got %+v", i, w, i, history[i])
+		}
+	}
+
+	// 清空後記為 0，且受上限限制只保留最近 3 筆
+	clock.Advance(time.Minute)
+	ob.CancelOrder("b2")
+	ob.RecordLevelSnapshot()
+	history = ob.LevelHistory(dec(100), Bid)
+	if len(history) != 3 || history[0].Quantity != dec(3) || history[2].Quantity != 0 {
+		t.Errorf("expected bounded history [3 2 0], got %v", history)
+	}
+	if len(ob.LevelHistory(dec(100), Ask)) != 0 {
+		t.Error("expected no ask history at 100")
+	}
+}
+
+// 測試流動性評分：深且窄的簿高於薄且寬的簿，空簿與單邊簿為 0
+func TestLiquidityScore(t *testing.T) {
+	deep := NewOrderBook("BTCUSDT")
+	for i := 0; i < 5; i++ {
+		offset := dec(0.05) * Decimal(i)
+		deep.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(99.95) - offset, Quantity: dec(10)})
+		deep.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: dec(100.05) + offset, Quantity: dec(10)})
+	}
+
+	thin := NewOrderBook("BTCUSDT")
+	thin.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	thin.PlaceOrder(&Order{ID: "a", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	deepScore, thinScore := deep.LiquidityScore(), thin.LiquidityScore()
+	if deepScore <= thinScore {
+		t.Errorf("expected deep score %.4f > thin score %.4f", deepScore, thinScore)
+	}
+	// 薄簿：中間價 100，±50bps 內沒有掛單
+	if thinScore != 0 {
+		t.Errorf("expected thin book score 0, got %.4f", thinScore)
+	}
+	// 深簿：價差 10bps，±0.5 內共 100
+	if !(math.Abs(deepScore-10) < 1e-9) {
+		t.Errorf("expected deep book score 10, got %.4f", deepScore)
+	}
+
+	oneSided := NewOrderBook("BTCUSDT")
+	oneSided.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(100)})
+	if s := oneSided.LiquidityScore(); s != 0 {
+		t.Errorf("expected one-sided book score 0, got %.4f", s)
+	}
+	if s := NewOrderBook("BTCUSDT").LiquidityScore(); s != 0 {
+		t.Errorf("expected empty book score 0, got %.4f", s)
+	}
+}
+
+// 測試集合競價以單一價格成交
+func TestRunAuction(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.AuctionMode = true
+
+	if _, err := ob.PlaceOrder(&Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(1)}); !errors.Is(err, ErrMarketOrderInAuction) {
+		t.Fatalf("expected market order rejection, got %v", err)
+	}
+
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1.5)})
+	trades, _ := ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+
+	if len(trades) != 0 || len(ob.Trades) != 0 {
+		t.Fatal("expected no continuous matching in auction mode")
+	}
+
+	// 101：買 3，賣 2.5，可成交 2.5（最大）
+	result := ob.RunAuction()
+	if result.Price != dec(101) || result.Quantity != dec(2.5) {
+		t.Fatalf("expected 2.5 at 101, got %s at %s", result.Quantity, result.Price)
+	}
+	for _, tr := range result.Trades {
+		if tr.Price != dec(101) {
+			t.Errorf("expected all trades at 101, got %s", tr.Price)
+		}
+	}
+
+	bid, ask, _ := ob.GetBestBidAsk()
+	if bid != dec(101) || ask != dec(103) {
+		t.Errorf("expected uncrossed book 101/103, got %s/%s", bid, ask)
+	}
+	if level := ob.BidLevels[dec(101)]; level == nil || level.Quantity != dec(0.5) {
+		t.Errorf("expected 0.5 left at 101")
+	}
+}
+
+// 測試公允價值介於最佳買賣價之間，並隨深度失衡移動
+func TestFairValue(t *testing.T) {
+	build := func(bidQty, askQty Decimal) *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		for i := 0; i < 3; i++ {
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(99) - DecimalFromInt(int64(i)), Quantity: bidQty})
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: dec(101) + DecimalFromInt(int64(i)), Quantity: askQty})
+		}
+		return ob
+	}
+
+	balanced := build(dec(5), dec(5)).FairValue(3)
+	if math.Abs(balanced-100) > 1e-9 {
+		t.Errorf("expected balanced book fair value 100, got %.4f", balanced)
+	}
+
+	bidHeavy := build(dec(20), dec(5)).FairValue(3)
+	askHeavy := build(dec(5), dec(20)).FairValue(3)
+	for _, fv := range []float64{bidHeavy, askHeavy} {
+		if fv <= 99 || fv >= 101 {
+			t.Errorf("expected fair value within (99, 101), got %.4f", fv)
+		}
+	}
+	if bidHeavy <= 100 || askHeavy >= 100 {
+		t.Errorf("expected bid-heavy > 100 > ask-heavy, got %.4f / %.4f", bidHeavy, askHeavy)
+	}
+
+	// 遠檔深度的影響小於近檔
+	nearHeavy := NewOrderBook("BTCUSDT")
+	farHeavy := NewOrderBook("BTCUSDT")
+	for _, ob := range []*OrderBook{nearHeavy, farHeavy} {
+		ob.PlaceOrder(&Order{ID: "a", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(5)})
+	}
+	nearHeavy.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(20)})
+	farHeavy.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(5)})
+	farHeavy.PlaceOrder(&Order{ID: "b_far", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(15)})
+	if nearHeavy.FairValue(5) <= farHeavy.FairValue(5) {
+		t.Errorf("expected near depth to weigh more than far depth")
+	}
+
+	oneSided := NewOrderBook("BTCUSDT")
+	oneSided.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	if fv := oneSided.FairValue(3); fv != 99 {
+		t.Errorf("expected one-sided fair value 99, got %.4f", fv)
+	}
+	if fv := NewOrderBook("BTCUSDT").FairValue(3); fv != 0 {
+		t.Errorf("expected empty book fair value 0, got %.4f", fv)
+	}
+}
+
+// 測試價差過寬時拒絕市價單，限價單不受影響
+func TestMaxSpreadBpsForMarket(t *testing.T) {
+	build := func(bid, ask Decimal) *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.MaxSpreadBpsForMarket = 50
+		ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: bid, Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: ask, Quantity: dec(1)})
+		return ob
+	}
+
+	// 價差約 20 bps，市價單正常成交
+	tight := build(dec(999), dec(1001))
+	trades, err := tight.PlaceOrder(&Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(1)})
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("expected market order to fill on tight spread, got %d trades, err %v", len(trades), err)
+	}
+
+	// 價差約 1000 bps，市價單被拒絕
+	wide := build(dec(950), dec(1050))
+	mkt := &Order{ID: "mkt", Side: Ask, Type: Market, Quantity: dec(1)}
+	trades, err = wide.PlaceOrder(mkt)
+	if !errors.Is(err, ErrSpreadTooWide) || len(trades) != 0 {
+		t.Fatalf("expected ErrSpreadTooWide, got %d trades, err %v", len(trades), err)
+	}
+	if mkt.Status != Cancelled || mkt.CancelReason != Rejected {
+		t.Errorf("expected rejected market order, got status %v reason %v", mkt.Status, mkt.CancelReason)
+	}
+
+	// 限價單在寬價差下仍可撮合
+	trades, err = wide.PlaceOrder(&Order{ID: "lmt", Side: Ask, Type: Limit, Price: dec(950), Quantity: dec(1)})
+	if err != nil || len(trades) != 1 {
+		t.Errorf("expected limit order to fill on wide spread, got %d trades, err %v", len(trades), err)
+	}
+}
+
+// 測試 Stats 忽略堆中殘留的空層級，而 Bids.Len() 會將其計入
+func TestStatsIgnoresStaleLevels(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for i := 0; i < 3; i++ {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(100) - DecimalFromInt(int64(i)), Quantity: dec(1)})
+	}
+	ob.PlaceOrder(&Order{ID: "b0_2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a0", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+
+	// 取消非堆頂層級的唯一訂單，層級從映射中移除但仍殘留在堆中
+	ob.CancelOrder("b2")
+
+	if ob.Bids.Len() != 3 {
+		t.Fatalf("expected heap to still hold the stale level (3 entries), got %d", ob.Bids.Len())
+	}
+
+	stats := ob.Stats()
+	want := BookStats{BidLevels: 2, AskLevels: 1, TotalBidOrders: 3, TotalAskOrders: 1, TotalResting: 4}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+// 記錄所有事件的測試用輸出
+type memoryEventSink struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (s *memoryEventSink) Publish(ev Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return s.err
+}
+
+// 測試成交與訂單事件按序號順序發布，且輸出失敗不影響撮合
+func TestEventSinkPublishesInOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	sink := &memoryEventSink{err: errors.New("broker down")}
+	ob.EventSink = sink
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	trades, _ := ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1.5)})
+	if len(trades) != 2 {
+		t.Fatalf("expected matching to proceed despite sink errors, got %d trades", len(trades))
+	}
+	ob.CancelOrder("a2")
+
+	wantTypes := []EventType{EventOrderUpdate, EventOrderUpdate, EventTrade, EventTrade, EventOrderUpdate, EventOrderCancel}
+	if len(sink.events) != len(wantTypes) {
+		t.Fatalf("expected %d events, got %d", len(wantTypes), len(sink.events))
+	}
+	for i, ev := range sink.events {
+		if ev.Sequence != uint64(i+1) {
+			t.Errorf("event %d: expected sequence %d, got %d", i, i+1, ev.Sequence)
+		}
+		if ev.Type != wantTypes[i] {
+			t.Errorf("event %d: expected type %s, got %s", i, wantTypes[i], ev.Type)
+		}
+	}
+	if sink.events[4].Order.Status != Filled {
+		t.Errorf("expected order update snapshot to be filled, got %v", sink.events[4].Order.Status)
+	}
+}
+
+type fakeBroker struct {
+	subjects []string
+}
+
+func (b *fakeBroker) Publish(subject string, data []byte) error {
+	b.subjects = append(b.subjects, subject)
+	return nil
+}
+
+// 測試非同步輸出經由訊息代理轉發全部事件，緩衝區滿時丟棄而不阻塞
+func TestAsyncBrokerSink(t *testing.T) {
+	broker := &fakeBroker{}
+	async := NewAsyncEventSink(&BrokerSink{Conn: broker, Prefix: "book"}, 16)
+	ob := NewOrderBook("BTCUSDT")
+	ob.EventSink = async
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	async.Close()
+
+	want := []string{"book.BTCUSDT.order_update", "book.BTCUSDT.trade", "book.BTCUSDT.order_update"}
+	if fmt.Sprint(broker.subjects) != fmt.Sprint(want) {
+		t.Errorf("expected subjects %v, got %v", want, broker.subjects)
+	}
+
+	// 下游阻塞時緩衝區滿，事件被丟棄
+	block := make(chan struct{})
+	full := NewAsyncEventSink(blockingSink(block), 1)
+	for i := 0; i < 5; i++ {
+		full.Publish(Event{Sequence: uint64(i)})
+	}
+	close(block)
+	full.Close()
+	if full.Dropped() == 0 {
+		t.Errorf("expected events to be dropped when the buffer is full")
+	}
+
+	// 關閉後發布不會 panic，事件被丟棄；重複關閉無影響
+	dropped := full.Dropped()
+	if err := full.Publish(Event{}); !errors.Is(err, ErrEventSinkClosed) {
+		t.Errorf("expected ErrEventSinkClosed after Close, got %v", err)
+	}
+	if full.Dropped() != dropped+1 {
+		t.Errorf("expected the late event to be counted as dropped")
+	}
+	full.Close()
+}
+
+type blockingSink chan struct{}
+
+func (b blockingSink) Publish(Event) error {
+	<-b
+	return nil
+}
+
+// 測試用戶掛單數上限，取消後釋出名額
+func TestMaxOpenOrdersPerUser(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.MaxOpenOrdersPerUser = 3
+
+	for i := 0; i < 3; i++ {
+		if _, err := ob.PlaceOrder(&Order{ID: fmt.Sprintf("o%d", i), UserID: "alice", Side: Bid, Type: Limit, Price: dec(100) - DecimalFromInt(int64(i)), Quantity: dec(1)}); err != nil {
+			t.Fatalf("order %d: unexpected error %v", i, err)
+		}
+	}
+
+	extra := &Order{ID: "o3", UserID: "alice", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(extra); !errors.Is(err, ErrTooManyOpenOrders) {
+		t.Fatalf("expected ErrTooManyOpenOrders, got %v", err)
+	}
+	if extra.Status != Cancelled || extra.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", extra.Status, extra.CancelReason)
+	}
+
+	// 其他用戶不受影響
+	if _, err := ob.PlaceOrder(&Order{ID: "bob1", UserID: "bob", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected other user's order accepted, got %v", err)
+	}
+
+	ob.CancelOrder("o1")
+	if _, err := ob.PlaceOrder(&Order{ID: "o4", UserID: "alice", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected order accepted after cancel, got %v", err)
+	}
+}
+
+// 測試按訂單ID查詢成交，吃單方與各掛單方都能查到
+func TestTradesForOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "m1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "m2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1.5)})
+
+	taker := ob.TradesForOrder("taker")
+	if len(taker) != 2 {
+		t.Fatalf("expected 2 trades for taker, got %d", len(taker))
+	}
+	if taker[0].SellOrderId != "m1" || taker[1].SellOrderId != "m2" {
+		t.Errorf("expected trades against m1 then m2, got %s, %s", taker[0].SellOrderId, taker[1].SellOrderId)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		trades := ob.TradesForOrder(id)
+		if len(trades) != 1 || trades[0].BuyOrderId != "taker" {
+			t.Errorf("expected one trade against taker for %s, got %+v", id, trades)
+		}
+	}
+	if trades := ob.TradesForOrder("unknown"); len(trades) != 0 {
+		t.Errorf("expected no trades for unknown order, got %d", len(trades))
+	}
+}
+
+// 測試參考價格保護：容忍範圍內正常成交，超出部分停止撮合並取消剩餘
+func TestReferencePriceProtection(t *testing.T) {
+	build := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(110), Quantity: dec(1)})
+		return ob
+	}
+
+	// 參考價 100，容忍 50 bps：100 可成交，110 偏離 1000 bps 被截斷
+	ob := build()
+	capped := &Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(110), Quantity: dec(2), ReferencePrice: dec(100), ReferenceToleranceBps: 50}
+	trades, err := ob.PlaceOrder(capped)
+	if err != nil || len(trades) != 1 || trades[0].Price != dec(100) {
+		t.Fatalf("expected single fill at 100, got %d trades, err %v", len(trades), err)
+	}
+	if capped.Status != Cancelled || capped.CancelReason != PriceProtection {
+		t.Errorf("expected remainder cancelled by price protection, got status %v reason %v", capped.Status, capped.CancelReason)
+	}
+	if _, exists := ob.UnFilledOrders["b1"]; exists {
+		t.Errorf("expected capped order not to rest on the book")
+	}
+
+	// 容忍範圍放寬到 1500 bps，兩檔都能成交
+	ob = build()
+	trades, err = ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(110), Quantity: dec(2), ReferencePrice: dec(100), ReferenceToleranceBps: 1500})
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("expected 2 fills within tolerance, got %d trades, err %v", len(trades), err)
+	}
+
+	// 參考價 120：最佳賣價 100 已偏離，完全不成交並拒絕
+	ob = build()
+	rejected := &Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(110), Quantity: dec(1), ReferencePrice: dec(120), ReferenceToleranceBps: 50}
+	trades, err = ob.PlaceOrder(rejected)
+	if !errors.Is(err, ErrPriceDeviation) || len(trades) != 0 {
+		t.Fatalf("expected ErrPriceDeviation, got %d trades, err %v", len(trades), err)
+	}
+	if rejected.CancelReason != PriceProtection {
+		t.Errorf("expected PriceProtection, got %v", rejected.CancelReason)
+	}
+}
+
+// 測試市價單掃過多檔後相對受理時中間價的滑價
+func TestOrderSlippage(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+
+	// 中間價 100，成交均價 (101 + 103) / 2 = 102，滑價 200 bps
+	ob.PlaceOrder(&Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(2)})
+	slippage, err := ob.OrderSlippage("mkt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if math.Abs(slippage-200) > 1e-9 {
+		t.Errorf("expected 200 bps slippage, got %.4f", slippage)
+	}
+
+	// 賣單以低於中間價成交也是正滑價
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "sell", Side: Ask, Type: Market, Quantity: dec(1)})
+	if slippage, _ := ob.OrderSlippage("sell"); math.Abs(slippage-100) > 1e-9 {
+		t.Errorf("expected 100 bps sell slippage, got %.4f", slippage)
+	}
+
+	ob.PlaceOrder(&Order{ID: "bid2", Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "rest", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+	if _, err := ob.OrderSlippage("rest"); !errors.Is(err, ErrNoFills) {
+		t.Errorf("expected ErrNoFills for unfilled order, got %v", err)
+	}
+	if _, err := ob.OrderSlippage("unknown"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+// 測試沒有成交就取消的訂單不保留受理時中間價，且紀錄數有上限
+func TestSubmissionMidBounded(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	ob.PlaceOrder(&Order{ID: "rest", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+	ob.CancelOrder("rest")
+	ob.PlaceOrder(&Order{ID: "ioc", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1), TimeInForce: IOC})
+	for _, id := range []string{"rest", "ioc"} {
+		if _, ok := ob.submissionMid[id]; ok {
+			t.Errorf("expected %s to be forgotten after an unfilled cancel", id)
+		}
+	}
+
+	ob.PlaceOrder(&Order{ID: "take", Side: Bid, Type: Market, Quantity: dec(1)})
+	if _, err := ob.OrderSlippage("take"); err != nil {
+		t.Errorf("expected slippage for a filled order, got %v", err)
+	}
+
+	// 超過上限時淘汰最早的紀錄
+	ob.PlaceOrder(&Order{ID: "ask2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	for i := 0; i < submissionMidLimit; i++ {
+		ob.recordSubmissionMid(&Order{ID: fmt.Sprintf("o%d", i), Side: Bid})
+	}
+	if len(ob.submissionMid) != submissionMidLimit {
+		t.Errorf("expected %d entries, got %d", submissionMidLimit, len(ob.submissionMid))
+	}
+	if _, ok := ob.submissionMid["take"]; ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+}
+
+// 測試 Dump 輸出與 golden 字串一致
+func TestDumpGolden(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(0.5)})
+	ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b4", Side: Bid, Type: Limit, Price: dec(97), Quantity: dec(3)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1.25)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(4)})
+
+	var b strings.Builder
+	if err := ob.Dump(&b, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	golden := `訂單簿 BTCUSDT (序號 6)
+  最佳買價: 99.00
+  最佳賣價: 101.00
+  買方層級: 3 賣方層級: 2
+  買單: 4 賣單: 2 未成交訂單: 6
+  賣盤:
+    102.00 -> 4.0000 (1)
+    101.00 -> 1.2500 (1)
+  買盤:
+    99.00 -> 1.5000 (2)
+    98.00 -> 2.0000 (1)
+`
+	if b.String() != golden {
+		t.Errorf("dump mismatch:\n got:\n%s\nwant:\n%s", b.String(), golden)
+	}
+}
+
+// 測試拒絕造成鎖定市場的掛單；未開啟時等價掛單正常掛在兩邊
+func TestRejectLockingOrders(t *testing.T) {
+	build := func(reject bool) *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.CrossOnEqual = false
+		ob.RejectLockingOrders = reject
+		ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		return ob
+	}
+
+	ob := build(true)
+	bid := &Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(bid); !errors.Is(err, ErrWouldLock) {
+		t.Fatalf("expected ErrWouldLock, got %v", err)
+	}
+	if bid.Status != Cancelled || bid.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", bid.Status, bid.CancelReason)
+	}
+	if _, exists := ob.UnFilledOrders["bid"]; exists {
+		t.Errorf("expected locking order not to rest")
+	}
+	// 非鎖定價格不受影響
+	if _, err := ob.PlaceOrder(&Order{ID: "bid2", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected non-locking order accepted, got %v", err)
+	}
+
+	ob = build(false)
+	if _, err := ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bestBid, bestAsk, _ := ob.GetBestBidAsk()
+	if bestBid != dec(100) || bestAsk != dec(100) {
+		t.Errorf("expected locked book 100/100 without the flag, got %s/%s", bestBid, bestAsk)
+	}
+}
+
+// 測試 GetDepth 按價格排序，且返回值不含任何指標（無法觸及內部訂單）
+func TestGetDepthReturnsPlainLevels(t *testing.T) {
+	var hasPointer func(typ reflect.Type) bool
+	hasPointer = func(typ reflect.Type) bool {
+		switch typ.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+			return true
+		case reflect.Struct:
+			for i := 0; i < typ.NumField(); i++ {
+				if hasPointer(typ.Field(i).Type) {
+					return true
+				}
+			}
+		case reflect.Array:
+			return hasPointer(typ.Elem())
+		}
+		return false
+	}
+	if hasPointer(reflect.TypeOf(DepthLevel{})) {
+		t.Fatalf("DepthLevel must not contain pointers")
+	}
+
+	ob := NewOrderBook("BTCUSDT")
+	for _, price := range []Decimal{dec(97), dec(99), dec(98)} {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%s", price), Side: Bid, Type: Limit, Price: price, Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%s", price+dec(5)), Side: Ask, Type: Limit, Price: price + dec(5), Quantity: dec(1)})
+	}
+	bids, asks := ob.GetDepth(2)
+	if len(bids) != 2 || bids[0].Price != dec(99) || bids[1].Price != dec(98) {
+		t.Errorf("expected bids 99, 98, got %+v", bids)
+	}
+	if len(asks) != 2 || asks[0].Price != dec(102) || asks[1].Price != dec(103) {
+		t.Errorf("expected asks 102, 103, got %+v", asks)
+	}
+}
+
+// 測試 GetDepth 不直接讀取堆的底層切片：多個分散價格層級按價格嚴格單調返回
+func TestGetDepthStrictlyOrdered(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for i, price := range []Decimal{dec(95), dec(99), dec(91), dec(97), dec(93)} {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: price, Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: price + dec(10), Quantity: dec(1)})
+	}
+	// 撤掉中間層級，堆中留下待清理的空層級
+	ob.CancelOrder("b3")
+	ob.CancelOrder("a0")
+
+	bids, asks := ob.GetDepth(10)
+	if len(bids) != 4 || len(asks) != 4 {
+		t.Fatalf("expected 4 levels per side, got %v %v", bids, asks)
+	}
+	for i := 1; i < len(bids); i++ {
+		if bids[i].Price >= bids[i-1].Price {
+			t.Errorf("bids not strictly descending: %v", bids)
+		}
+	}
+	for i := 1; i < len(asks); i++ {
+		if asks[i].Price <= asks[i-1].Price {
+			t.Errorf("asks not strictly ascending: %v", asks)
+		}
+	}
+}
+
+// 測試時間窗口內的成交量只計入窗口內的成交
+func TestVolumeInWindow(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+
+	trade := func(id string, price, qty Decimal) {
+		ob.PlaceOrder(&Order{ID: id + "_ask", Side: Ask, Type: Limit, Price: price, Quantity: qty})
+		ob.PlaceOrder(&Order{ID: id + "_bid", Side: Bid, Type: Limit, Price: price, Quantity: qty})
+	}
+
+	trade("t1", dec(100), dec(1))
+	clock.Advance(30 * time.Minute)
+	trade("t2", dec(110), dec(2))
+	clock.Advance(20 * time.Minute)
+	trade("t3", dec(120), dec(3))
+	clock.Advance(5 * time.Minute)
+
+	// 最近 30 分鐘只含 t2、t3
+	base, quote := ob.VolumeInWindow(30 * time.Minute)
+	if base != dec(5) || quote != dec(110*2+120*3) {
+		t.Errorf("expected 5 / %.2f, got %s / %s", float64(110*2+120*3), base, quote)
+	}
+
+	base, _ = ob.VolumeInWindow(time.Hour)
+	if base != dec(6) {
+		t.Errorf("expected all trades in the last hour, got %s", base)
+	}
+	if base, _ = ob.VolumeInWindow(time.Minute); base != 0 {
+		t.Errorf("expected no trades in the last minute, got %s", base)
+	}
+}
+
+// 測試超過最長存活時間的掛單被清除
+func TestMaxOrderAgeSweep(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.MaxOrderAge = time.Hour
+
+	old := &Order{ID: "old", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	ob.PlaceOrder(old)
+	clock.Advance(30 * time.Minute)
+	ob.PlaceOrder(&Order{ID: "young", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+
+	if swept := ob.SweepExpired(); len(swept) != 0 {
+		t.Fatalf("expected nothing swept before MaxOrderAge, got %v", swept)
+	}
+
+	clock.Advance(31 * time.Minute)
+	swept := ob.SweepExpired()
+	if len(swept) != 1 || swept[0] != "old" {
+		t.Fatalf("expected old order swept, got %v", swept)
+	}
+	if old.Status != Cancelled || old.CancelReason != Expired {
+		t.Errorf("expected expired order, got status %v reason %v", old.Status, old.CancelReason)
+	}
+	if _, exists := ob.UnFilledOrders["young"]; !exists {
+		t.Errorf("expected young order to remain")
+	}
+	if bestBid, _, _ := ob.GetBestBidAsk(); bestBid != 0 {
+		t.Errorf("expected empty bid side after sweep, got %s", bestBid)
+	}
+}
+
+// 測試部分成交後取消，取消事件帶有已成交與被取消的數量
+func TestPartialCancelEvent(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	sink := &memoryEventSink{}
+	ob.EventSink = sink
+
+	ob.PlaceOrder(&Order{ID: "maker", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.CancelOrder("maker")
+
+	last := sink.events[len(sink.events)-1]
+	if last.Type != EventOrderCancel || last.Order.ID != "maker" {
+		t.Fatalf("expected cancel event for maker, got %s for %v", last.Type, last.Order)
+	}
+	if last.FilledQuantity != dec(2) || last.CancelledQuantity != dec(3) {
+		t.Errorf("expected filled 2 / cancelled 3, got %s / %s", last.FilledQuantity, last.CancelledQuantity)
+	}
+}
+
+// 測試改善最佳價至少需 MinImprovementTicks 個價位
+func TestMinImprovementTicks(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.TickSize = dec(0.5)
+	ob.MinImprovementTicks = 2
+	ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(110), Quantity: dec(1)})
+
+	// 只改善一個價位，被拒絕
+	jump := &Order{ID: "jump", Side: Bid, Type: Limit, Price: dec(100.5), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(jump); !errors.Is(err, ErrInsufficientImprovement) {
+		t.Fatalf("expected ErrInsufficientImprovement, got %v", err)
+	}
+	if jump.Status != Cancelled || jump.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", jump.Status, jump.CancelReason)
+	}
+	if _, err := ob.PlaceOrder(&Order{ID: "ask_jump", Side: Ask, Type: Limit, Price: dec(109.5), Quantity: dec(1)}); !errors.Is(err, ErrInsufficientImprovement) {
+		t.Errorf("expected ask improving by one tick rejected, got %v", err)
+	}
+
+	// 改善兩個價位及不改善最佳價的掛單都接受
+	for _, o := range []*Order{
+		{ID: "improve", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1)},
+		{ID: "join", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1)},
+		{ID: "behind", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)},
+	} {
+		if _, err := ob.PlaceOrder(o); err != nil {
+			t.Errorf("%s: expected accepted, got %v", o.ID, err)
+		}
+	}
+	if bestBid, _, _ := ob.GetBestBidAsk(); bestBid != dec(101) {
+		t.Errorf("expected best bid 101, got %s", bestBid)
+	}
+}
+
+// 測試大量掛單、部分成交、撤單後 LevelQuantity 仍精確等於剩餘數量總和
+func TestLevelQuantityExact(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	rng := rand.New(rand.NewSource(42))
+	quantities := []Decimal{dec(0.1), dec(0.2), dec(0.3), dec(0.7), dec(1.1)}
+
+	live := make([]string, 0)
+	for i := 0; i < 2000; i++ {
+		switch rng.Intn(3) {
+		case 0, 1:
+			id := fmt.Sprintf("ask%d", i)
+			ob.PlaceOrder(&Order{ID: id, Side: Ask, Type: Limit, Price: dec(100), Quantity: quantities[rng.Intn(len(quantities))]})
+			live = append(live, id)
+		case 2:
+			if len(live) > 0 && rng.Intn(2) == 0 {
+				j := rng.Intn(len(live))
+				ob.CancelOrder(live[j])
+				live = append(live[:j], live[j+1:]...)
+			} else {
+				ob.PlaceOrder(&Order{ID: fmt.Sprintf("bid%d", i), Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.15)})
+			}
+		}
+	}
+
+	level := ob.AskLevels[dec(100)]
+	if level == nil {
+		t.Fatalf("expected ask level at 100 to remain")
+	}
+	var expected Decimal
+	for _, o := range level.OrderList() {
+		expected += o.Remaining()
+	}
+	if got := ob.LevelQuantity(dec(100), Ask); got != expected {
+		t.Errorf("expected exact level quantity %s, got %s", expected, got)
+	}
+	if got := ob.LevelQuantity(dec(101), Ask); got != 0 {
+		t.Errorf("expected 0 for missing level, got %v", got)
+	}
+}
+
+// 測試價格層級掛單數上限，其他價格不受影響
+func TestMaxOrdersPerLevel(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.MaxOrdersPerLevel = 3
+
+	for i := 0; i < 3; i++ {
+		if _, err := ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}); err != nil {
+			t.Fatalf("order %d: unexpected error %v", i, err)
+		}
+	}
+
+	full := &Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(full); !errors.Is(err, ErrLevelFull) {
+		t.Fatalf("expected ErrLevelFull, got %v", err)
+	}
+	if full.Status != Cancelled || full.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", full.Status, full.CancelReason)
+	}
+	if n := ob.BidLevels[dec(100)].Len(); n != 3 {
+		t.Errorf("expected level to stay at 3 orders, got %d", n)
+	}
+
+	if _, err := ob.PlaceOrder(&Order{ID: "b4", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected order at another price accepted, got %v", err)
+	}
+}
+
+// 測試與撮合並行取得的完整快照內部一致：成交量加上剩餘賣單量等於原始賣單總量
+func TestFullSnapshotConsistent(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	const asks = 20
+	for i := 0; i < asks; i++ {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: dec(100) + DecimalFromInt(int64(i)), Quantity: dec(1)})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < asks*2; i++ {
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Market, Quantity: dec(0.5)})
+		}
+	}()
+
+	check := func(snap BookSnapshot) {
+		var traded, resting Decimal
+		for _, tr := range snap.Trades {
+			traded += tr.Quantity
+		}
+		for _, level := range snap.Asks {
+			resting += level.Quantity
+		}
+		if traded+resting != DecimalFromInt(asks) {
+			t.Fatalf("inconsistent snapshot at seq %d: traded %s + resting %s != %d", snap.Sequence, traded, resting, asks)
+		}
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		check(ob.FullSnapshot())
+	}
+
+	final := ob.FullSnapshot()
+	check(final)
+	if len(final.Asks) != 0 || len(final.Trades) != asks*2 {
+		t.Errorf("expected book swept with %d trades, got %d ask levels and %d trades", asks*2, len(final.Asks), len(final.Trades))
+	}
+}
+
+// 測試以目標收入指定的市價賣單，在多檔買盤上達到目標後停止
+func TestQuoteTargetMarketSell(t *testing.T) {
+	build := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		for i, price := range []Decimal{dec(100), dec(99), dec(98)} {
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: price, Quantity: dec(1)})
+		}
+		return ob
+	}
+	proceeds := func(trades []*Trade) Decimal {
+		var total Decimal
+		for _, tr := range trades {
+			total += tr.Price.Mul(tr.Quantity)
+		}
+		return total
+	}
+
+	ob := build()
+	sell := &Order{ID: "sell", Side: Ask, Type: Market, Quantity: dec(10), QuoteTarget: dec(150)}
+	trades, err := ob.PlaceOrder(sell)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 第二筆數量向下截斷到 8 位小數，成交金額不超過目標
+	if len(trades) != 2 || proceeds(trades) != dec(149.9999995) {
+		t.Fatalf("expected 2 trades with proceeds just under 150, got %d trades with %s", len(trades), proceeds(trades))
+	}
+	if trades[1].Quantity != dec(0.5050505) {
+		t.Errorf("expected last fill clipped to 0.5050505, got %s", trades[1].Quantity)
+	}
+	if sell.Status != Filled {
+		t.Errorf("expected order filled once target reached, got %v", sell.Status)
+	}
+	if q := ob.LevelQuantity(dec(99), Bid); q != dec(0.4949495) {
+		t.Errorf("expected partial bid at 99 to remain, got %s", q)
+	}
+
+	// 買盤不足以達到目標時，剩餘部分取消
+	ob = build()
+	sell = &Order{ID: "sell", Side: Ask, Type: Market, Quantity: dec(10), QuoteTarget: dec(1000)}
+	trades, _ = ob.PlaceOrder(sell)
+	if proceeds(trades) != dec(297) || sell.CancelReason != MarketRemainder {
+		t.Errorf("expected full ladder sold (297) and remainder cancelled, got %s reason %v", proceeds(trades), sell.CancelReason)
+	}
+}
+
+// 測試只減少數量的改單保留時間優先，增加數量或改價則排到隊尾
+func TestAmendOrderPriority(t *testing.T) {
+	build := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		for _, id := range []string{"a", "b", "c"} {
+			ob.PlaceOrder(&Order{ID: id, Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+		}
+		return ob
+	}
+
+	ob := build()
+	if _, err := ob.AmendOrder("a", dec(100), dec(1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("expected decrease to keep priority [a b c], got %v", got)
+	}
+	if q := ob.BidLevels[dec(100)].Quantity; q != dec(5) {
+		t.Errorf("expected level quantity 5 after decrease, got %s", q)
+	}
+
+	ob = build()
+	ob.AmendOrder("a", dec(100), dec(3))
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[b c a]" {
+		t.Errorf("expected increase to lose priority [b c a], got %v", got)
+	}
+	if q := ob.BidLevels[dec(100)].Quantity; q != dec(7) {
+		t.Errorf("expected level quantity 7 after increase, got %s", q)
+	}
+
+	// 改價後移到新層級，並可立即撮合
+	ob = build()
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	trades, err := ob.AmendOrder("b", dec(101), dec(2))
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("expected repriced order to trade once, got %d trades, err %v", len(trades), err)
+	}
+	if got := levelOrderIDs(ob.BidLevels[dec(101)]); fmt.Sprint(got) != "[b]" {
+		t.Errorf("expected remainder resting at 101, got %v", got)
+	}
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[a c]" {
+		t.Errorf("expected [a c] left at 100, got %v", got)
+	}
+
+	if _, err := ob.AmendOrder("b", dec(101), dec(1)); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity when amending below filled, got %v", err)
+	}
+	if _, err := ob.AmendOrder("missing", dec(100), dec(1)); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+// 測試改單在撮合前被拒絕時，原掛單的價格、數量及隊列位置不變
+func TestAmendRejectedKeepsOriginal(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2), PostOnly: true})
+	ob.PlaceOrder(&Order{ID: "c", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	if _, err := ob.AmendOrder("b", dec(101), dec(2)); !errors.Is(err, ErrPostOnlyWouldTake) {
+		t.Fatalf("expected ErrPostOnlyWouldTake, got %v", err)
+	}
+	b := ob.UnFilledOrders["b"]
+	if b == nil || b.Price != dec(100) || b.Quantity != dec(2) {
+		t.Fatalf("expected b to keep 2 @ 100, got %+v", b)
+	}
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("expected b to keep its queue position [a b c], got %v", got)
+	}
+	if q := ob.BidLevels[dec(100)].Quantity; q != dec(6) {
+		t.Errorf("expected level quantity 6, got %s", q)
+	}
+
+	// 排空中改價無法掛單：唯一掛單的層級被移除後重建
+	ob.Drain()
+	if _, err := ob.AmendOrder("ask", dec(102), dec(1)); !errors.Is(err, ErrBookDraining) {
+		t.Fatalf("expected ErrBookDraining, got %v", err)
+	}
+	if _, ask, _ := ob.GetBestBidAsk(); ask != dec(101) {
+		t.Errorf("expected ask to stay at 101, got %s", ask)
+	}
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Errorf("unexpected integrity issues: %v", issues)
+	}
+}
+
+// 測試事件序號缺口：回報期待與實際序號，觸發重新同步，重新同步前不套用後續事件
+func TestAmendPriorityResetThreshold(t *testing.T) {
+	build := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.AmendPriorityResetPct = 10
+		for _, id := range []string{"a", "b", "c"} {
+			ob.PlaceOrder(&Order{ID: id, Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(10)})
+		}
+		return ob
+	}
+
+	// 增幅 5%：可忽略，保留優先權
+	ob := build()
+	if _, err := ob.AmendOrder("a", dec(100), dec(10.5)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("expected small increase to keep priority [a b c], got %v", got)
+	}
+	if q := ob.BidLevels[dec(100)].Quantity; q != dec(30.5) {
+		t.Errorf("expected level quantity 30.5, got %s", q)
+	}
+
+	// 增幅恰好 10%：達到門檻，重新排隊
+	ob = build()
+	ob.AmendOrder("a", dec(100), dec(11))
+	if got := levelOrderIDs(ob.BidLevels[dec(100)]); fmt.Sprint(got) != "[b c a]" {
+		t.Errorf("expected large increase to lose priority [b c a], got %v", got)
+	}
+	if q := ob.BidLevels[dec(100)].Quantity; q != dec(31) {
+		t.Errorf("expected level quantity 31, got %s", q)
+	}
+}
+
+func TestSequenceGapDetector(t *testing.T) {
+	var applied []uint64
+	var gapExpected, gapGot uint64
+	resyncs := 0
+	d := &SequenceGapDetector{
+		Apply:         func(ev Event) { applied = append(applied, ev.Sequence) },
+		OnSequenceGap: func(expected, got uint64) { gapExpected, gapGot = expected, got },
+		OnResync:      func() { resyncs++ },
+	}
+
+	for _, seq := range []uint64{1, 2, 2, 3} {
+		if err := d.Publish(Event{Sequence: seq}); err != nil {
+			t.Fatalf("seq %d: unexpected error %v", seq, err)
+		}
+	}
+	if err := d.Publish(Event{Sequence: 6}); !errors.Is(err, ErrSequenceGap) {
+		t.Fatalf("expected ErrSequenceGap, got %v", err)
+	}
+	if gapExpected != 4 || gapGot != 6 || resyncs != 1 {
+		t.Errorf("expected gap 4 -> 6 with one resync, got %d -> %d, %d resyncs", gapExpected, gapGot, resyncs)
+	}
+
+	// 重新同步前的事件不套用
+	d.Publish(Event{Sequence: 7})
+	d.Resynced(7)
+	d.Publish(Event{Sequence: 8})
+
+	if fmt.Sprint(applied) != "[1 2 3 8]" {
+		t.Errorf("expected applied [1 2 3 8], got %v", applied)
+	}
+}
+
+// 測試受保護市價單在賣盤中途到達最差成交價後停止，剩餘部分取消或掛單
+func TestProtectedMarketOrder(t *testing.T) {
+	build := func(policy ProtectionRemainderPolicy) *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.ProtectionRemainder = policy
+		for i, price := range []Decimal{dec(100), dec(101), dec(102), dec(103)} {
+			ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: price, Quantity: dec(1)})
+		}
+		return ob
+	}
+
+	ob := build(ProtectionCancel)
+	buy := &Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(5), ProtectionPrice: dec(101.5)}
+	trades, err := ob.PlaceOrder(buy)
+	if err != nil || len(trades) != 2 || trades[1].Price != dec(101) {
+		t.Fatalf("expected fills at 100 and 101, got %d trades, err %v", len(trades), err)
+	}
+	if buy.Status != Cancelled || buy.CancelReason != PriceProtection {
+		t.Errorf("expected remainder cancelled by protection, got status %v reason %v", buy.Status, buy.CancelReason)
+	}
+	if _, bestAsk, _ := ob.GetBestBidAsk(); bestAsk != dec(102) {
+		t.Errorf("expected asks from 102 untouched, got best ask %s", bestAsk)
+	}
+
+	ob = build(ProtectionRest)
+	buy = &Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(5), ProtectionPrice: dec(101.5)}
+	trades, _ = ob.PlaceOrder(buy)
+	if len(trades) != 2 {
+		t.Fatalf("expected 2 fills, got %d", len(trades))
+	}
+	if buy.Status != Partial || buy.Type != Limit || buy.Price != dec(101.5) {
+		t.Errorf("expected remainder resting as limit at 101.5, got status %v type %v price %s", buy.Status, buy.Type, buy.Price)
+	}
+	if q := ob.LevelQuantity(dec(101.5), Bid); q != dec(3) {
+		t.Errorf("expected 3 resting at 101.5, got %s", q)
+	}
+}
+
+// 測試空層級比例超過門檻時自動壓縮
+func TestAutoCompaction(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.CompactionThreshold = 0.5
+	for i := 0; i < 10; i++ {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(100) - DecimalFromInt(int64(i)), Quantity: dec(1)})
+	}
+
+	// 取消非堆頂層級，前 5 個使空層級比例達到 0.5 但未超過
+	for i := 9; i >= 5; i-- {
+		ob.CancelOrder(fmt.Sprintf("b%d", i))
+	}
+	if ob.Bids.Len() != 10 || ob.CompactionStats().Runs != 0 {
+		t.Fatalf("expected no compaction at ratio 0.5, got heap %d, runs %d", ob.Bids.Len(), ob.CompactionStats().Runs)
+	}
+
+	ob.CancelOrder("b4")
+	stats := ob.CompactionStats()
+	if stats.Runs != 1 || stats.LevelsRemoved != 6 {
+		t.Errorf("expected one compaction removing 6 levels, got %+v", stats)
+	}
+	if ob.Bids.Len() != 4 || ob.Stats().BidLevels != 4 {
+		t.Errorf("expected heap to match live levels (4), got %d", ob.Bids.Len())
+	}
+	if bestBid, _, _ := ob.GetBestBidAsk(); bestBid != dec(100) {
+		t.Errorf("expected best bid 100 after compaction, got %s", bestBid)
+	}
+}
+
+// 測試淺隊列隊首訂單的成交機率高於深隊列隊尾的訂單
+func TestFillProbabilityHint(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	front := &Order{ID: "front", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	if p := ob.FillProbabilityHint(front); p != 0.5 {
+		t.Errorf("expected neutral 0.5 without trade history, got %.4f", p)
+	}
+
+	// 產生成交紀錄
+	ob.PlaceOrder(&Order{ID: "t_ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "t_bid", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(5)})
+
+	ob.PlaceOrder(front)
+	ob.PlaceOrder(&Order{ID: "behind", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	for i := 0; i < 20; i++ {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("deep%d", i), Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(5)})
+	}
+	back := &Order{ID: "back", Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(1)}
+	ob.PlaceOrder(back)
+
+	pFront, pBack := ob.FillProbabilityHint(front), ob.FillProbabilityHint(back)
+	if pFront <= pBack {
+		t.Errorf("expected front of shallow queue (%.4f) > back of deep queue (%.4f)", pFront, pBack)
+	}
+	// 5 / (5 + 1)
+	if math.Abs(pFront-5.0/6) > 1e-9 {
+		t.Errorf("expected front hint %.4f, got %.4f", 5.0/6, pFront)
+	}
+}
+
+// 測試拒單紀錄記下每筆被拒訂單及原因，並只保留上限筆數
+func TestRejectionLog(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.RejectionLogEnabled = true
+	ob.RejectionLogLimit = 3
+
+	ob.PlaceOrder(&Order{ID: "ok", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "zero_qty", Side: Bid, Type: Limit, Price: dec(100), Quantity: 0})
+	ob.PlaceOrder(&Order{ID: "bad_price", Side: Bid, Type: Limit, Price: dec(-1), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ok", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "no_liq", Side: Bid, Type: Market, Quantity: dec(1)})
+
+	records := ob.RecentRejections(0)
+	want := []struct {
+		id  string
+		err error
+	}{
+		{"bad_price", ErrInvalidPrice},
+		{"ok", ErrDuplicateOrderID},
+		{"no_liq", ErrNoLiquidity},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records (bounded), got %d", len(want), len(records))
+	}
+	for i, w := range want {
+		if records[i].OrderID != w.id || !errors.Is(records[i].Err, w.err) || records[i].Reason != w.err.Error() {
+			t.Errorf("record %d: expected %s / %v, got %s / %v", i, w.id, w.err, records[i].OrderID, records[i].Err)
+		}
+	}
+	if latest := ob.RecentRejections(1); len(latest) != 1 || latest[0].OrderID != "no_liq" {
+		t.Errorf("expected latest rejection no_liq, got %+v", latest)
+	}
+}
+
+// 測試 BestN 的價格與數量陣列對齊且按價格優先排序
+func TestBestN(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for i, price := range []Decimal{dec(98), dec(100), dec(99)} {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: price, Quantity: DecimalFromInt(int64(i + 1))})
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i), Side: Ask, Type: Limit, Price: price + dec(5), Quantity: DecimalFromInt(int64(i + 1))})
+	}
+
+	prices, quantities := ob.BestN(Bid, 2)
+	if fmt.Sprint(prices) != "[100 99]" || fmt.Sprint(quantities) != "[2 3]" {
+		t.Errorf("unexpected bids %v / %v", prices, quantities)
+	}
+	prices, quantities = ob.BestN(Ask, 5)
+	if fmt.Sprint(prices) != "[103 104 105]" || fmt.Sprint(quantities) != "[1 3 2]" {
+		t.Errorf("unexpected asks %v / %v", prices, quantities)
+	}
+	if prices, quantities = ob.BestN(Ask, 0); len(prices) != 0 || len(quantities) != 0 {
+		t.Errorf("expected empty arrays for n=0, got %v / %v", prices, quantities)
+	}
+}
+
+// 測試已知訂單簿上的來回交易成本
+func TestRoundTripCost(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(97), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+
+	// 買 2：均價 102；賣 2：均價 98；中間價 100 -> 400 bps
+	buyAvg, sellAvg, costBps, ok := ob.RoundTripCost(dec(2))
+	if !ok || buyAvg != 102 || sellAvg != 98 || math.Abs(costBps-400) > 1e-9 {
+		t.Errorf("expected 102 / 98 / 400bps, got %.2f / %.2f / %.4f (ok=%v)", buyAvg, sellAvg, costBps, ok)
+	}
+
+	// 只吃第一檔：純價差 200 bps
+	if _, _, costBps, _ = ob.RoundTripCost(dec(1)); math.Abs(costBps-200) > 1e-9 {
+		t.Errorf("expected 200bps for top-of-book size, got %.4f", costBps)
+	}
+
+	if _, _, _, ok = ob.RoundTripCost(dec(3)); ok {
+		t.Errorf("expected ok=false on insufficient depth")
+	}
+	if avg, worst, ok := ob.EstimateMarketImpact(Bid, dec(1.5)); !ok || math.Abs(avg-(101+103*0.5)/1.5) > 1e-9 || worst != dec(103) {
+		t.Errorf("unexpected impact estimate %.4f / %s (ok=%v)", avg, worst, ok)
+	}
+}
+
+// 測試用戶淨部位變化：買入為正、賣出為負，只計入 since 之後的成交
+func TestNetPositionChange(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+
+	trade := func(id, buyer, seller string, qty Decimal) {
+		ob.PlaceOrder(&Order{ID: id + "_ask", UserID: seller, Side: Ask, Type: Limit, Price: dec(100), Quantity: qty})
+		ob.PlaceOrder(&Order{ID: id + "_bid", UserID: buyer, Side: Bid, Type: Limit, Price: dec(100), Quantity: qty})
+	}
+
+	trade("t1", "alice", "bob", dec(5)) // 早於統計起點
+	clock.Advance(time.Minute)
+	since := clock.Now()
+	trade("t2", "alice", "bob", dec(2))
+	trade("t3", "bob", "alice", dec(0.5))
+	trade("t4", "alice", "carol", dec(1.25))
+	trade("t5", "alice", "alice", dec(3)) // 自成交
+
+	if got := ob.NetPositionChange("alice", since); got != dec(2.75) {
+		t.Errorf("expected alice net +2.75, got %s", got)
+	}
+	if got := ob.NetPositionChange("bob", since); got != dec(-1.5) {
+		t.Errorf("expected bob net -1.5, got %s", got)
+	}
+	if got := ob.NetPositionChange("alice", since.Add(-time.Hour)); got != dec(7.75) {
+		t.Errorf("expected alice net +7.75 including earlier fill, got %s", got)
+	}
+}
+
+func TestExecutedNotional(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+
+	// 先掛單再以對手方向吃單，主動方為後者
+	trade := func(id string, aggressor OrderSide, price, qty Decimal) {
+		maker, taker := Ask, Bid
+		if aggressor == Ask {
+			maker, taker = Bid, Ask
+		}
+		ob.PlaceOrder(&Order{ID: id + "_maker", Side: maker, Type: Limit, Price: price, Quantity: qty})
+		ob.PlaceOrder(&Order{ID: id + "_taker", Side: taker, Type: Limit, Price: price, Quantity: qty})
+	}
+
+	trade("t1", Bid, dec(100), dec(10)) // 早於統計起點
+	clock.Advance(time.Minute)
+	since := clock.Now()
+	trade("t2", Bid, dec(100), dec(2))
+	trade("t3", Ask, dec(99), dec(1))
+	trade("t4", Bid, dec(101), dec(0.5))
+	trade("t5", Ask, dec(98), dec(3))
+
+	if got := ob.ExecutedNotional(Bid, since); got != dec(250.5) {
+		t.Errorf("expected buy-initiated notional 250.5, got %s", got)
+	}
+	if got := ob.ExecutedNotional(Ask, since); got != dec(393) {
+		t.Errorf("expected sell-initiated notional 393, got %s", got)
+	}
+	if got := ob.ExecutedNotional(Bid, since.Add(-time.Hour)); got != dec(1250.5) {
+		t.Errorf("expected buy notional 1250.5 including earlier trade, got %s", got)
+	}
+}
+
+func TestAggressorFlow(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+
+	n := 0
+	trade := func(aggressor OrderSide, qty Decimal) {
+		maker, taker := Ask, Bid
+		if aggressor == Ask {
+			maker, taker = Bid, Ask
+		}
+		n++
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("m%d", n), Side: maker, Type: Limit, Price: dec(100), Quantity: qty})
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("t%d", n), Side: taker, Type: Limit, Price: dec(100), Quantity: qty})
+	}
+
+	trade(Bid, dec(10)) // 窗口外
+	clock.Advance(10 * time.Minute)
+	trade(Bid, dec(2))
+	trade(Ask, dec(1.5))
+	clock.Advance(time.Minute)
+	trade(Bid, dec(0.5))
+	trade(Ask, dec(3))
+
+	buy, sell := ob.AggressorFlow(5 * time.Minute)
+	if buy != dec(2.5) || sell != dec(4.5) {
+		t.Errorf("expected 2.5 buy / 4.5 sell in window, got %v / %v", buy, sell)
+	}
+	buy, sell = ob.AggressorFlow(30 * time.Second)
+	if buy != dec(0.5) || sell != dec(3) {
+		t.Errorf("expected 0.5 buy / 3 sell in short window, got %v / %v", buy, sell)
+	}
+	if buy, _ := ob.AggressorFlow(time.Hour); buy != dec(12.5) {
+		t.Errorf("expected 12.5 buy in hour window, got %v", buy)
+	}
+}
+
+func TestTradePriceBand(t *testing.T) {
+	build := func(halt bool) *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.TradeBandPct = 5
+		ob.HaltOnBandBreach = halt
+		ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		// 遠離上一筆成交價的孤立賣單
+		ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(120), Quantity: dec(1)})
+		return ob
+	}
+
+	// 只標記：成交照常執行
+	ob := build(false)
+	trades, err := ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Market, Quantity: dec(1)})
+	if err != nil || len(trades) != 1 || trades[0].Price != dec(120) {
+		t.Fatalf("expected outlier trade to execute, got %v %v", trades, err)
+	}
+	breaches := ob.BandBreaches()
+	if len(breaches) != 1 || breaches[0].PrevPrice != dec(100) || breaches[0].Price != dec(120) || breaches[0].Halted {
+		t.Fatalf("unexpected breaches: %+v", breaches)
+	}
+	if ob.Halted() {
+		t.Errorf("expected book not halted")
+	}
+
+	// 暫停：成交不執行，後續下單被拒直到恢復
+	ob = build(true)
+	bid := &Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(120), Quantity: dec(1)}
+	trades, err = ob.PlaceOrder(bid)
+	if !errors.Is(err, ErrBookHalted) || len(trades) != 0 {
+		t.Fatalf("expected ErrBookHalted with no trades, got %v %v", trades, err)
+	}
+	if !ob.Halted() || len(ob.BandBreaches()) != 1 || !ob.BandBreaches()[0].Halted {
+		t.Fatalf("expected halted book with recorded breach")
+	}
+	if bid.Status != Cancelled || bid.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", bid.Status, bid.CancelReason)
+	}
+	if _, exists := ob.UnFilledOrders["a2"]; !exists {
+		t.Errorf("expected resting ask untouched")
+	}
+	if _, err := ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)}); !errors.Is(err, ErrBookHalted) {
+		t.Errorf("expected ErrBookHalted while halted, got %v", err)
+	}
+
+	ob.Resume()
+	if _, err := ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected order accepted after resume, got %v", err)
+	}
+}
+
+// 測試只標記不暫停時價格帶異常紀錄有上限，保留最新的紀錄
+func TestBandBreachesBounded(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.TradeBandPct = 5
+	ob.BandBreachLimit = 3
+	ob.PlaceOrder(&Order{ID: "a0", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b0", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+	// 價格來回跳動，每筆成交都偏離前一筆
+	for i := 0; i < 10; i++ {
+		price := dec(100)
+		if i%2 == 0 {
+			price = dec(120)
+		}
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("a%d", i+1), Side: Ask, Type: Limit, Price: price, Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i+1), Side: Bid, Type: Market, Quantity: dec(1)})
+	}
+
+	breaches := ob.BandBreaches()
+	if len(breaches) != 3 {
+		t.Fatalf("expected 3 retained breaches, got %d", len(breaches))
+	}
+	if last := breaches[len(breaches)-1]; last.Price != dec(100) || last.PrevPrice != dec(120) {
+		t.Errorf("expected newest breach retained, got %+v", last)
+	}
+}
+
+func TestMarshalL2JSON(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99.5), Quantity: dec(1.25)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(0.1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101.25), Quantity: dec(0.5)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(3)})
+
+	data, err := ob.MarshalL2JSON(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got struct {
+		Bids     [][]string `json:"bids"`
+		Asks     [][]string `json:"asks"`
+		Sequence uint64     `json:"sequence"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+
+	wantBids := [][]string{{"100", "2"}, {"99.5", "1.25"}}
+	wantAsks := [][]string{{"101", "3"}, {"101.25", "0.5"}}
+	if !reflect.DeepEqual(got.Bids, wantBids) || !reflect.DeepEqual(got.Asks, wantAsks) {
+		t.Errorf("unexpected levels: bids %v asks %v", got.Bids, got.Asks)
+	}
+	if got.Sequence != ob.Sequence() {
+		t.Errorf("expected sequence %d, got %d", ob.Sequence(), got.Sequence)
+	}
+}
+
+func TestMinVisibleLevelQuantity(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.MinVisibleLevelQuantity = dec(1)
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.4)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.4)})
+	ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(2)})
+
+	bids, _ := ob.GetDepth(5)
+	if len(bids) != 1 || bids[0].Price != dec(99) {
+		t.Fatalf("expected only 99 visible, got %+v", bids)
+	}
+	if bestBid, _, _ := ob.GetBestBidAsk(); bestBid != dec(100) {
+		t.Errorf("expected hidden level still best bid for matching, got %v", bestBid)
+	}
+
+	ob.PlaceOrder(&Order{ID: "b4", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.2)})
+	bids, _ = ob.GetDepth(5)
+	if len(bids) != 2 || bids[0].Price != dec(100) || bids[0].Quantity != dec(1) {
+		t.Fatalf("expected 100 visible after accumulating, got %+v", bids)
+	}
+
+	// 撮合仍使用隱藏的層級
+	ob.PlaceOrder(&Order{ID: "b5", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(0.5)})
+	trades, _ := ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Market, Quantity: dec(0.5)})
+	if len(trades) != 1 || trades[0].Price != dec(101) {
+		t.Errorf("expected market order to match hidden level, got %+v", trades)
+	}
+}
+
+func TestFlushSide(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+
+	n, ids := ob.FlushSide(Bid)
+	if n != 3 || !reflect.DeepEqual(ids, []string{"b1", "b2", "b3"}) {
+		t.Fatalf("expected 3 flushed bids, got %d %v", n, ids)
+	}
+	if ob.Bids.Len() != 0 || len(ob.BidLevels) != 0 {
+		t.Errorf("expected empty bid side, got heap %d levels %d", ob.Bids.Len(), len(ob.BidLevels))
+	}
+	if ob.Asks.Len() != 2 || len(ob.AskLevels) != 2 || len(ob.UnFilledOrders) != 2 {
+		t.Errorf("expected ask side intact, got heap %d levels %d orders %d", ob.Asks.Len(), len(ob.AskLevels), len(ob.UnFilledOrders))
+	}
+	bids, asks := ob.GetDepth(5)
+	if len(bids) != 0 || len(asks) != 2 {
+		t.Errorf("unexpected depth after flush: %+v %+v", bids, asks)
+	}
+
+	// 清空後可正常重新掛單
+	ob.PlaceOrder(&Order{ID: "b4", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	if bestBid, bestAsk, ok := ob.GetBestBidAsk(); !ok || bestBid != dec(100) || bestAsk != dec(101) {
+		t.Errorf("unexpected best bid/ask %v %v %v", bestBid, bestAsk, ok)
+	}
+}
+
+func TestCancelLevelStats(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	for i := 0; i < 50; i++ {
+		ob.PlaceOrder(&Order{ID: fmt.Sprintf("b%d", i), Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	}
+	ob.PlaceOrder(&Order{ID: "lone", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+
+	ob.CancelOrder("b10")
+	stats := ob.CancelLevelStats()
+	if stats.Count != 1 || stats.MaxLevelSize != 50 || stats.AvgLevelSize != 50 {
+		t.Fatalf("unexpected stats after first cancel: %+v", stats)
+	}
+
+	ob.CancelOrder("lone")
+	stats = ob.Metrics().Cancels
+	if stats.Count != 2 || stats.MaxLevelSize != 50 || stats.AvgLevelSize != 25.5 {
+		t.Errorf("unexpected stats after second cancel: %+v", stats)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "regenerate golden files under testdata")
+
+// 重播腳本中的一個步驟
+type replayStep struct {
+	Op       string  `json:"op"` // place、cancel 或 advance
+	ID       string  `json:"id"`
+	User     string  `json:"user"`
+	Side     string  `json:"side"` // bid 或 ask
+	Type     string  `json:"type"` // limit 或 market
+	Price    Decimal `json:"price"`
+	Quantity Decimal `json:"quantity"`
+	Ms       int     `json:"ms"` // advance 推進的毫秒數
+}
+
+// 以固定時鐘與序號成交ID執行腳本，返回成交紀錄的文字形式
+func runReplay(t *testing.T, steps []replayStep) string {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.SequentialTradeIDs = true
+
+	var b strings.Builder
+	for i, step := range steps {
+		switch step.Op {
+		case "place":
+			o := &Order{ID: step.ID, UserID: step.User, Side: Bid, Type: Limit, Price: step.Price, Quantity: step.Quantity}
+			if step.Side == "ask" {
+				o.Side = Ask
+			}
+			if step.Type == "market" {
+				o.Type = Market
+			}
+			trades, err := ob.PlaceOrder(o)
+			if err != nil {
+				fmt.Fprintf(&b, "# %s rejected: %v\n", step.ID, err)
+			}
+			for _, tr := range trades {
+				fmt.Fprintf(&b, "%s buy=%s sell=%s aggressor=%s price=%s qty=%s at=+%s\n",
+					tr.ID, tr.BuyOrderId, tr.SellOrderId, GetSideName(tr.AggressorSide), tr.Price, tr.Quantity, tr.Timestamp.Sub(start))
+			}
+		case "cancel":
+			if !ob.CancelOrder(step.ID) {
+				fmt.Fprintf(&b, "# %s cancel failed\n", step.ID)
+			}
+		case "advance":
+			clock.Advance(time.Duration(step.Ms) * time.Millisecond)
+		default:
+			t.Fatalf("step %d: unknown op %q", i, step.Op)
+		}
+	}
+	return b.String()
+}
+
+// 執行固定腳本並與黃金成交紀錄比對，鎖定撮合行為；以 go test -run TestReplayGoldenTape -update 重新產生
+func TestReplayGoldenTape(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "replay_orders.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var steps []replayStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runReplay(t, steps)
+	if again := runReplay(t, steps); again != got {
+		t.Fatalf("replay not deterministic:\n%s\nvs\n%s", got, again)
+	}
+
+	golden := filepath.Join("testdata", "replay_trades.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("trade tape mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTradeEventRunningVWAP(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+	sink := &memoryEventSink{}
+	ob.EventSink = sink
+
+	buy := &Order{ID: "buy", Side: Bid, Type: Limit, Price: dec(103), Quantity: dec(5)}
+	ob.PlaceOrder(buy)
+
+	want := []OrderFill{
+		{OrderID: "buy", FilledQuantity: dec(1), AvgPrice: 100, Remaining: dec(4)},
+		{OrderID: "buy", FilledQuantity: dec(3), AvgPrice: 302.0 / 3, Remaining: dec(2)},
+		{OrderID: "buy", FilledQuantity: dec(4), AvgPrice: 405.0 / 4, Remaining: dec(1)},
+	}
+	var got []OrderFill
+	for _, ev := range sink.events {
+		if ev.Type != EventTrade {
+			continue
+		}
+		if ev.BuyFill == nil || ev.SellFill == nil {
+			t.Fatalf("expected fills on trade event %d", ev.Sequence)
+		}
+		if ev.SellFill.Remaining != 0 || ev.SellFill.AvgPrice != ev.Trade.Price.Float64() {
+			t.Errorf("unexpected maker fill %+v for trade at %v", ev.SellFill, ev.Trade.Price)
+		}
+		got = append(got, *ev.BuyFill)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d trade events, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].FilledQuantity != want[i].FilledQuantity || got[i].Remaining != want[i].Remaining ||
+			math.Abs(got[i].AvgPrice-want[i].AvgPrice) > 1e-9 {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if math.Abs(buy.AvgFillPrice()-405.0/4) > 1e-9 {
+		t.Errorf("unexpected order average price %v", buy.AvgFillPrice())
+	}
+}
+
+func TestOpeningSnapshot(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	if _, ok := ob.OpeningDepth(); ok {
+		t.Fatalf("expected no opening snapshot before capture")
+	}
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.SetOpeningSnapshot()
+
+	// 開盤後的變動不影響開盤快照
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1.5)})
+	ob.CancelOrder("b1")
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+
+	snap, ok := ob.OpeningDepth()
+	if !ok {
+		t.Fatalf("expected opening snapshot")
+	}
+	wantBids := []DepthLevel{{Price: dec(99), Quantity: dec(1), Orders: 1}}
+	wantAsks := []DepthLevel{{Price: dec(101), Quantity: dec(2), Orders: 1}}
+	if !reflect.DeepEqual(snap.Bids, wantBids) || !reflect.DeepEqual(snap.Asks, wantAsks) {
+		t.Errorf("unexpected opening depth: bids %+v asks %+v", snap.Bids, snap.Asks)
+	}
+	if snap.Mid != dec(100) || snap.LastPrice != 0 {
+		t.Errorf("unexpected opening mid %v last %v", snap.Mid, snap.LastPrice)
+	}
+	bids, asks := ob.GetDepth(5)
+	if len(bids) != 0 || len(asks) != 2 || asks[0].Quantity != dec(0.5) {
+		t.Errorf("expected live depth to differ from opening, got %+v %+v", bids, asks)
+	}
+
+	// 開盤參考價為中間價 100，最新成交 101
+	change, pct, ok := ob.IntradayChange()
+	if !ok || change != dec(1) || pct != 1 {
+		t.Errorf("expected +1 (1%%) intraday change, got %v %v %v", change, pct, ok)
+	}
+	if base, quote := ob.VolumeSinceOpen(); base != dec(1.5) || quote != dec(151.5) {
+		t.Errorf("unexpected volume since open %v %v", base, quote)
+	}
+}
+
+func TestRequantizeMergesByTimePriority(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.TickSize = dec(0.1)
+	for _, o := range []*Order{
+		{ID: "b1", Side: Bid, Type: Limit, Price: dec(100.2), Quantity: dec(1)},
+		{ID: "b2", Side: Bid, Type: Limit, Price: dec(100.4), Quantity: dec(1)},
+		{ID: "b3", Side: Bid, Type: Limit, Price: dec(100.2), Quantity: dec(1)},
+		{ID: "b4", Side: Bid, Type: Limit, Price: dec(100.4), Quantity: dec(1)},
+		{ID: "a1", Side: Ask, Type: Limit, Price: dec(100.6), Quantity: dec(2)},
+	} {
+		o.Timestamp = clock.Now()
+		ob.PlaceOrder(o)
+		clock.Advance(time.Second)
+	}
+
+	merged, err := ob.Requantize(dec(0.5))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged != 1 {
+		t.Errorf("expected 1 level merged, got %d", merged)
+	}
+	level := ob.BidLevels[dec(100)]
+	if level == nil || len(ob.BidLevels) != 1 {
+		t.Fatalf("expected a single bid level at 100, got %v", ob.BidLevels)
+	}
+	// 兩個舊層級的訂單按下單時間交錯，而不是整個層級串接
+	if got := levelOrderIDs(level); fmt.Sprint(got) != "[b1 b2 b3 b4]" {
+		t.Errorf("expected merged order [b1 b2 b3 b4], got %v", got)
+	}
+	orders := level.OrderList()
+	for i := 1; i < len(orders); i++ {
+		if orders[i].Timestamp.Before(orders[i-1].Timestamp) {
+			t.Errorf("order %s placed before %s but queued after it", orders[i].ID, orders[i-1].ID)
+		}
+	}
+	if level.Quantity != dec(4) {
+		t.Errorf("expected merged quantity 4, got %v", level.Quantity)
+	}
+	if bestBid, bestAsk, _ := ob.GetBestBidAsk(); bestBid != dec(100) || bestAsk != dec(101) {
+		t.Errorf("expected bids rounded down and asks up, got %v / %v", bestBid, bestAsk)
+	}
+	if issues := ob.CheckIntegrity(); len(issues) != 0 {
+		t.Errorf("unexpected integrity issues: %v", issues)
+	}
+
+	// 合併後最早的訂單先成交
+	trades, _ := ob.PlaceOrder(&Order{ID: "s", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	if len(trades) != 2 || trades[0].BuyOrderId != "b1" || trades[1].BuyOrderId != "b2" {
+		t.Errorf("expected b1 then b2 to fill first, got %+v", trades)
+	}
+
+	if _, err := ob.Requantize(0); !errors.Is(err, ErrInvalidTickSize) {
+		t.Errorf("expected ErrInvalidTickSize, got %v", err)
+	}
+}
+
+func TestResolveCrossesAfterAmend(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(3)})
+
+	trades, err := ob.AmendOrder("b1", dec(102), dec(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 2 || trades[0].Price != dec(101) || trades[1].Price != dec(102) || trades[0].AggressorSide != Bid {
+		t.Fatalf("expected fills at maker prices 101 and 102, got %+v", trades)
+	}
+	bestBid, _, _ := ob.GetBestBidAsk()
+	if bestBid != dec(102) || ob.Asks.Len() != 0 || ob.BidLevels[dec(102)].Quantity != dec(1) {
+		t.Errorf("expected clean book with 1 bid left at 102, got best bid %v", bestBid)
+	}
+
+	// 直接放入的交叉掛單：較晚的一方為主動方，以較早掛單的價格成交
+	ob = NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.AddBidToOrderBook(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(103), Quantity: dec(1)})
+	ob.mutex.Lock()
+	trades = ob.resolveCrosses()
+	ob.mutex.Unlock()
+	if len(trades) != 1 || trades[0].Price != dec(101) || trades[0].AggressorSide != Bid || trades[0].Quantity != dec(1) {
+		t.Fatalf("unexpected uncross trades %+v", trades)
+	}
+	if ob.Bids.Len() != 0 || ob.AskLevels[dec(101)].Quantity != dec(1) {
+		t.Errorf("expected crossed bid fully matched")
+	}
+
+	ob.AutoUncross = false
+	ob.AddBidToOrderBook(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(103), Quantity: dec(1)})
+	ob.mutex.Lock()
+	trades = ob.resolveCrosses()
+	ob.mutex.Unlock()
+	if len(trades) != 0 {
+		t.Errorf("expected no uncross when disabled, got %+v", trades)
+	}
+}
+
+func TestDrainingBook(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.Drain()
+	if ob.State() != BookDraining {
+		t.Fatalf("expected draining state, got %v", GetBookStateName(ob.State()))
+	}
+
+	// 可立即成交的限價單照常成交
+	trades, err := ob.PlaceOrder(&Order{ID: "t1", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	if err != nil || len(trades) != 1 || trades[0].Price != dec(101) {
+		t.Fatalf("expected marketable order to fill, got %v %v", trades, err)
+	}
+	if trades, err := ob.PlaceOrder(&Order{ID: "t2", Side: Ask, Type: Market, Quantity: dec(0.5)}); err != nil || len(trades) != 1 {
+		t.Errorf("expected market order to fill, got %v %v", trades, err)
+	}
+
+	// 只掛單的訂單被拒絕
+	rest := &Order{ID: "r1", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(rest); !errors.Is(err, ErrBookDraining) {
+		t.Fatalf("expected ErrBookDraining, got %v", err)
+	}
+	if rest.Status != Cancelled || rest.CancelReason != Rejected {
+		t.Errorf("expected rejected order, got status %v reason %v", rest.Status, rest.CancelReason)
+	}
+	// 部分成交後剩餘部分不掛單
+	partial := &Order{ID: "t3", Side: Bid, Type: Limit, Price: dec(102), Quantity: dec(3)}
+	trades, err = ob.PlaceOrder(partial)
+	if !errors.Is(err, ErrBookDraining) || len(trades) != 1 || partial.FilledQuantity != dec(1) {
+		t.Errorf("expected fill then rejected remainder, got %v %v", trades, err)
+	}
+	if _, exists := ob.UnFilledOrders["t3"]; exists {
+		t.Errorf("expected remainder not to rest")
+	}
+
+	// 撤單照常
+	if !ob.CancelOrder("b1") {
+		t.Errorf("expected cancel allowed while draining")
+	}
+
+	ob.ConfirmShutdown()
+	if _, err := ob.PlaceOrder(&Order{ID: "t4", Side: Bid, Type: Market, Quantity: dec(1)}); !errors.Is(err, ErrBookHalted) {
+		t.Errorf("expected ErrBookHalted after shutdown, got %v", err)
+	}
+	ob.Resume()
+	if _, err := ob.PlaceOrder(&Order{ID: "r2", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)}); err != nil {
+		t.Errorf("expected resting order accepted after resume, got %v", err)
+	}
+}
+
+func TestOrderMetadataPassthrough(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1), Metadata: map[string]string{"strategy": "mm-1"}})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	tags := map[string]string{"strategy": "twap-7", "desk": "alpha"}
+	trades, err := ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(2), Metadata: tags})
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("expected 2 trades, got %v %v", trades, err)
+	}
+	for _, tr := range trades {
+		if !reflect.DeepEqual(tr.BuyMetadata, tags) {
+			t.Errorf("expected aggressor metadata on trade %s, got %v", tr.ID, tr.BuyMetadata)
+		}
+	}
+	if trades[0].SellMetadata["strategy"] != "mm-1" || trades[1].SellMetadata != nil {
+		t.Errorf("unexpected maker metadata %v / %v", trades[0].SellMetadata, trades[1].SellMetadata)
+	}
+	// 成交紀錄持有自己的副本
+	tags["desk"] = "changed"
+	if trades[0].BuyMetadata["desk"] != "alpha" {
+		t.Errorf("expected trade metadata isolated from order map")
+	}
+
+	data, err := json.Marshal(ob.FullSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored BookSnapshot
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Trades) != 2 || restored.Trades[1].BuyMetadata["strategy"] != "twap-7" || restored.Trades[0].SellMetadata["strategy"] != "mm-1" {
+		t.Errorf("expected metadata to survive snapshot round-trip, got %+v", restored.Trades)
+	}
+}
+
+func TestMatchOrdersExactEqualFill(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1.5)})
+	bid := &Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1.5)}
+	trades, err := ob.PlaceOrder(bid)
+	if err != nil || len(trades) != 1 || trades[0].Quantity != dec(1.5) {
+		t.Fatalf("expected single full fill, got %v %v", trades, err)
+	}
+	if bid.Status != Filled || bid.Remaining() != 0 {
+		t.Errorf("expected aggressor filled, got %v remaining %v", bid.Status, bid.Remaining())
+	}
+	if _, exists := ob.UnFilledOrders[trades[0].SellOrderId]; exists {
+		t.Errorf("expected maker removed from unfilled orders")
+	}
+	if len(ob.UnFilledOrders) != 0 || len(ob.AskLevels) != 0 || len(ob.BidLevels) != 0 {
+		t.Errorf("expected empty book, got %d orders %d asks %d bids", len(ob.UnFilledOrders), len(ob.AskLevels), len(ob.BidLevels))
+	}
+}
+
+func TestMatchOrdersRejectsTwoMarketOrders(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	buy := &Order{ID: "b", Side: Bid, Type: Market, Quantity: dec(1)}
+	sell := &Order{ID: "s", Side: Ask, Type: Market, Quantity: dec(1)}
+	ob.UnFilledOrders["b"], ob.UnFilledOrders["s"] = buy, sell
+
+	if trade := ob.matchOrders(buy, sell, dec(100), Bid); trade != nil {
+		t.Fatalf("expected nil trade for two market orders, got %+v", trade)
+	}
+	if buy.FilledQuantity != 0 || sell.FilledQuantity != 0 || buy.Status != Pending || len(ob.UnFilledOrders) != 2 {
+		t.Errorf("expected no state change, got buy %+v sell %+v", buy, sell)
+	}
+}
+
+func TestPeggedOrderRepegsToMid(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.AutoRepeg = true
+	ob.RepegMinInterval = time.Second
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+
+	peg := &Order{ID: "peg", Side: Bid, Type: Limit, Peg: PegMid, PegOffset: dec(-0.5), Quantity: dec(1)}
+	if _, err := ob.PlaceOrder(peg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peg.Price != dec(99.5) {
+		t.Fatalf("expected pegged price 99.5, got %v", peg.Price)
+	}
+
+	// 中間價上移到 101，重新掛鉤到 100.5
+	clock.Advance(2 * time.Second)
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.CancelOrder("a1")
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	if peg.Price != dec(100.5) {
+		t.Fatalf("expected pegged price to track mid to 100.5, got %v", peg.Price)
+	}
+	if level := ob.BidLevels[dec(100.5)]; level == nil || level.Front() != peg {
+		t.Errorf("expected pegged order resting at 100.5")
+	}
+	if _, exists := ob.BidLevels[dec(99.5)]; exists {
+		t.Errorf("expected old pegged level removed")
+	}
+
+	// 間隔內的變動先不處理，間隔過後的下一次變動才重新掛鉤
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	if peg.Price != dec(100.5) {
+		t.Errorf("expected re-peg throttled, got %v", peg.Price)
+	}
+	clock.Advance(2 * time.Second)
+	ob.CancelOrder("b2")
+	if peg.Price != dec(99) {
+		t.Errorf("expected pegged price 99 after throttle window, got %v", peg.Price)
+	}
+
+	if _, err := NewOrderBook("X").PlaceOrder(&Order{ID: "p", Side: Bid, Type: Limit, Peg: PegMid, Quantity: dec(1)}); !errors.Is(err, ErrNoPegReference) {
+		t.Errorf("expected ErrNoPegReference on empty book, got %v", err)
+	}
+}
+
+// 測試重新掛鉤產生的成交返回給呼叫者、被拒絕的重新掛鉤以取消事件結束，且不需要 AutoRepeg 也會清理已結束的掛鉤單
+func TestRepegTradesAndRejections(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.AutoRepeg = true
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+	peg := &Order{ID: "peg", Side: Bid, Type: Limit, Peg: PegPrimary, PegOffset: dec(1), Quantity: dec(1)}
+	ob.PlaceOrder(peg)
+
+	// 最佳買價上移到 101.5，掛鉤單移到 102.5 與賣單成交
+	trades, err := ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(101.5), Quantity: dec(1)})
+	if err != nil || len(trades) != 1 || trades[0].BuyOrderId != "peg" || trades[0].SellOrderId != "a1" {
+		t.Fatalf("expected repeg trade returned to caller, got %v (%v)", trades, err)
+	}
+	if peg.Status != Filled || len(ob.pegged) != 0 {
+		t.Errorf("expected filled peg untracked, got %s with %d pegged", GetStatusName(peg.Status), len(ob.pegged))
+	}
+
+	// 排空中重新掛鉤的掛單被拒絕：取消並發布取消事件
+	sink := &memoryEventSink{}
+	ob.EventSink = sink
+	drained := &Order{ID: "drained", Side: Bid, Type: Limit, Peg: PegPrimary, Quantity: dec(1)}
+	ob.PlaceOrder(drained)
+	ob.Drain()
+	ob.CancelOrder("b2")
+	if drained.Status != Cancelled || drained.CancelReason != Rejected {
+		t.Errorf("expected rejected repeg cancelled, got %s/%s", GetStatusName(drained.Status), GetCancelReasonName(drained.CancelReason))
+	}
+	cancelled := false
+	for _, e := range sink.events {
+		if e.Type == EventOrderCancel && e.Order != nil && e.Order.ID == "drained" {
+			cancelled = true
+		}
+	}
+	if !cancelled || len(ob.pegged) != 0 {
+		t.Errorf("expected cancel event and peg untracked, got event %v with %d pegged", cancelled, len(ob.pegged))
+	}
+
+	// 未開啟 AutoRepeg 時取消掛鉤單同樣清理
+	ob = NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "peg", Side: Bid, Type: Limit, Peg: PegPrimary, Quantity: dec(1)})
+	ob.CancelOrder("peg")
+	if len(ob.pegged) != 0 {
+		t.Errorf("expected cancelled peg untracked, got %d", len(ob.pegged))
+	}
+}
+
+func TestStreamEventsJSONL(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ob.StreamEventsJSONL(&buf, ctx) }()
+
+	// 等待訂閱建立
+	for subscribed := false; !subscribed; {
+		ob.mutex.RLock()
+		subscribed = len(ob.subscribers) > 0
+		ob.mutex.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.CancelOrder("a1")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	wantTypes := []EventType{EventOrderUpdate, EventTrade, EventOrderUpdate, EventOrderCancel}
+	if len(lines) != len(wantTypes) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(wantTypes), len(lines), buf.String())
+	}
+	for i, line := range lines {
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", i, err)
+		}
+		if ev.Type != wantTypes[i] || ev.Sequence != uint64(i+1) || ev.Symbol != "BTCUSDT" {
+			t.Errorf("line %d: unexpected event %+v", i, ev)
+		}
+	}
+	var trade Event
+	json.Unmarshal([]byte(lines[1]), &trade)
+	if trade.Trade == nil || trade.Trade.Price != dec(100) || trade.Trade.Quantity != dec(1) || trade.BuyFill.OrderID != "b1" {
+		t.Errorf("unexpected trade event %+v", trade)
+	}
+	var cancelled Event
+	json.Unmarshal([]byte(lines[3]), &cancelled)
+	if cancelled.Order.ID != "a1" || cancelled.FilledQuantity != dec(1) || cancelled.CancelledQuantity != dec(1) {
+		t.Errorf("unexpected cancel event %+v", cancelled)
+	}
+
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+	if len(ob.subscribers) != 0 {
+		t.Errorf("expected subscription removed after cancel")
+	}
+}
+
+func TestMatchOrdersRejectsSameOrder(t *testing.T) {
+	var logged []string
+	ob := NewOrderBook("BTCUSDT")
+	ob.Logf = func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+	o := &Order{ID: "x", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	if trade := ob.matchOrders(o, o, dec(100), Bid); trade != nil {
+		t.Fatalf("expected nil trade for same order on both sides, got %+v", trade)
+	}
+	if o.FilledQuantity != 0 || len(logged) != 1 {
+		t.Errorf("expected untouched order and one log line, got filled %v logs %v", o.FilledQuantity, logged)
+	}
+
+	// 構造損壞的狀態：賣方層級中殘留一筆與新買單同ID的訂單
+	ob.AddAskToOrderBook(&Order{ID: "x", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	delete(ob.UnFilledOrders, "x")
+	bid := &Order{ID: "x", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	trades, err := ob.PlaceOrder(bid)
+	if !errors.Is(err, ErrMatchInvariant) || len(trades) != 0 || len(ob.Trades) != 0 {
+		t.Fatalf("expected no self-trade and ErrMatchInvariant, got %v %v", trades, err)
+	}
+	if bid.Status != Cancelled || len(ob.BidLevels) != 0 {
+		t.Errorf("expected rejected order not resting, got status %v", bid.Status)
+	}
+	if len(logged) != 2 {
+		t.Errorf("expected violation logged, got %v", logged)
+	}
+}
+
+func TestOpeningAuctionWindow(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.OpeningAuctionWindow = time.Minute
+
+	for _, o := range []*Order{
+		{ID: "b1", Side: Bid, Type: Limit, Price: dec(102), Quantity: dec(1)},
+		{ID: "a1", Side: Ask, Type: Limit, Price: dec(99), Quantity: dec(1)},
+		{ID: "b2", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(2)},
+		{ID: "a2", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(2)},
+		{ID: "a3", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)},
+	} {
+		trades, err := ob.PlaceOrder(o)
+		if err != nil || len(trades) != 0 {
+			t.Fatalf("expected %s to accumulate without matching, got %v %v", o.ID, trades, err)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	if _, err := ob.PlaceOrder(&Order{ID: "m", Side: Bid, Type: Market, Quantity: dec(1)}); !errors.Is(err, ErrMarketOrderInAuction) {
+		t.Errorf("expected market orders rejected during opening auction, got %v", err)
+	}
+	if _, ok := ob.OpeningAuctionResult(); ok {
+		t.Fatalf("expected auction still open")
+	}
+
+	clock.Advance(time.Minute)
+	ob.CheckOpeningAuction()
+	result, ok := ob.OpeningAuctionResult()
+	if !ok || result.Quantity != dec(3) || len(result.Trades) == 0 {
+		t.Fatalf("expected opening auction to clear 3, got %+v %v", result, ok)
+	}
+	for _, tr := range result.Trades {
+		if tr.Price != result.Price {
+			t.Errorf("expected single opening price %v, got trade at %v", result.Price, tr.Price)
+		}
+	}
+	if len(ob.Trades) != len(result.Trades) {
+		t.Errorf("expected only auction trades recorded, got %d", len(ob.Trades))
+	}
+
+	// 競價後轉為連續競價
+	trades, err := ob.PlaceOrder(&Order{ID: "b3", Side: Bid, Type: Limit, Price: dec(103), Quantity: dec(1)})
+	if err != nil || len(trades) != 1 || trades[0].Price != dec(103) {
+		t.Errorf("expected continuous matching after auction, got %v %v", trades, err)
+	}
+}
+
+func TestPreviewLimitOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(0.5)})
+	ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a4", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(2)})
+
+	o := &Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(3)}
+	seq := ob.Sequence()
+	fills, resting, avg := ob.PreviewLimitOrder(o)
+	if ob.Sequence() != seq || ob.AskLevels[dec(100)].Quantity != dec(1) {
+		t.Fatalf("expected preview not to mutate the book")
+	}
+	if resting != dec(0.5) || math.Abs(avg-(100+101*1.5)/2.5) > 1e-9 {
+		t.Errorf("unexpected preview resting %v avg %v", resting, avg)
+	}
+
+	trades, err := ob.PlaceOrder(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fills) != len(trades) {
+		t.Fatalf("expected %d previewed fills, got %d", len(trades), len(fills))
+	}
+	for i, tr := range trades {
+		f := fills[i]
+		if f.SellOrderId != tr.SellOrderId || f.BuyOrderId != tr.BuyOrderId || f.Price != tr.Price || f.Quantity != tr.Quantity {
+			t.Errorf("fill %d: preview %+v does not match trade %+v", i, f, *tr)
+		}
+	}
+	if rest := ob.BidLevels[dec(101)]; rest == nil || rest.Quantity != resting {
+		t.Errorf("expected %v resting at 101 after placement", resting)
+	}
+}
+
+// 測試預覽套用與撮合相同的條件：IOC／FOK 剩餘不掛單、只掛單會吃單時不成交、自成交防範及手數取整
+func TestPreviewLimitOrderMatchingRules(t *testing.T) {
+	setup := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.PlaceOrder(&Order{ID: "own", UserID: "u", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a1", UserID: "x", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a2", UserID: "x", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+		return ob
+	}
+
+	cases := []struct {
+		name      string
+		configure func(ob *OrderBook)
+		order     *Order
+		makers    []string
+		resting   Decimal
+	}{
+		{"IOC", nil, &Order{ID: "b", UserID: "v", Side: Bid, Type: Limit, TimeInForce: IOC, Price: dec(100), Quantity: dec(3)}, []string{"own", "a1"}, 0},
+		{"FOK unfilled", nil, &Order{ID: "b", UserID: "v", Side: Bid, Type: Limit, TimeInForce: FOK, Price: dec(100), Quantity: dec(3)}, nil, 0},
+		{"post only", nil, &Order{ID: "b", UserID: "v", Side: Bid, Type: Limit, PostOnly: true, Price: dec(100), Quantity: dec(1)}, nil, 0},
+		{"STP cancel resting", func(ob *OrderBook) { ob.SelfTradePolicy = STPCancelResting }, &Order{ID: "b", UserID: "u", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)}, []string{"a1"}, dec(1)},
+		{"STP cancel incoming", func(ob *OrderBook) { ob.SelfTradePolicy = STPCancelIncoming }, &Order{ID: "b", UserID: "u", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(2)}, nil, 0},
+		{"round fills to lot", func(ob *OrderBook) { ob.LotSize, ob.RoundFillsToLot = dec(0.5), true }, &Order{ID: "b", UserID: "v", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1.7)}, []string{"own", "a1"}, 0},
+	}
+	for _, tc := range cases {
+		ob := setup()
+		if tc.configure != nil {
+			tc.configure(ob)
+		}
+		fills, resting, _ := ob.PreviewLimitOrder(tc.order)
+		var makers []string
+		for _, f := range fills {
+			makers = append(makers, f.SellOrderId)
+		}
+		if fmt.Sprint(makers) != fmt.Sprint(tc.makers) || resting != tc.resting {
+			t.Errorf("%s: expected fills against %v resting %s, got %v resting %s", tc.name, tc.makers, tc.resting, makers, resting)
+		}
+
+		// 預覽與實際下單的成交及掛單一致
+		trades, _ := ob.PlaceOrder(tc.order)
+		if len(trades) != len(fills) {
+			t.Errorf("%s: expected %d trades as previewed, got %d", tc.name, len(fills), len(trades))
+			continue
+		}
+		for i, tr := range trades {
+			if tr.SellOrderId != fills[i].SellOrderId || tr.Quantity != fills[i].Quantity {
+				t.Errorf("%s: fill %d: preview %+v does not match trade %+v", tc.name, i, fills[i], *tr)
+			}
+		}
+		var rested Decimal
+		if o, ok := ob.UnFilledOrders[tc.order.ID]; ok {
+			rested = o.Remaining()
+		}
+		if rested != resting {
+			t.Errorf("%s: expected %s resting as previewed, got %s", tc.name, resting, rested)
+		}
+	}
+}
+
+// 測試只比對有變更層級的深度增量：快照加上其後的增量等於最終的完整深度
+// （涵蓋成交、冰山單補充、原地及改價修改、撤單、雙邊報價更新及重置）
+func TestDepthDeltaReplaysToSnapshot(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.DepthDeltaLimit = 1000
+	ob.PlaceOrder(&Order{ID: "seed", Side: Bid, Type: Limit, Price: dec(90), Quantity: dec(1)})
+
+	depthOf := func() map[levelKey]Decimal {
+		depth := make(map[levelKey]Decimal)
+		bids, asks, _ := ob.DepthSnapshot(0)
+		for side, levels := range map[OrderSide][]DepthLevel{Bid: bids, Ask: asks} {
+			for _, l := range levels {
+				depth[levelKey{side: side, price: l.Price}] = l.Quantity
+			}
+		}
+		return depth
+	}
+	snapshot, since := depthOf(), ob.Sequence()
+	checkReplay := func(stage string) {
+		depth := make(map[levelKey]Decimal)
+		for key, qty := range snapshot {
+			depth[key] = qty
+		}
+		changes, _, ok := ob.DepthDelta(since)
+		if !ok {
+			t.Fatalf("%s: expected deltas since the snapshot to be available", stage)
+		}
+		for _, c := range changes {
+			key := levelKey{side: c.Side, price: c.Price}
+			if c.Action == LevelRemoved {
+				delete(depth, key)
+			} else {
+				depth[key] = c.Quantity
+			}
+		}
+		if want := depthOf(); fmt.Sprint(depth) != fmt.Sprint(want) {
+			t.Errorf("%s: expected replayed depth %v, got %v", stage, want, depth)
+		}
+	}
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "ice", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(3), DisplayQuantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "m1", Side: Bid, Type: Market, Quantity: dec(1.5)})
+	ob.AmendOrder("b1", dec(99), dec(1))
+	ob.AmendOrder("a1", dec(102), dec(2))
+	ob.UpdateQuote("mm", dec(98), dec(1), dec(103), dec(1))
+	ob.UpdateQuote("mm", dec(97), dec(2), dec(103), dec(2))
+	ob.CancelOrder("seed")
+	ob.PlaceOrder(&Order{ID: "m2", Side: Ask, Type: Market, Quantity: dec(0.5)})
+	checkReplay("after trading")
+
+	ob.Clear()
+	ob.PlaceOrder(&Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(95), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(96), Quantity: dec(0.5)})
+	checkReplay("after clear")
+}
+
+type memoryTradeArchive struct {
+	trades []Trade
+}
+
+func (a *memoryTradeArchive) Archive(symbol Symbol, trades []Trade) error {
+	a.trades = append(a.trades, trades...)
+	return nil
+}
+
+// 測試 Clear 重置前將成交紀錄歸檔，之後訂單簿為空
+func TestClearArchivesTrades(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	archive := &memoryTradeArchive{}
+	ob.TradeArchive = archive
+
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Market, Quantity: dec(1.5)})
+	resting := &Order{ID: "b2", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)}
+	ob.PlaceOrder(resting)
+	prior := append([]*Trade(nil), ob.Trades...)
+
+	if err := ob.Clear(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(archive.trades) != len(prior) || len(prior) != 2 {
+		t.Fatalf("expected %d archived trades, got %d", len(prior), len(archive.trades))
+	}
+	for i := range prior {
+		if archive.trades[i].ID != prior[i].ID {
+			t.Errorf("archived trade %d: expected %s, got %s", i, prior[i].ID, archive.trades[i].ID)
+		}
+	}
+	if len(ob.Trades) != 0 || len(ob.UnFilledOrders) != 0 {
+		t.Errorf("expected empty book after Clear, got %d trades %d orders", len(ob.Trades), len(ob.UnFilledOrders))
+	}
+	if bids, asks := ob.GetDepth(10); len(bids) != 0 || len(asks) != 0 {
+		t.Errorf("expected empty depth, got %v %v", bids, asks)
+	}
+	if resting.Status != Cancelled || resting.CancelReason != BookCleared {
+		t.Errorf("expected resting order cancelled by Clear, got %s/%s",
+			GetStatusName(resting.Status), GetCancelReasonName(resting.CancelReason))
+	}
+}
+
+// 測試 Clear 取消已觸發待撮合的停損單並重置衍生狀態
+func TestClearResetsDerivedState(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "b1", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "peg", Side: Bid, Type: Limit, Peg: PegPrimary, Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "m1", Side: Bid, Type: Market, Quantity: dec(0.5)})
+	ob.SetOpeningSnapshot()
+	ob.PlaceOrder(&Order{ID: "m2", Side: Bid, Type: Market, Quantity: dec(0.1)})
+
+	// 暫停期間觸發的停損單留在待撮合佇列
+	triggered := &Order{ID: "stop", Side: Bid, Type: StopLimit, StopPrice: dec(100), Price: dec(101), Quantity: dec(1)}
+	ob.PlaceOrder(triggered)
+	ob.Halt("test")
+	ob.checkStops(dec(100))
+	sink := &memoryEventSink{}
+	ob.EventSink = sink
+
+	if err := ob.Clear(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if triggered.Status != Cancelled || triggered.CancelReason != BookCleared {
+		t.Errorf("expected triggered stop cancelled by Clear, got %s/%s",
+			GetStatusName(triggered.Status), GetCancelReasonName(triggered.CancelReason))
+	}
+	cancelled := false
+	for _, e := range sink.events {
+		if e.Type == EventOrderCancel && e.Order != nil && e.Order.ID == "stop" {
+			cancelled = true
+		}
+	}
+	if !cancelled {
+		t.Error("expected cancel event for triggered stop")
+	}
+	if len(ob.PendingStopOrders()) != 0 || len(ob.submissionMid) != 0 || len(ob.pegged) != 0 {
+		t.Errorf("expected derived state reset, got %d stops %d mids %d pegged",
+			len(ob.PendingStopOrders()), len(ob.submissionMid), len(ob.pegged))
+	}
+	if _, ok := ob.OpeningDepth(); ok {
+		t.Error("expected opening snapshot reset")
+	}
+	if base, quote := ob.VolumeSinceOpen(); base != 0 || quote != 0 {
+		t.Errorf("expected no volume since open, got %s/%s", base, quote)
+	}
+}
+
+// 測試 IOC 限價單：與部分流動性成交後剩餘部分取消，不掛單
+func TestIOCLimitOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(1)})
+
+	ioc := &Order{ID: "ioc", Side: Bid, Type: Limit, TimeInForce: IOC, Price: dec(101), Quantity: dec(3)}
+	trades, err := ob.PlaceOrder(ioc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 1 || trades[0].Quantity != dec(1) || trades[0].Price != dec(100) {
+		t.Fatalf("expected 1 trade of 1 @100, got %v", trades)
+	}
+	if ioc.Status != Cancelled || ioc.CancelReason != IOCRemainder || ioc.FilledQuantity != dec(1) {
+		t.Errorf("expected IOC remainder cancelled after filling 1, got %s/%s filled %v",
+			GetStatusName(ioc.Status), GetCancelReasonName(ioc.CancelReason), ioc.FilledQuantity)
+	}
+	if _, ok := ob.UnFilledOrders["ioc"]; ok {
+		t.Error("expected IOC remainder not to rest")
+	}
+	if bids, _ := ob.GetDepth(10); len(bids) != 0 {
+		t.Errorf("expected no bids, got %v", bids)
+	}
+}
+
+// 測試 FOK：可接受價格內的對手盤剛好足夠時全部成交，差一單位時整筆取消且訂單簿不變
+func TestFOKOrder(t *testing.T) {
+	setup := func() *OrderBook {
+		ob := NewOrderBook("BTCUSDT")
+		ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(2)})
+		ob.PlaceOrder(&Order{ID: "a3", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(5)})
+		return ob
+	}
+
+	// 剛好足夠：100 與 101 共 3
+	ob := setup()
+	fok := &Order{ID: "fok", Side: Bid, Type: Limit, TimeInForce: FOK, Price: dec(101), Quantity: dec(3)}
+	trades, err := ob.PlaceOrder(fok)
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("expected full fill in 2 trades, got %v, %v", trades, err)
+	}
+	if fok.Status != Filled || fok.FilledQuantity != dec(3) {
+		t.Errorf("expected FOK filled, got %s filled %v", GetStatusName(fok.Status), fok.FilledQuantity)
+	}
+
+	// 差一單位：101 以內只有 3，要求 4
+	ob = setup()
+	short := &Order{ID: "short", Side: Bid, Type: Limit, TimeInForce: FOK, Price: dec(101), Quantity: dec(4)}
+	trades, err = ob.PlaceOrder(short)
+	if err != nil || len(trades) != 0 {
+		t.Fatalf("expected no trades, got %v, %v", trades, err)
+	}
+	if short.Status != Cancelled || short.CancelReason != FOKUnfilled || short.FilledQuantity != 0 {
+		t.Errorf("expected FOK killed, got %s/%s filled %v",
+			GetStatusName(short.Status), GetCancelReasonName(short.CancelReason), short.FilledQuantity)
+	}
+	if _, ok := ob.UnFilledOrders["short"]; ok {
+		t.Error("expected killed FOK not to rest")
+	}
+	_, asks := ob.GetDepth(10)
+	if len(asks) != 3 || asks[0].Quantity != dec(1) || asks[1].Quantity != dec(2) || asks[2].Quantity != dec(5) {
+		t.Errorf("expected book untouched, got %v", asks)
+	}
+
+	// 市價 FOK 同樣檢查整邊流動性
+	ob = setup()
+	market := &Order{ID: "mkt", Side: Bid, Type: Market, TimeInForce: FOK, Quantity: dec(9)}
+	if trades, _ := ob.PlaceOrder(market); len(trades) != 0 || market.CancelReason != FOKUnfilled {
+		t.Errorf("expected market FOK killed, got %v %s", trades, GetCancelReasonName(market.CancelReason))
+	}
+	market = &Order{ID: "mkt2", Side: Bid, Type: Market, TimeInForce: FOK, Quantity: dec(8)}
+	if trades, _ := ob.PlaceOrder(market); len(trades) != 3 || market.Status != Filled {
+		t.Errorf("expected market FOK filled, got %v %s", trades, GetStatusName(market.Status))
+	}
+}
+
+// 測試 FOK 模擬撮合時的各項限制：任何一項使撮合提前停止時整筆取消，不產生部分成交
+func TestFOKRespectsMatchingConstraints(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(ob *OrderBook)
+		fok   Order
+	}{
+		{"reference", func(ob *OrderBook) {}, Order{ReferencePrice: dec(100), ReferenceToleranceBps: 50}},
+		{"band", func(ob *OrderBook) {
+			ob.TradeBandPct, ob.HaltOnBandBreach = 0.5, true
+			ob.PlaceOrder(&Order{ID: "seed", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.5)})
+			ob.PlaceOrder(&Order{ID: "refill", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.5)})
+		}, Order{}},
+		{"self-trade", func(ob *OrderBook) {
+			ob.SelfTradePolicy = STPCancelIncoming
+			ob.AskLevels[dec(102)].Front().UserID = "alice"
+		}, Order{UserID: "alice"}},
+		{"min trade", func(ob *OrderBook) {
+			ob.MinTradeSize = dec(1)
+			ob.PlaceOrder(&Order{ID: "dust", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(0.2)})
+		}, Order{}},
+		{"lot", func(ob *OrderBook) {
+			ob.LotSize, ob.RoundFillsToLot = dec(1), true
+			ob.PlaceOrder(&Order{ID: "odd", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(0.5)})
+		}, Order{Quantity: dec(2.5)}},
+	} {
+		ob := NewOrderBook("BTCUSDT")
+		ob.PlaceOrder(&Order{ID: "a1", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		ob.PlaceOrder(&Order{ID: "a2", Side: Ask, Type: Limit, Price: dec(102), Quantity: dec(5)})
+		tc.setup(ob)
+
+		fok := tc.fok
+		fok.ID, fok.Side, fok.Type, fok.TimeInForce, fok.Price = "fok", Bid, Limit, FOK, dec(103)
+		if fok.Quantity == 0 {
+			fok.Quantity = dec(2)
+		}
+		trades, err := ob.PlaceOrder(&fok)
+		if err != nil || len(trades) != 0 || fok.FilledQuantity != 0 {
+			t.Errorf("%s: expected FOK killed without fills, got %v filled %s (%v)", tc.name, trades, fok.FilledQuantity, err)
+		}
+		if fok.Status != Cancelled || fok.CancelReason != FOKUnfilled {
+			t.Errorf("%s: expected FOKUnfilled, got %s/%s", tc.name, GetStatusName(fok.Status), GetCancelReasonName(fok.CancelReason))
+		}
+	}
+
+	// 被取消的自成交掛單及碎量掛單不影響可全部成交的 FOK
+	ob := NewOrderBook("BTCUSDT")
+	ob.SelfTradePolicy = STPCancelResting
+	ob.PlaceOrder(&Order{ID: "own", UserID: "alice", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ice", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(3), DisplayQuantity: dec(1)})
+	fok := &Order{ID: "fok", UserID: "alice", Side: Bid, Type: Limit, TimeInForce: FOK, Price: dec(101), Quantity: dec(3)}
+	if trades, err := ob.PlaceOrder(fok); err != nil || fok.Status != Filled || len(trades) != 3 {
+		t.Errorf("expected FOK filled through iceberg refills, got %v %s (%v)", trades, GetStatusName(fok.Status), err)
+	}
+}
+
+// 測試成交數量按手數取整：不足一手的部分留在訂單上，撮合迴圈不會卡在碎量上
+func TestRoundFillsToLot(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.LotSize = dec(0.1)
+	ob.RoundFillsToLot = true
+
+	maker := &Order{ID: "maker", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.25)}
+	ob.PlaceOrder(maker)
+	taker := &Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(0.37)}
+	trades, err := ob.PlaceOrder(taker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 1 || trades[0].Quantity != dec(0.2) {
+		t.Fatalf("expected a single lot-aligned fill of 0.2, got %v", trades)
+	}
+	if maker.Remaining() != dec(0.05) || taker.Remaining() != dec(0.17) {
+		t.Errorf("expected sub-lot remainders 0.05/0.17, got %v/%v", maker.Remaining(), taker.Remaining())
+	}
+	// 剩餘部分與不足一手的掛單無法成交，掛單會交叉，因此取消
+	if taker.Status != Cancelled || taker.CancelReason != CrossingRemainder {
+		t.Errorf("expected taker remainder cancelled as crossing, got %s/%s",
+			GetStatusName(taker.Status), GetCancelReasonName(taker.CancelReason))
+	}
+	if bid, ask, _ := ob.GetBestBidAsk(); bid >= ask {
+		t.Errorf("expected uncrossed book, got bid %s ask %s", bid, ask)
+	}
+
+	// 兩邊剩餘都不足以成交一手：新進場的市價單直接結束，不產生成交
+	done := make(chan []*Trade, 1)
+	go func() {
+		trades, _ := ob.PlaceOrder(&Order{ID: "mkt", Side: Bid, Type: Market, Quantity: dec(0.04)})
+		done <- trades
+	}()
+	select {
+	case trades := <-done:
+		if len(trades) != 0 {
+			t.Errorf("expected no sub-lot trade, got %v", trades)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("matching loop did not terminate on sub-lot remainder")
+	}
+	for _, tr := range ob.Trades {
+		if tr.Quantity%ob.LotSize != 0 {
+			t.Errorf("trade %s quantity %v not lot-aligned", tr.ID, tr.Quantity)
+		}
+	}
+}
+
+// 測試 DustPolicy 為 DustCancel 時，按手數取整後無法成交的掛單被取消，進場訂單繼續與下一筆撮合
+func TestRoundFillsToLotDropsDustMaker(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.LotSize = dec(0.1)
+	ob.RoundFillsToLot = true
+
+	// 不足一手的掛單（例如 DustKeep 期間留下的碎量）
+	small := &Order{ID: "small", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(0.05)}
+	ob.PlaceOrder(small)
+	ob.PlaceOrder(&Order{ID: "next", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.DustPolicy = DustCancel
+
+	taker := &Order{ID: "taker", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(0.3)}
+	trades, err := ob.PlaceOrder(taker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if small.Status != Cancelled || small.CancelReason != DustRemainder {
+		t.Errorf("expected sub-lot maker cancelled as dust, got %s/%s",
+			GetStatusName(small.Status), GetCancelReasonName(small.CancelReason))
+	}
+	if len(trades) != 1 || trades[0].SellOrderId != "next" || trades[0].Quantity != dec(0.3) {
+		t.Errorf("expected taker filled against next maker, got %v", trades)
+	}
+}
+
+// 回歸測試：市價賣單撮合時進場訂單是賣方，掛單買方的成交量與成交紀錄方向正確
+func TestMarketSellTradeSides(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	bid := &Order{ID: "bid", UserID: "maker", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(2)}
+	ob.PlaceOrder(bid)
+	sell := &Order{ID: "sell", UserID: "taker", Side: Ask, Type: Market, Quantity: dec(1)}
+	trades, err := ob.PlaceOrder(sell)
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("expected 1 trade, got %v, %v", trades, err)
+	}
+
+	tr := trades[0]
+	if tr.SellOrderId != "sell" || tr.BuyOrderId != "bid" {
+		t.Errorf("expected sell=%s buy=%s, got sell=%s buy=%s", "sell", "bid", tr.SellOrderId, tr.BuyOrderId)
+	}
+	if tr.SellUserID != "taker" || tr.BuyUserID != "maker" || tr.AggressorSide != Ask {
+		t.Errorf("unexpected trade sides: %+v", tr)
+	}
+	if sell.Status != Filled || bid.Status != Partial || bid.Remaining() != dec(1) {
+		t.Errorf("expected taker filled and bid partial, got %s/%s remaining %v",
+			GetStatusName(sell.Status), GetStatusName(bid.Status), bid.Remaining())
+	}
+	if bids, _ := ob.GetDepth(10); len(bids) != 1 || bids[0].Quantity != dec(1) {
+		t.Errorf("expected bid level with 1 remaining, got %v", bids)
+	}
+}
+
+// 測試只做 maker 的訂單：會與對手盤成交（含價格相同）時拒絕且不改變訂單簿，否則照常掛單
+func TestPostOnlyOrder(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+
+	for _, price := range []Decimal{dec(101), dec(100)} {
+		o := &Order{ID: fmt.Sprintf("po%s", price), Side: Bid, Type: Limit, Price: price, Quantity: dec(1), PostOnly: true}
+		trades, err := ob.PlaceOrder(o)
+		if !errors.Is(err, ErrPostOnlyWouldTake) || len(trades) != 0 {
+			t.Errorf("price %v: expected post-only rejection, got %v, %v", price, trades, err)
+		}
+		if o.Status != Cancelled || o.FilledQuantity != 0 {
+			t.Errorf("price %v: expected cancelled unfilled, got %s", price, GetStatusName(o.Status))
+		}
+	}
+	if _, asks := ob.GetDepth(10); len(asks) != 1 || asks[0].Quantity != dec(1) || len(ob.UnFilledOrders) != 1 {
+		t.Errorf("expected book untouched, got asks %v", asks)
+	}
+
+	// 不會成交時照常掛單
+	rest := &Order{ID: "rest", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1), PostOnly: true}
+	if trades, err := ob.PlaceOrder(rest); err != nil || len(trades) != 0 {
+		t.Fatalf("expected post-only order to rest, got %v, %v", trades, err)
+	}
+	if _, ok := ob.UnFilledOrders["rest"]; !ok || rest.Status != Pending {
+		t.Errorf("expected resting post-only order, got %s", GetStatusName(rest.Status))
+	}
+
+	// 價格相同但不允許以相同價格撮合時不算吃單
+	ob.CrossOnEqual = false
+	equal := &Order{ID: "equal", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1), PostOnly: true}
+	if _, err := ob.PlaceOrder(equal); err != nil || equal.Status != Pending {
+		t.Errorf("expected equal-price post-only order to rest without CrossOnEqual, got %v %s", err, GetStatusName(equal.Status))
+	}
+}
+
+// 測試 maker 價差收益：雙邊報價被吃時按當時中間價計算，吃單方不計入，超出窗口的成交不計入
+func TestRealizedSpreadCapture(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.PlaceOrder(&Order{ID: "other_bid", UserID: "other", Side: Bid, Type: Limit, Price: dec(98), Quantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "other_ask", UserID: "other", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "mm_bid", UserID: "mm", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "mm_ask", UserID: "mm", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+
+	// 中間價 100 時賣給 mm 的買單：mm 賺 1
+	ob.PlaceOrder(&Order{ID: "sell", UserID: "taker", Side: Ask, Type: Market, Quantity: dec(1)})
+	// 中間價 (98+101)/2 = 99.5 時向 mm 買入：mm 賺 1.5
+	ob.PlaceOrder(&Order{ID: "buy", UserID: "taker", Side: Bid, Type: Market, Quantity: dec(1)})
+
+	if got := ob.RealizedSpreadCapture("mm", time.Hour); got != dec(2.5) {
+		t.Errorf("expected maker capture 2.5, got %v", got)
+	}
+	if got := ob.RealizedSpreadCapture("taker", time.Hour); got != 0 {
+		t.Errorf("expected no capture for taker, got %v", got)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if got := ob.RealizedSpreadCapture("mm", time.Hour); got != 0 {
+		t.Errorf("expected fills outside window excluded, got %v", got)
+	}
+}
+
+// 測試一筆吃單掃過多個層級時，每筆成交的中間價取該筆成交前的訂單簿
+func TestFillMidPerFill(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "bid", UserID: "other", Side: Bid, Type: Limit, Price: dec(99), Quantity: dec(5)})
+	ob.PlaceOrder(&Order{ID: "ask1", UserID: "mm", Side: Ask, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask2", UserID: "mm", Side: Ask, Type: Limit, Price: dec(103), Quantity: dec(1)})
+
+	trades, err := ob.PlaceOrder(&Order{ID: "buy", UserID: "taker", Side: Bid, Type: Market, Quantity: dec(2)})
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("expected 2 fills, got %v %v", trades, err)
+	}
+	if trades[0].Mid != dec(100) || trades[1].Mid != dec(101) {
+		t.Errorf("expected mids 100 then 101, got %v and %v", trades[0].Mid, trades[1].Mid)
+	}
+	// 第一筆以 100 計 1，第二筆以 101 計 2
+	if got := ob.RealizedSpreadCapture("mm", time.Hour); got != dec(3) {
+		t.Errorf("expected maker capture 3, got %v", got)
+	}
+}
+
+// 測試結算價擷取：VWAP 只計入結算時間前 SettlementWindow 內的成交，中間價方式取當時最佳買賣價
+func TestSettlementPrice(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := NewManualClock(start)
+	ob := NewOrderBook("BTCUSDT")
+	ob.Clock = clock
+	ob.SettlementWindow = 5 * time.Minute
+
+	if _, ok := ob.SettlementPrice(); ok {
+		t.Fatal("expected no settlement before capture")
+	}
+
+	trade := func(id string, price, qty Decimal) {
+		ob.PlaceOrder(&Order{ID: id + "_ask", Side: Ask, Type: Limit, Price: price, Quantity: qty})
+		ob.PlaceOrder(&Order{ID: id + "_bid", Side: Bid, Type: Limit, Price: price, Quantity: qty})
+	}
+	trade("old", dec(90), dec(10)) // 窗口外
+	clock.Advance(10 * time.Minute)
+	trade("t1", dec(100), dec(1))
+	clock.Advance(time.Minute)
+	trade("t2", dec(103), dec(2))
+	clock.Advance(time.Minute)
+
+	s, err := ob.SetSettlement(clock.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := dec(306).Div(dec(3))
+	if s.Price != want || s.Method != SettleVWAP || !s.CapturedAt.Equal(clock.Now()) {
+		t.Errorf("expected VWAP settlement %v at %v, got %+v", want, clock.Now(), s)
+	}
+	if got, ok := ob.SettlementPrice(); !ok || got != s {
+		t.Errorf("expected SettlementPrice to return captured value, got %+v", got)
+	}
+
+	// 中間價方式
+	ob.SettlementMethod = SettleMid
+	ob.PlaceOrder(&Order{ID: "bid", Side: Bid, Type: Limit, Price: dec(101), Quantity: dec(1)})
+	ob.PlaceOrder(&Order{ID: "ask", Side: Ask, Type: Limit, Price: dec(105), Quantity: dec(1)})
+	if s, err := ob.SetSettlement(clock.Now()); err != nil || s.Price != dec(103) {
+		t.Errorf("expected mid settlement 103, got %+v, %v", s, err)
+	}
+
+	// 窗口內沒有成交時無法以 VWAP 結算
+	ob.SettlementMethod = SettleVWAP
+	if _, err := ob.SetSettlement(clock.Now().Add(time.Hour)); !errors.Is(err, ErrNoSettlementPrice) {
+		t.Errorf("expected ErrNoSettlementPrice, got %v", err)
+	}
+}
+
+// 測試自成交防範的三種策略，以及取消後層級與堆的一致性
+func TestSelfTradePrevention(t *testing.T) {
+	setup := func(policy SelfTradePolicy) (*OrderBook, *Order) {
+		ob := NewOrderBook("BTCUSDT")
+		ob.SelfTradePolicy = policy
+		own := &Order{ID: "own", UserID: "alice", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)}
+		ob.PlaceOrder(own)
+		ob.PlaceOrder(&Order{ID: "other", UserID: "bob", Side: Ask, Type: Limit, Price: dec(100), Quantity: dec(1)})
+		return ob, own
+	}
+	checkBook := func(policy SelfTradePolicy, ob *OrderBook) {
+		t.Helper()
+		if issues := ob.CheckIntegrity(); len(issues) != 0 {
+			t.Errorf("policy %d: inconsistent book after self-trade prevention: %v", policy, issues)
+		}
+	}
+
+	// 取消掛單：進場訂單繼續與同層級下一筆成交
+	ob, own := setup(STPCancelResting)
+	taker := &Order{ID: "taker", UserID: "alice", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	trades, err := ob.PlaceOrder(taker)
+	if err != nil || len(trades) != 1 || trades[0].SellOrderId != "other" {
+		t.Fatalf("STPCancelResting: expected fill against next order, got %v, %v", trades, err)
+	}
+	if own.Status != Cancelled || own.CancelReason != SelfTradePrevented || taker.Status != Filled {
+		t.Errorf("STPCancelResting: expected resting cancelled and taker filled, got %s/%s",
+			GetStatusName(own.Status), GetStatusName(taker.Status))
+	}
+	checkBook(STPCancelResting, ob)
+
+	// 取消進場訂單：掛單保留，進場訂單不掛單
+	ob, own = setup(STPCancelIncoming)
+	sink := &memoryEventSink{}
+	ob.EventSink = sink
+	ob.RejectionLogEnabled = true
+	taker = &Order{ID: "taker", UserID: "alice", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}
+	if trades, _ := ob.PlaceOrder(taker); len(trades) != 0 {
+		t.Errorf("STPCancelIncoming: expected no trades, got %v", trades)
+	}
+	cancelled := false
+	for _, ev := range sink.events {
+		if ev.Type == EventOrderCancel && ev.Order.ID == "taker" && ev.CancelledQuantity == dec(1) {
+			cancelled = true
+		}
+	}
+	if !cancelled {
+		t.Errorf("STPCancelIncoming: expected a cancel event for the taker, got %v", sink.events)
+	}
+	if rejections := ob.RecentRejections(1); len(rejections) != 1 || !errors.Is(rejections[0].Err, ErrSelfTradePrevented) {
+		t.Errorf("STPCancelIncoming: expected a self-trade rejection record, got %v", rejections)
+	}
+	if taker.Status != Cancelled || taker.CancelReason != SelfTradePrevented {
+		t.Errorf("STPCancelIncoming: expected taker cancelled, got %s", GetStatusName(taker.Status))
+	}
+	if _, ok := ob.UnFilledOrders["taker"]; ok || own.Status != Pending {
+		t.Errorf("STPCancelIncoming: expected taker not resting and own order kept")
+	}
+	checkBook(STPCancelIncoming, ob)
+
+	// 兩筆都取消
+	ob, own = setup(STPCancelBoth)
+	taker = &Order{ID: "taker", UserID: "alice", Side: Bid, Type: Market, Quantity: dec(1)}
+	if trades, _ := ob.PlaceOrder(taker); len(trades) != 0 {
+		t.Errorf("STPCancelBoth: expected no trades, got %v", trades)
+	}
+	if own.CancelReason != SelfTradePrevented || taker.CancelReason != SelfTradePrevented {
+		t.Errorf("STPCancelBoth: expected both cancelled, got %s/%s",
+			GetCancelReasonName(own.CancelReason), GetCancelReasonName(taker.CancelReason))
+	}
+	if _, asks := ob.GetDepth(10); len(asks) != 1 || asks[0].Quantity != dec(1) || asks[0].Orders != 1 {
+		t.Errorf("STPCancelBoth: expected only other order left at 100, got %v", asks)
+	}
+	checkBook(STPCancelBoth, ob)
+
+	// 預設允許自成交
+	ob, _ = setup(STPNone)
+	if trades, _ := ob.PlaceOrder(&Order{ID: "taker", UserID: "alice", Side: Bid, Type: Limit, Price: dec(100), Quantity: dec(1)}); len(trades) != 1 || trades[0].SellOrderId != "own" {
+		t.Errorf("STPNone: expected self-trade allowed, got %v", trades)
+	}
+}
+
+// 測試定點十進位數的解析、格式化、JSON 及運算
+func TestDecimal(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"50150.25", "50150.25"},
+		{"-0.001", "-0.001"},
+		{"+7", "7"},
+		{".5", "0.5"},
+		{"0.10000000", "0.1"},
+		{"92233720368.54775807", "92233720368.54775807"},
+		{"1.000000000", "1"},
+		{"1e-3", "0.001"},
+		{"1.5E2", "150"},
+		{"-1e+2", "-100"},
+		{"2.0e-8", "0.00000002"},
+		{"123.456e-1", "12.3456"},
+	} {
+		d, err := ParseDecimal(tc.in)
+		if err != nil || d.String() != tc.want {
+			t.Errorf("ParseDecimal(%q): expected %s, got %s (%v)", tc.in, tc.want, d, err)
+		}
+	}
+	for _, in := range []string{"", ".", "1.2.3", "abc", "0.123456789", "1e", "e5", "1e-9", "1e-999", "Inf", "NaN"} {
+		if _, err := ParseDecimal(in); !errors.Is(err, ErrInvalidDecimal) {
+			t.Errorf("ParseDecimal(%q): expected ErrInvalidDecimal, got %v", in, err)
+		}
+	}
+	for _, in := range []string{"92233720368.54775808", "1e11", "1e300", "-1e300"} {
+		if _, err := ParseDecimal(in); !errors.Is(err, ErrDecimalOverflow) {
+			t.Errorf("ParseDecimal(%q): expected ErrDecimalOverflow, got %v", in, err)
+		}
+	}
+
+	// float64 轉換拒絕非有限值及超出範圍的值；NewDecimal 則飽和
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := CheckedNewDecimal(f); !errors.Is(err, ErrInvalidDecimal) {
+			t.Errorf("CheckedNewDecimal(%v): expected ErrInvalidDecimal, got %v", f, err)
+		}
+	}
+	if _, err := CheckedNewDecimal(1e300); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("CheckedNewDecimal(1e300): expected ErrDecimalOverflow, got %v", err)
+	}
+	if got := NewDecimal(-1e300); got != math.MinInt64 {
+		t.Errorf("expected NewDecimal(-1e300) to saturate, got %s", got)
+	}
+	if got := NewDecimal(math.NaN()); got != 0 {
+		t.Errorf("expected NewDecimal(NaN) = 0, got %s", got)
+	}
+
+	// 浮點數下 0.1+0.2 != 0.3，定點數則精確相等，可作為價格層級的鍵
+	if dec(0.1)+dec(0.2) != dec(0.3) {
+		t.Errorf("expected 0.1+0.2 == 0.3, got %s", dec(0.1)+dec(0.2))
+	}
+	ob := NewOrderBook("BTCUSDT")
+	ob.PlaceOrder(&Order{ID: "a", Side: Ask, Type: Limit, Price: dec(0.1) + dec(0.2), Quantity: dec(1)})
+	if ob.AskLevels[dec(0.3)] == nil {
+		t.Errorf("expected level at 0.3 to be found by exact key")
+	}
+
+	if got := dec(1.5).Mul(dec(2.25)); got != dec(3.375) {
+		t.Errorf("expected 1.5*2.25 = 3.375, got %s", got)
+	}
+	if got := dec(1).Div(dec(3)); got != dec(0.33333333) {
+		t.Errorf("expected 1/3 truncated to 0.33333333, got %s", got)
+	}
+	if _, err := dec(5e10).CheckedMul(dec(1000)); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("expected overflow for 5e10*1000, got %v", err)
+	}
+	if got := dec(5e10).Mul(dec(1000)); got != math.MaxInt64 {
+		t.Errorf("expected overflowing Mul to saturate, got %s", got)
+	}
+	if _, err := dec(1).CheckedDiv(0); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+	if got := dec(-1000).Div(Decimal(1)); got != math.MinInt64 {
+		t.Errorf("expected overflowing Div to saturate, got %s", got)
+	}
+	if _, err := Decimal(math.MaxInt64).CheckedAdd(1); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("expected overflow for MaxInt64+1, got %v", err)
+	}
+	if got := dec(-0.37).FloorTo(dec(0.1)); got != dec(-0.4) {
+		t.Errorf("expected floor(-0.37, 0.1) = -0.4, got %s", got)
+	}
+	if got := dec(100.01).CeilTo(dec(0.5)); got != dec(100.5) {
+		t.Errorf("expected ceil(100.01, 0.5) = 100.5, got %s", got)
+	}
+
+	var v struct{ A, B, C Decimal }
+	if err := json.Unmarshal([]byte(`{"A":0.3,"B":"12.5","C":1e-3}`), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.A != dec(0.3) || v.B != dec(12.5) || v.C != dec(0.001) {
+		t.Errorf("unexpected decoded values %+v", v)
+	}
+	if data, _ := json.Marshal(v); string(data) != `{"A":0.3,"B":12.5,"C":0.001}` {
+		t.Errorf("unexpected JSON %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"A":"x"}`), &v); err == nil {
+		t.Errorf("expected error for non-numeric decimal")
+	}
+	for _, in := range []string{`{"A":1e300}`, `{"A":"Inf"}`, `{"A":"NaN"}`, `{"A":1e-9}`} {
+		if err := json.Unmarshal([]byte(in), &v); err == nil {
+			t.Errorf("expected error decoding %s", in)
+		}
+	}
+}
+
+// 價格乘以數量溢位的限價單在驗證時被拒絕，報價幣目標市價單遇到極小價格也不會 panic
+func TestNotionalOverflow(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	big := &Order{ID: "big", Side: Bid, Type: Limit, Price: dec(5e10), Quantity: dec(1000)}
+	if _, err := ob.PlaceOrder(big); !errors.Is(err, ErrNotionalOverflow) {
+		t.Fatalf("expected ErrNotionalOverflow, got %v", err)
+	}
+	if big.Status != Cancelled || big.FilledQuantity != 0 {
+		t.Errorf("expected rejected order untouched, got %v", big)
+	}
+
+	ob.PlaceOrder(&Order{ID: "tiny", Side: Ask, Type: Limit, Price: Decimal(1), Quantity: dec(5)})
+	buy := &Order{ID: "buy", Side: Bid, Type: Market, Quantity: dec(10), QuoteTarget: dec(1000)}
+	trades, err := ob.PlaceOrder(buy)
+	if err != nil || len(trades) != 1 || trades[0].Quantity != dec(5) {
+		t.Fatalf("expected tiny ask fully taken, got %v (%v)", trades, err)
+	}
 }
@@ -35,7 +35,77 @@ func GetTypeName(orderType OrderType) string {
 		return "限價單"
 	case Market:
 		return "市價單"
+	case StopLimit:
+		return "停損限價單"
 	default:
 		return "未知類型"
 	}
 }
+
+// 輔助函數 - 獲取有效期限名稱
+func GetTimeInForceName(tif TimeInForce) string {
+	switch tif {
+	case GTC:
+		return "GTC"
+	case IOC:
+		return "IOC"
+	case FOK:
+		return "FOK"
+	default:
+		return "未知期限"
+	}
+}
+
+// 輔助函數 - 獲取取消原因名稱
+func GetCancelReasonName(reason CancelReason) string {
+	switch reason {
+	case NoCancelReason:
+		return ""
+	case UserRequested:
+		return "用戶取消"
+	case NoLiquidity:
+		return "無流動性"
+	case MarketRemainder:
+		return "市價單剩餘取消"
+	case Rejected:
+		return "訂單被拒絕"
+	case DustRemainder:
+		return "剩餘不足一手"
+	case LevelInsufficient:
+		return "單一價位數量不足"
+	case PriceProtection:
+		return "偏離參考價格"
+	case Expired:
+		return "訂單過期"
+	case SideFlushed:
+		return "緊急清空單邊"
+	case BookCleared:
+		return "訂單簿重置"
+	case IOCRemainder:
+		return "IOC 剩餘取消"
+	case FOKUnfilled:
+		return "FOK 無法全部成交"
+	case SelfTradePrevented:
+		return "自成交防範"
+	case CrossingRemainder:
+		return "剩餘部分會交叉"
+	case AuctionCancelled:
+		return "集合競價取消"
+	default:
+		return "未知原因"
+	}
+}
+
+// 輔助函數 - 獲取訂單簿交易狀態名稱
+func GetBookStateName(state BookState) string {
+	switch state {
+	case BookNormal:
+		return "正常交易"
+	case BookDraining:
+		return "排空中"
+	case BookHalted:
+		return "暫停交易"
+	default:
+		return "未知狀態"
+	}
+}
@@ -0,0 +1,183 @@
+package orderbook
+
+import "sort"
+
+// 掛鉤單的參考價格
+type PegType int
+
+const (
+	PegNone    PegType = iota // 非掛鉤單
+	PegPrimary                // 掛鉤同方向最佳價（買單掛鉤最佳買價、賣單掛鉤最佳賣價）
+	PegMid                    // 掛鉤中間價
+)
+
+// 掛鉤單計算參考價格時的雙邊最佳價（不含掛鉤單本身）
+type pegReference struct {
+	bid, ask     Decimal
+	bidOK, askOK bool
+}
+
+// 某一邊不含掛鉤單的最佳價格，避免掛鉤單追隨自己。最佳層級含非掛鉤單時直接返回堆頂，
+// 否則線性掃描該邊的層級（不排序）（呼叫者需持有鎖）
+func (ob *OrderBook) bestUnpegged(side OrderSide) (Decimal, bool) {
+	ob.pruneStaleTops()
+	top, levels := ob.Bids.Peek(), ob.BidLevels
+	if side == Ask {
+		top, levels = ob.Asks.Peek(), ob.AskLevels
+	}
+	if top == nil {
+		return 0, false
+	}
+	if top.pegged < top.Len() {
+		return top.Price, true
+	}
+
+	var best Decimal
+	found := false
+	for price, level := range levels {
+		if level.isEmpty() || level.pegged >= level.Len() {
+			continue
+		}
+		if !found || (side == Bid && price > best) || (side == Ask && price < best) {
+			best, found = price, true
+		}
+	}
+	return best, found
+}
+
+func (ob *OrderBook) currentPegReference() pegReference {
+	var ref pegReference
+	ref.bid, ref.bidOK = ob.bestUnpegged(Bid)
+	ref.ask, ref.askOK = ob.bestUnpegged(Ask)
+	return ref
+}
+
+// 按參考價格與 PegOffset 計算掛鉤單價格；設定 TickSize 時買單向下、賣單向上取整。
+// 參考價格不存在時 ok 為 false
+func (ob *OrderBook) pegPrice(o *Order, ref pegReference) (price Decimal, ok bool) {
+	switch o.Peg {
+	case PegPrimary:
+		if o.Side == Bid {
+			price, ok = ref.bid, ref.bidOK
+		} else {
+			price, ok = ref.ask, ref.askOK
+		}
+	case PegMid:
+		price, ok = (ref.bid+ref.ask)/2, ref.bidOK && ref.askOK
+	}
+	if !ok {
+		return 0, false
+	}
+	price += o.PegOffset
+	if ob.TickSize > 0 {
+		if o.Side == Bid {
+			price = price.FloorTo(ob.TickSize)
+		} else {
+			price = price.CeilTo(ob.TickSize)
+		}
+	}
+	return price, true
+}
+
+// 下單時以目前參考價格決定掛鉤單的限價（呼叫者需持有鎖）
+func (ob *OrderBook) applyPeg(o *Order) error {
+	if o.Peg == PegNone {
+		return nil
+	}
+	if o.Type != Limit {
+		return ErrInvalidPrice
+	}
+	ref := ob.currentPegReference()
+	price, ok := ob.pegPrice(o, ref)
+	if !ok {
+		return ErrNoPegReference
+	}
+	o.Price = price
+	ob.lastPegRef = ref
+	return nil
+}
+
+// 通過驗證的掛鉤單登記為需要重新掛鉤（呼叫者需持有鎖）
+func (ob *OrderBook) trackPeg(o *Order) {
+	if o.Peg != PegNone {
+		ob.pegged[o.ID] = o
+	}
+}
+
+// 掛鉤單處理後未留在訂單簿中（已成交、取消或被拒絕）時取消登記（呼叫者需持有鎖）
+func (ob *OrderBook) untrackPeg(o *Order) {
+	if o.Peg != PegNone && ob.UnFilledOrders[o.ID] != o {
+		delete(ob.pegged, o.ID)
+	}
+}
+
+// 最佳價格變動時重新掛鉤：AutoRepeg 開啟且距上次重新掛鉤已超過 RepegMinInterval 時，
+// 將價格改變的掛鉤單移到新價格（失去時間優先，新價格可成交時立即撮合）。
+// 間隔未到時保留變動，待下一次觸發時處理。新價格的掛單被拒絕（如排空中）時取消該掛鉤單並發布取消事件；
+// 返回重新掛鉤產生的成交（呼叫者需持有鎖）
+func (ob *OrderBook) repegOrders() []*Trade {
+	if !ob.AutoRepeg || len(ob.pegged) == 0 || ob.AuctionMode || ob.state == BookHalted {
+		return nil
+	}
+	ref := ob.currentPegReference()
+	if ref == ob.lastPegRef {
+		return nil
+	}
+	now := ob.Clock.Now()
+	if ob.RepegMinInterval > 0 && !ob.lastRepeg.IsZero() && now.Sub(ob.lastRepeg) < ob.RepegMinInterval {
+		return nil
+	}
+	ob.lastPegRef, ob.lastRepeg = ref, now
+
+	orders := make([]*Order, 0, len(ob.pegged))
+	for id, o := range ob.pegged {
+		if ob.UnFilledOrders[id] != o {
+			// 已成交或已取消
+			delete(ob.pegged, id)
+			continue
+		}
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].priority < orders[j].priority })
+
+	var trades []*Trade
+	for _, o := range orders {
+		price, ok := ob.pegPrice(o, ref)
+		if !ok || price == o.Price {
+			continue
+		}
+		ob.removeResting(o)
+		o.Price = price
+		filled := o.FilledQuantity
+		repegTrades, err := ob.processLimitOrder(o)
+		trades = append(trades, repegTrades...)
+		ob.untrackPeg(o)
+		ob.forgetSubmissionMid(o)
+		if err != nil {
+			ob.recordRejection(o, err)
+			ob.publishEvent(EventOrderCancel, nil, o)
+			continue
+		}
+		if o.Status == Pending && filled > 0 {
+			o.Status = Partial
+		}
+		ob.publishEvent(EventOrderUpdate, nil, o)
+	}
+	ob.runTriggeredStops()
+	ob.sequence++
+	ob.afterChange()
+	return trades
+}
+
+// 將掛單移出價格層級及未成交訂單，不改變訂單狀態（呼叫者需持有鎖）
+func (ob *OrderBook) removeResting(o *Order) {
+	level := ob.BidLevels[o.Price]
+	if o.Side == Ask {
+		level = ob.AskLevels[o.Price]
+	}
+	delete(ob.UnFilledOrders, o.ID)
+	if level != nil {
+		level.RemoveOrder(o.ID)
+		ob.cleanupPriceLevel(level, o.Side == Bid)
+	}
+}
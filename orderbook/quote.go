@@ -0,0 +1,121 @@
+package orderbook
+
+import "fmt"
+
+// 做市商的雙邊報價
+type quote struct {
+	bidID string
+	askID string
+	count int // 已更新次數，用於生成訂單ID
+}
+
+// UpdateQuote 以一次加鎖原子地撤銷用戶先前的雙邊報價並掛上新的買賣單，返回新報價產生的成交
+// 數量為 0 的一邊不掛單。撤銷舊報價前先以新條件驗證兩邊，任一邊會被拒絕時返回錯誤，舊報價保持不變
+func (ob *OrderBook) UpdateQuote(userID string, bidPrice, bidQty, askPrice, askQty Decimal) ([]*Trade, error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	defer ob.afterChange()
+
+	q, ok := ob.quotes[userID]
+	if !ok {
+		q = &quote{}
+		ob.quotes[userID] = q
+	}
+
+	legs := make([]*Order, 0, 2)
+	if bidQty > 0 {
+		legs = append(legs, &Order{
+			ID:       fmt.Sprintf("quote_%s_bid_%d", userID, q.count+1),
+			UserID:   userID,
+			Symbol:   ob.Symbol,
+			Side:     Bid,
+			Type:     Limit,
+			Price:    bidPrice,
+			Quantity: bidQty,
+		})
+	}
+	if askQty > 0 {
+		legs = append(legs, &Order{
+			ID:       fmt.Sprintf("quote_%s_ask_%d", userID, q.count+1),
+			UserID:   userID,
+			Symbol:   ob.Symbol,
+			Side:     Ask,
+			Type:     Limit,
+			Price:    askPrice,
+			Quantity: askQty,
+		})
+	}
+	if err := ob.quoteError(q, legs); err != nil {
+		return nil, err
+	}
+
+	// 撤銷舊報價（已成交的部分不受影響）
+	if q.bidID != "" {
+		ob.cancelOrder(q.bidID, UserRequested)
+	}
+	if q.askID != "" {
+		ob.cancelOrder(q.askID, UserRequested)
+	}
+	q.bidID, q.askID = "", ""
+	q.count++
+
+	trades := make([]*Trade, 0)
+	for _, leg := range legs {
+		legTrades, err := ob.placeOrder(leg)
+		if err != nil {
+			return trades, err
+		}
+		trades = append(trades, legTrades...)
+		if leg.Side == Bid {
+			q.bidID = leg.ID
+		} else {
+			q.askID = leg.ID
+		}
+	}
+	return trades, nil
+}
+
+// 暫時移出舊報價，以新條件依序驗證每一邊（與 amendError 相同的檢查），之後放回舊報價；
+// 已通過驗證的一邊暫時計入掛單，讓另一邊的掛單數上限檢查包含它（呼叫者需持有鎖）
+func (ob *OrderBook) quoteError(q *quote, legs []*Order) error {
+	type detached struct {
+		order *Order
+		level *PriceLevel
+		next  *Order
+	}
+	var old []detached
+	for _, id := range []string{q.bidID, q.askID} {
+		o, ok := ob.UnFilledOrders[id]
+		if !ok {
+			continue
+		}
+		level := ob.BidLevels[o.Price]
+		if o.Side == Ask {
+			level = ob.AskLevels[o.Price]
+		}
+		d := detached{order: o, level: level}
+		if e := level.nodes[id].Next(); e != nil {
+			d.next = e.Value.(*Order)
+		}
+		level.RemoveOrder(id)
+		delete(ob.UnFilledOrders, id)
+		old = append(old, d)
+	}
+
+	var err error
+	for _, leg := range legs {
+		if err = ob.amendError(leg); err != nil {
+			err = &OrderError{OrderID: leg.ID, Err: err}
+			break
+		}
+		ob.UnFilledOrders[leg.ID] = leg
+	}
+
+	for _, leg := range legs {
+		delete(ob.UnFilledOrders, leg.ID)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		ob.restoreResting(old[i].order, old[i].level, old[i].next)
+	}
+	return err
+}
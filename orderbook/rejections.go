@@ -0,0 +1,65 @@
+package orderbook
+
+import (
+	"errors"
+	"time"
+)
+
+// 拒單紀錄預設保留筆數
+const defaultRejectionLogLimit = 1000
+
+// RejectionRecord 一筆被拒絕的下單請求
+type RejectionRecord struct {
+	OrderID   string
+	UserID    string
+	Side      OrderSide
+	Type      OrderType
+	Price     Decimal
+	Quantity  Decimal
+	Err       error `json:"-"` // 拒絕原因（哨兵錯誤），可用 errors.Is 判斷
+	Reason    string
+	Timestamp time.Time
+}
+
+// 記錄下單被拒（呼叫者需持有鎖）；未啟用 RejectionLogEnabled 時不做任何事
+func (ob *OrderBook) recordRejection(o *Order, err error) {
+	if !ob.RejectionLogEnabled {
+		return
+	}
+	reason := err
+	var orderErr *OrderError
+	if errors.As(err, &orderErr) {
+		reason = orderErr.Err
+	}
+
+	limit := ob.RejectionLogLimit
+	if limit <= 0 {
+		limit = defaultRejectionLogLimit
+	}
+	ob.rejections = append(ob.rejections, RejectionRecord{
+		OrderID:   o.ID,
+		UserID:    o.UserID,
+		Side:      o.Side,
+		Type:      o.Type,
+		Price:     o.Price,
+		Quantity:  o.Quantity,
+		Err:       reason,
+		Reason:    reason.Error(),
+		Timestamp: ob.Clock.Now(),
+	})
+	if len(ob.rejections) > limit {
+		ob.rejections = ob.rejections[len(ob.rejections)-limit:]
+	}
+}
+
+// RecentRejections 返回最近 limit 筆拒單紀錄（由舊到新），limit <= 0 返回全部保留的紀錄
+func (ob *OrderBook) RecentRejections(limit int) []RejectionRecord {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	records := ob.rejections
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return append([]RejectionRecord(nil), records...)
+}
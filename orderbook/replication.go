@@ -0,0 +1,110 @@
+package orderbook
+
+import "sync"
+
+// 熱備援複製進度
+type replicationState struct {
+	primarySequence uint64 // 主節點最新序號
+	appliedSequence uint64 // 本節點（備援）已套用的序號
+}
+
+// ObservePrimarySequence 由複製通道傳入主節點目前的最新序號
+func (ob *OrderBook) ObservePrimarySequence(seq uint64) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if seq > ob.replication.primarySequence {
+		ob.replication.primarySequence = seq
+	}
+}
+
+// MarkApplied 記錄備援已套用到的序號
+func (ob *OrderBook) MarkApplied(seq uint64) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if seq > ob.replication.appliedSequence {
+		ob.replication.appliedSequence = seq
+	}
+}
+
+// ReplicationLag 返回備援落後主節點的事件數
+func (ob *OrderBook) ReplicationLag() uint64 {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.replicationLag()
+}
+
+func (ob *OrderBook) replicationLag() uint64 {
+	r := ob.replication
+	if r.primarySequence <= r.appliedSequence {
+		return 0
+	}
+	return r.primarySequence - r.appliedSequence
+}
+
+// ReplicationStatus 返回主節點序號、已套用序號及落後量
+func (ob *OrderBook) ReplicationStatus() (primary, applied, lag uint64) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.replication.primarySequence, ob.replication.appliedSequence, ob.replicationLag()
+}
+
+// SequenceGapDetector 備援端的事件消費者：按序號連續套用事件，發現缺口時不套用亂序事件，
+// 而是回報缺口並請求完整重新同步，直到 Resynced 被呼叫前丟棄後續事件。
+// 實作 EventSink，可直接接在事件流上
+type SequenceGapDetector struct {
+	// 套用連續的事件
+	Apply func(ev Event)
+	// 發現缺口時呼叫：expected 為期待的序號，got 為實際收到的序號
+	OnSequenceGap func(expected, got uint64)
+	// 請求完整重新同步（例如重新拉取完整快照）
+	OnResync func()
+
+	mu        sync.Mutex
+	next      uint64
+	resyncing bool
+}
+
+// Publish 接收一個事件；重複的舊事件直接略過，出現缺口時返回 ErrSequenceGap
+func (d *SequenceGapDetector) Publish(ev Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.next == 0 {
+		d.next = 1
+	}
+	if d.resyncing {
+		return ErrSequenceGap
+	}
+	if ev.Sequence < d.next {
+		return nil
+	}
+	if ev.Sequence > d.next {
+		d.resyncing = true
+		if d.OnSequenceGap != nil {
+			d.OnSequenceGap(d.next, ev.Sequence)
+		}
+		if d.OnResync != nil {
+			d.OnResync()
+		}
+		return ErrSequenceGap
+	}
+
+	if d.Apply != nil {
+		d.Apply(ev)
+	}
+	d.next++
+	return nil
+}
+
+// Resynced 完整重新同步到 seq 後呼叫，之後從 seq+1 繼續套用
+func (d *SequenceGapDetector) Resynced(seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.next = seq + 1
+	d.resyncing = false
+}
@@ -0,0 +1,44 @@
+package orderbook
+
+// Requantize 將最小價格變動單位改為 tickSize，所有掛單價格調整到新的價格網格上：
+// 買單向下、賣單向上取整，不會因此變得更積極而與對手盤交叉。
+// 多個舊層級落在同一新價格時合併為一個層級，合併後按原時間優先序號交錯排列，
+// 而不是把舊層級的隊列直接串接。返回因合併而減少的層級數
+func (ob *OrderBook) Requantize(tickSize Decimal) (int, error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if tickSize <= 0 {
+		return 0, ErrInvalidTickSize
+	}
+
+	before := ob.liveLevelCount()
+	orders := make([]*Order, 0, len(ob.UnFilledOrders))
+	for _, level := range ob.BidLevels {
+		for _, o := range level.OrderList() {
+			o.Price = o.Price.FloorTo(tickSize)
+			orders = append(orders, o)
+		}
+	}
+	for _, level := range ob.AskLevels {
+		for _, o := range level.OrderList() {
+			o.Price = o.Price.CeilTo(tickSize)
+			orders = append(orders, o)
+		}
+	}
+
+	ob.TickSize = tickSize
+	ob.rebuildLevels(orders)
+	ob.sequence++
+	ob.afterChange()
+	merged := before - ob.liveLevelCount()
+	// 取整本身不會造成交叉，但原本已鎖定或交叉的掛單在此一併撮合
+	ob.resolveCrosses()
+	return merged, nil
+}
+
+// 非空價格層級總數（呼叫者需持有鎖）
+func (ob *OrderBook) liveLevelCount() int {
+	stats := ob.stats()
+	return stats.BidLevels + stats.AskLevels
+}
@@ -0,0 +1,33 @@
+package orderbook
+
+// 自成交防範：同一用戶的買賣單相遇時的處理方式
+type SelfTradePolicy int
+
+const (
+	STPNone           SelfTradePolicy = iota // 不防範，允許自成交（預設）
+	STPCancelResting                         // 取消掛單，進場訂單繼續與同層級下一筆撮合
+	STPCancelIncoming                        // 取消進場訂單剩餘部分
+	STPCancelBoth                            // 兩筆都取消
+)
+
+// 進場訂單與掛單是否屬於同一用戶且需要防範（未帶 UserID 的訂單不檢查）
+func (ob *OrderBook) selfTrade(taker, maker *Order) bool {
+	return ob.SelfTradePolicy != STPNone && taker != maker && taker.ID != maker.ID &&
+		taker.UserID != "" && taker.UserID == maker.UserID
+}
+
+// 按 SelfTradePolicy 取消相遇的訂單，返回進場訂單是否仍可繼續撮合。
+// 進場訂單被取消時與掛單一樣發布取消事件，並記入拒絕紀錄（呼叫者需持有鎖）
+func (ob *OrderBook) preventSelfTrade(taker, maker *Order) bool {
+	if ob.SelfTradePolicy == STPCancelResting || ob.SelfTradePolicy == STPCancelBoth {
+		ob.cancelOrder(maker.ID, SelfTradePrevented)
+	}
+	if ob.SelfTradePolicy == STPCancelResting {
+		return true
+	}
+	taker.Status = Cancelled
+	taker.CancelReason = SelfTradePrevented
+	ob.recordRejection(taker, ErrSelfTradePrevented)
+	ob.publishEvent(EventOrderCancel, nil, taker)
+	return false
+}
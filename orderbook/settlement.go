@@ -0,0 +1,66 @@
+package orderbook
+
+import "time"
+
+// 結算價的計算方式
+type SettlementMethod int
+
+const (
+	SettleVWAP SettlementMethod = iota // 最近 SettlementWindow 內的成交均價（預設）
+	SettleMid                          // 擷取時的中間價
+)
+
+// Settlement 擷取的結算價，用於逐日盯市
+type Settlement struct {
+	Price      Decimal
+	Method     SettlementMethod
+	CapturedAt time.Time
+}
+
+// SetSettlement 以 SettlementMethod 在 now 擷取結算價，覆蓋前一次的結算價：
+// VWAP 取 (now-SettlementWindow, now] 內的成交，窗口內沒有成交或沒有雙邊報價時返回 ErrNoSettlementPrice
+func (ob *OrderBook) SetSettlement(now time.Time) (Settlement, error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	s := Settlement{Method: ob.SettlementMethod, CapturedAt: now}
+	switch ob.SettlementMethod {
+	case SettleMid:
+		ob.pruneStaleTops()
+		if ob.Bids.Len() == 0 || ob.Asks.Len() == 0 {
+			return Settlement{}, ErrNoSettlementPrice
+		}
+		s.Price = (ob.Bids.Peek().Price + ob.Asks.Peek().Price) / 2
+	default:
+		cutoff := now.Add(-ob.SettlementWindow)
+		var quantity, notional Decimal
+		for i := len(ob.Trades) - 1; i >= 0; i-- {
+			t := ob.Trades[i]
+			if !t.Timestamp.After(cutoff) {
+				break
+			}
+			if t.Timestamp.After(now) {
+				continue
+			}
+			quantity += t.Quantity
+			notional += t.Price.Mul(t.Quantity)
+		}
+		if quantity == 0 {
+			return Settlement{}, ErrNoSettlementPrice
+		}
+		s.Price = notional.Div(quantity)
+	}
+	ob.settlement = &s
+	return s, nil
+}
+
+// SettlementPrice 返回最近一次擷取的結算價，尚未擷取時 ok 為 false
+func (ob *OrderBook) SettlementPrice() (s Settlement, ok bool) {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	if ob.settlement == nil {
+		return Settlement{}, false
+	}
+	return *ob.settlement, true
+}
@@ -0,0 +1,74 @@
+package orderbook
+
+import "time"
+
+// 訂單簿交易狀態
+type BookState int
+
+const (
+	BookNormal   BookState = iota // 正常交易
+	BookDraining                  // 排空：可立即成交的部分照常撮合，剩餘部分不掛單；可撤單
+	BookHalted                    // 暫停：拒絕所有新訂單，只能撤單
+)
+
+// 訂單簿交易狀態的一次變更
+type StateChange struct {
+	From      BookState
+	To        BookState
+	Reason    string
+	Timestamp time.Time
+}
+
+// State 返回訂單簿目前的交易狀態
+func (ob *OrderBook) State() BookState {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.state
+}
+
+// Halted 訂單簿是否已暫停交易
+func (ob *OrderBook) Halted() bool {
+	return ob.State() == BookHalted
+}
+
+// Drain 進入排空狀態，準備受控停機：新訂單只能與現有掛單成交，不再新增掛單，
+// 直到操作員以 ConfirmShutdown 確認停機或以 Resume 恢復
+func (ob *OrderBook) Drain() {
+	ob.setState(BookDraining, "drain requested")
+}
+
+// ConfirmShutdown 確認停機，訂單簿暫停交易
+func (ob *OrderBook) ConfirmShutdown() {
+	ob.setState(BookHalted, "shutdown confirmed")
+}
+
+// Halt 暫停交易並記錄原因，直到 Resume
+func (ob *OrderBook) Halt(reason string) {
+	ob.setState(BookHalted, reason)
+}
+
+// Resume 恢復正常交易
+func (ob *OrderBook) Resume() {
+	ob.setState(BookNormal, "resumed")
+}
+
+func (ob *OrderBook) setState(state BookState, reason string) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ob.transition(state, reason)
+	ob.sequence++
+	// 暫停期間被觸發的停損單在恢復交易後撮合
+	ob.runTriggeredStops()
+	ob.afterChange()
+}
+
+// 切換交易狀態，狀態確實改變時通知 OnStateChange（呼叫者需持有鎖）
+func (ob *OrderBook) transition(state BookState, reason string) {
+	prev := ob.state
+	ob.state = state
+	if prev != state && ob.OnStateChange != nil {
+		ob.OnStateChange(StateChange{From: prev, To: state, Reason: reason, Timestamp: ob.Clock.Now()})
+	}
+}
@@ -0,0 +1,107 @@
+package orderbook
+
+import "time"
+
+// BookStats 訂單簿的層級與掛單數統計
+type BookStats struct {
+	BidLevels      int // 有掛單的買方價格層級數
+	AskLevels      int // 有掛單的賣方價格層級數
+	TotalBidOrders int // 買方掛單數
+	TotalAskOrders int // 賣方掛單數
+	TotalResting   int // 全部掛單數
+}
+
+// Stats 從價格層級映射與未成交訂單計算統計數據。
+// 堆中可能殘留尚未清理的空層級，因此 Bids.Len()/Asks.Len() 會高估層級數，應以此為準
+func (ob *OrderBook) Stats() BookStats {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.stats()
+}
+
+// 計算統計數據（呼叫者需持有鎖）
+func (ob *OrderBook) stats() BookStats {
+	var stats BookStats
+	for _, level := range ob.BidLevels {
+		if !level.isEmpty() {
+			stats.BidLevels++
+		}
+	}
+	for _, level := range ob.AskLevels {
+		if !level.isEmpty() {
+			stats.AskLevels++
+		}
+	}
+	for _, o := range ob.UnFilledOrders {
+		if o.Side == Bid {
+			stats.TotalBidOrders++
+		} else {
+			stats.TotalAskOrders++
+		}
+	}
+	stats.TotalResting = stats.TotalBidOrders + stats.TotalAskOrders
+	return stats
+}
+
+// BookMetrics 訂單簿指標快照，所有欄位在同一次加鎖內取得，彼此一致
+type BookMetrics struct {
+	BookStats
+	BestBid     Decimal
+	BestAsk     Decimal
+	Mid         Decimal // 單邊或空簿為 0
+	Spread      Decimal // 單邊或空簿為 0
+	LastPrice   Decimal // 尚無成交為 0
+	Volume24h   Decimal // 最近 24 小時成交數量
+	TotalTrades int
+	Cancels     CancelLevelStats
+}
+
+// CancelLevelStats 撤單時所在價格層級的掛單數統計，用於發現過度擁擠的層級
+type CancelLevelStats struct {
+	Count        int     // 撤單次數
+	MaxLevelSize int     // 撤單時遇到的最大層級掛單數
+	AvgLevelSize float64 // 撤單時層級掛單數的平均值
+	totalSize    int
+}
+
+// 記錄一次撤單時層級的掛單數（呼叫者需持有鎖）
+func (s *CancelLevelStats) record(levelSize int) {
+	s.Count++
+	s.totalSize += levelSize
+	if levelSize > s.MaxLevelSize {
+		s.MaxLevelSize = levelSize
+	}
+	s.AvgLevelSize = float64(s.totalSize) / float64(s.Count)
+}
+
+// CancelLevelStats 返回撤單層級統計
+func (ob *OrderBook) CancelLevelStats() CancelLevelStats {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.cancelStats
+}
+
+// Metrics 返回訂單簿的指標快照
+func (ob *OrderBook) Metrics() BookMetrics {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	m := BookMetrics{BookStats: ob.stats(), TotalTrades: len(ob.Trades), Cancels: ob.cancelStats}
+	if bids := ob.sortedLevels(Bid); len(bids) > 0 {
+		m.BestBid = bids[0].Price
+	}
+	if asks := ob.sortedLevels(Ask); len(asks) > 0 {
+		m.BestAsk = asks[0].Price
+	}
+	if m.BestBid != 0 && m.BestAsk != 0 {
+		m.Mid = (m.BestBid + m.BestAsk) / 2
+		m.Spread = m.BestAsk - m.BestBid
+	}
+	if len(ob.Trades) > 0 {
+		m.LastPrice = ob.Trades[len(ob.Trades)-1].Price
+	}
+	m.Volume24h, _ = ob.volumeInWindow(24 * time.Hour)
+	return m
+}
@@ -0,0 +1,66 @@
+package orderbook
+
+import "time"
+
+// 最佳價格顯示暫留狀態（僅影響顯示，不影響撮合）
+type stickySide struct {
+	last   Decimal   // 上一次的實際最佳價
+	lastOK bool      // 上一次是否有最佳價
+	held   Decimal   // 暫留顯示的價格
+	until  time.Time // 暫留截止時間
+}
+
+type stickyState struct {
+	bid stickySide
+	ask stickySide
+}
+
+// 每次狀態變更後更新衍生狀態：最佳價格暫留及深度增量紀錄（呼叫者需持有鎖）
+func (ob *OrderBook) afterChange() {
+	ob.updateSticky()
+	ob.recordDepthDelta()
+}
+
+// 在每次狀態變更後更新暫留狀態（呼叫者需持有鎖）
+// 最佳價格層級被清空（價格變差或該邊變空）時，暫留舊價格 StickyBestWindow；價格改善時立即更新
+func (ob *OrderBook) updateSticky() {
+	if ob.StickyBestWindow <= 0 {
+		return
+	}
+	now := ob.Clock.Now()
+
+	var bid Decimal
+	bidOK := ob.Bids.Len() > 0
+	if bidOK {
+		bid = ob.Bids.Peek().Price
+	}
+	var ask Decimal
+	askOK := ob.Asks.Len() > 0
+	if askOK {
+		ask = ob.Asks.Peek().Price
+	}
+
+	ob.sticky.bid.update(bid, bidOK, func(prev, cur Decimal) bool { return cur < prev }, now, ob.StickyBestWindow)
+	ob.sticky.ask.update(ask, askOK, func(prev, cur Decimal) bool { return cur > prev }, now, ob.StickyBestWindow)
+}
+
+func (s *stickySide) update(price Decimal, ok bool, worse func(prev, cur Decimal) bool, now time.Time, window time.Duration) {
+	if s.lastOK && (!ok || worse(s.last, price)) {
+		// 只在沒有暫留中時記錄，連續清空多個層級時保留最早的價格
+		if !now.Before(s.until) {
+			s.held = s.last
+			s.until = now.Add(window)
+		}
+	} else if ok && s.lastOK && price != s.last {
+		s.until = time.Time{}
+	}
+	s.last, s.lastOK = price, ok
+}
+
+// 顯示用價格：暫留期間返回舊價格
+func (s *stickySide) display(price Decimal, ok bool, now time.Time) (Decimal, bool) {
+	if now.Before(s.until) {
+		return s.held, true
+	}
+	return price, ok
+}
@@ -0,0 +1,97 @@
+package orderbook
+
+// 停損限價單是否已被成交價觸發：買單在成交價漲到 StopPrice 以上、賣單跌到 StopPrice 以下時觸發
+func (o *Order) stopTriggered(price Decimal) bool {
+	if o.Side == Bid {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+// 暫存尚未觸發的停損限價單，不進入撮合（呼叫者需持有鎖）
+func (ob *OrderBook) holdStop(o *Order) {
+	ob.stopOrders = append(ob.stopOrders, o)
+}
+
+// 每筆成交後以成交價檢查停損單，被觸發的按下單順序移入待處理佇列；
+// 實際撮合由 runTriggeredStops 在目前訂單處理完後進行，避免在撮合迴圈中重入（呼叫者需持有鎖）
+func (ob *OrderBook) checkStops(price Decimal) {
+	pending := ob.stopOrders[:0]
+	for _, o := range ob.stopOrders {
+		if o.stopTriggered(price) {
+			ob.triggeredStops = append(ob.triggeredStops, o)
+		} else {
+			pending = append(pending, o)
+		}
+	}
+	clear(ob.stopOrders[len(pending):])
+	ob.stopOrders = pending
+}
+
+// 將已觸發的停損單轉為限價單撮合，無法完全成交的部分照常掛單；
+// 其成交可能再觸發其他停損單，直到佇列清空。每條撮合路徑（下單、改單、集合競價、重新掛鉤、
+// 恢復交易）結束時都會呼叫；集合競價或暫停期間留待之後處理（呼叫者需持有鎖）
+func (ob *OrderBook) runTriggeredStops() {
+	for len(ob.triggeredStops) > 0 && !ob.AuctionMode && ob.state != BookHalted {
+		o := ob.triggeredStops[0]
+		ob.triggeredStops[0] = nil
+		ob.triggeredStops = ob.triggeredStops[1:]
+
+		o.Type = Limit
+		ob.sequence++
+		ob.recordSubmissionMid(o)
+		if _, err := ob.processLimitOrder(o); err != nil {
+			ob.recordRejection(o, err)
+		}
+		ob.publishEvent(EventOrderUpdate, nil, o)
+	}
+}
+
+// 取消尚未轉為限價單的停損單（未觸發或已觸發待撮合），找不到時返回 false（呼叫者需持有鎖）
+func (ob *OrderBook) cancelStop(orderID string, reason CancelReason) bool {
+	for _, queue := range []*[]*Order{&ob.stopOrders, &ob.triggeredStops} {
+		for i, o := range *queue {
+			if o.ID != orderID {
+				continue
+			}
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			o.Status = Cancelled
+			o.CancelReason = reason
+			ob.sequence++
+			ob.publishEvent(EventOrderCancel, nil, o)
+			return true
+		}
+	}
+	return false
+}
+
+// 是否有同ID的停損單尚未轉為限價單（呼叫者需持有鎖）
+func (ob *OrderBook) hasStop(orderID string) bool {
+	for _, o := range ob.stopOrders {
+		if o.ID == orderID {
+			return true
+		}
+	}
+	for _, o := range ob.triggeredStops {
+		if o.ID == orderID {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingStopOrders 返回尚未轉為限價單的停損單：先是未觸發的（按下單順序），
+// 其後是已觸發、等待集合競價或暫停結束後撮合的（按觸發順序）
+func (ob *OrderBook) PendingStopOrders() []Order {
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	orders := make([]Order, 0, len(ob.stopOrders)+len(ob.triggeredStops))
+	for _, o := range ob.stopOrders {
+		orders = append(orders, *o)
+	}
+	for _, o := range ob.triggeredStops {
+		orders = append(orders, *o)
+	}
+	return orders
+}
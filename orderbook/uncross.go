@@ -0,0 +1,43 @@
+package orderbook
+
+// 改單、重新量化等直接修改掛單的操作後，若訂單簿出現交叉（最佳買價 >= 最佳賣價，
+// CrossOnEqual 為 false 時為 >），立即撮合交叉部分：較晚進入隊列的一方視為剛到達的主動方，
+// 以先到一方（掛單方）的價格成交。集合競價模式或暫停時不處理（呼叫者需持有鎖）
+func (ob *OrderBook) resolveCrosses() []*Trade {
+	if !ob.AutoUncross || ob.AuctionMode || ob.state == BookHalted {
+		return nil
+	}
+
+	var trades []*Trade
+	for {
+		ob.pruneStaleTops()
+		if ob.Bids.Len() == 0 || ob.Asks.Len() == 0 {
+			break
+		}
+		bestBid, bestAsk := ob.Bids.Peek(), ob.Asks.Peek()
+		if bestBid.Price < bestAsk.Price || (bestBid.Price == bestAsk.Price && !ob.CrossOnEqual) {
+			break
+		}
+
+		buy, sell := bestBid.Front(), bestAsk.Front()
+		aggressor, price := Bid, bestAsk.Price
+		if sell.priority > buy.priority {
+			aggressor, price = Ask, bestBid.Price
+		}
+		trade := ob.matchOrders(buy, sell, price, aggressor)
+		if trade == nil {
+			break
+		}
+		ob.recordTrade(trade, buy, sell)
+		trades = append(trades, trade)
+
+		ob.cleanupPriceLevel(bestBid, true)
+		ob.cleanupPriceLevel(bestAsk, false)
+	}
+	if len(trades) > 0 {
+		ob.runTriggeredStops()
+		ob.sequence++
+		ob.afterChange()
+	}
+	return trades
+}
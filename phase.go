@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// 市場階段
+type Phase int
+
+const (
+	Continuous Phase = iota // 連續競價（預設）
+	PreOpen                 // 開盤前集合競價，訂單進入競價簿
+	Closed                  // 休市，不接受新訂單
+)
+
+// Phase 返回交易對目前的市場階段
+func (ex *Exchange) Phase(symbol orderbook.Symbol) Phase {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	return ex.phases[symbol]
+}
+
+// 按市場階段選擇下單的訂單簿
+func (ex *Exchange) routeOrderBook(symbol orderbook.Symbol) (*orderbook.OrderBook, error) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	ob, ok := ex.OrderBooks[symbol]
+	if !ok {
+		return nil, orderbook.ErrUnknownSymbol
+	}
+	switch ex.phases[symbol] {
+	case PreOpen:
+		return ex.auctionBooks[symbol], nil
+	case Closed:
+		return nil, orderbook.ErrBookHalted
+	default:
+		return ob, nil
+	}
+}
+
+// SetPhase 切換交易對的市場階段。進入 PreOpen 時以連續競價簿的設定建立競價簿；
+// 從 PreOpen 切換到 Continuous 時執行集合競價，並將未成交的訂單及停損單移入連續競價簿
+// （被集合競價成交價觸發的停損單轉為限價單進場）；從 PreOpen 切換到 Closed 時取消競價簿中的所有訂單。
+// 移入時被連續競價簿拒絕的訂單（狀態為 Cancelled）的錯誤以 errors.Join 合併返回，階段仍會切換。
+// 切換完成前同一交易對的下單會等待
+func (ex *Exchange) SetPhase(symbol orderbook.Symbol, phase Phase) (orderbook.AuctionResult, error) {
+	lock, ok := ex.phaseLocks[symbol]
+	if !ok {
+		return orderbook.AuctionResult{}, orderbook.ErrUnknownSymbol
+	}
+	// 整個切換期間阻擋下單，避免新訂單在集合競價的訂單移入前進入連續競價簿
+	lock.Lock()
+	defer lock.Unlock()
+
+	ex.mutex.Lock()
+	ob := ex.OrderBooks[symbol]
+	prev := ex.phases[symbol]
+	ex.phases[symbol] = phase
+	if prev != phase {
+		ex.recordTransition(symbol, StateTransition{
+			Kind:      PhaseTransition,
+			From:      phaseName(prev),
+			To:        phaseName(phase),
+			Reason:    phaseChangeReason(prev, phase),
+			Timestamp: ex.Clock.Now(),
+		})
+	}
+
+	if phase == PreOpen && prev != PreOpen {
+		auction := ob.CloneConfig()
+		auction.AuctionMode = true
+		auction.OpeningAuctionWindow = 0
+		ex.auctionBooks[symbol] = auction
+	}
+	auction := ex.auctionBooks[symbol]
+	if prev == PreOpen && phase != PreOpen {
+		if phase != Continuous {
+			// 未執行集合競價就離開 PreOpen：取消競價簿中的訂單，而不是隨競價簿一起丟棄
+			auction.CancelAll(orderbook.AuctionCancelled)
+		}
+		delete(ex.auctionBooks, symbol)
+	}
+	ex.mutex.Unlock()
+
+	var result orderbook.AuctionResult
+	var errs []error
+	if prev == PreOpen && phase == Continuous {
+		result = auction.RunAuction()
+		for _, o := range auction.DrainOrders() {
+			filled := o.FilledQuantity
+			if _, err := ob.PlaceOrder(o); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if o.Status == orderbook.Pending && filled > 0 {
+				o.Status = orderbook.Partial
+			}
+		}
+		for _, o := range auction.DrainStopOrders() {
+			if _, err := ob.PlaceOrder(o); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// 市場階段名稱
+func phaseName(phase Phase) string {
+	switch phase {
+	case Continuous:
+		return "連續競價"
+	case PreOpen:
+		return "開盤前集合競價"
+	case Closed:
+		return "休市"
+	default:
+		return "未知階段"
+	}
+}
+
+// 階段變更的原因說明
+func phaseChangeReason(prev, phase Phase) string {
+	switch {
+	case phase == PreOpen:
+		return "pre-open auction started"
+	case prev == PreOpen && phase == Continuous:
+		return "opening auction completed"
+	case phase == Closed:
+		return "market closed"
+	default:
+		return "trading reopened"
+	}
+}
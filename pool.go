@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// 撮合池已關閉，不再接受請求
+var ErrPoolClosed = errors.New("matching pool closed")
+
+// 每批最多處理的請求數，避免單一交易對長時間佔用訂單簿的鎖
+const poolMaxBatch = 256
+
+// 撮合池的請求：下單或讀取快照，結果經由 reply 返回
+type poolRequest struct {
+	order    *orderbook.Order
+	snapshot bool
+	reply    chan poolResult
+}
+
+type poolResult struct {
+	trades   []*orderbook.Trade
+	err      error
+	snapshot orderbook.BookSnapshot
+}
+
+// 回覆通道可重複使用，避免每筆請求配置
+var replyPool = sync.Pool{New: func() any { return make(chan poolResult, 1) }}
+
+// MatchingPool 每個交易對由單一 goroutine 按佇列順序處理下單與快照讀取（單一寫入者）：
+// 處理 goroutine 一次取出佇列中累積的訂單，在一次持有訂單簿鎖的情況下批次撮合，
+// 熱路徑上不再有多個寫入者爭用同一個鎖；不同交易對之間並行撮合。
+// 建立後 Exchange.PlaceOrder 經由撮合池下單，Close 後恢復直接下單
+type MatchingPool struct {
+	ex     *Exchange
+	queues map[orderbook.Symbol]chan poolRequest
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex // 保護 closed，避免向已關閉的佇列送出請求
+	closed bool
+}
+
+// NewMatchingPool 為每個交易對啟動一個處理 goroutine，queueSize 為每個交易對的佇列長度
+func NewMatchingPool(ex *Exchange, queueSize int) *MatchingPool {
+	p := &MatchingPool{
+		ex:     ex,
+		queues: make(map[orderbook.Symbol]chan poolRequest, len(ex.OrderBooks)),
+	}
+	for symbol := range ex.OrderBooks {
+		queue := make(chan poolRequest, queueSize)
+		p.queues[symbol] = queue
+		p.wg.Add(1)
+		go p.run(symbol, queue)
+	}
+
+	ex.pool.Store(p)
+	return p
+}
+
+// 處理交易對的佇列：每次取出目前累積的請求（最多 poolMaxBatch 筆）一起處理
+func (p *MatchingPool) run(symbol orderbook.Symbol, queue chan poolRequest) {
+	defer p.wg.Done()
+	batch := make([]poolRequest, 0, poolMaxBatch)
+	for req := range queue {
+		batch = append(batch[:0], req)
+		batch = collect(queue, batch)
+		if len(batch) == 1 {
+			// 讓出處理器，讓其他等待中的下單者先送出請求，累積成批
+			runtime.Gosched()
+			batch = collect(queue, batch)
+		}
+		p.process(symbol, batch)
+	}
+}
+
+// 不阻塞地取出佇列中已有的請求，直到批次已滿
+func collect(queue chan poolRequest, batch []poolRequest) []poolRequest {
+	for len(batch) < poolMaxBatch {
+		select {
+		case req, ok := <-queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// 按佇列順序處理一批請求：連續的下單合併為一次批次撮合，快照在之前的下單完成後讀取
+func (p *MatchingPool) process(symbol orderbook.Symbol, batch []poolRequest) {
+	start := 0
+	for i, req := range batch {
+		if !req.snapshot {
+			continue
+		}
+		p.placeBatch(symbol, batch[start:i])
+		start = i + 1
+		var result poolResult
+		if ob, ok := p.ex.OrderBooks[symbol]; ok {
+			result.snapshot = ob.FullSnapshot()
+		}
+		req.reply <- result
+	}
+	p.placeBatch(symbol, batch[start:])
+}
+
+// 批次下單：與 Exchange.PlaceOrder 相同的階段路由及檢查流程，
+// 整批只取一次階段切換讀鎖、路由、檢查清單及訂單簿的鎖，並只推進一次括號單
+func (p *MatchingPool) placeBatch(symbol orderbook.Symbol, batch []poolRequest) {
+	if len(batch) == 0 {
+		return
+	}
+	ex := p.ex
+	lock := ex.phaseLocks[symbol]
+	lock.RLock()
+	ob, routeErr := ex.routeOrderBook(symbol)
+	pipeline := ex.validatorPipeline(symbol)
+	orders := make([]*orderbook.Order, 0, len(batch))
+	accepted := make([]poolRequest, 0, len(batch))
+	for _, req := range batch {
+		if routeErr != nil {
+			req.reply <- poolResult{err: &orderbook.OrderError{OrderID: req.order.ID, Err: routeErr}}
+			continue
+		}
+		if err := screenOrder(pipeline, req.order); err != nil {
+			req.reply <- poolResult{err: err}
+			continue
+		}
+		orders = append(orders, req.order)
+		accepted = append(accepted, req)
+	}
+	var results []orderbook.PlaceResult
+	if len(orders) > 0 {
+		results = ob.PlaceOrders(orders)
+	}
+	lock.RUnlock()
+
+	ex.processBrackets()
+	for i, req := range accepted {
+		req.reply <- poolResult{trades: results[i].Trades, err: results[i].Err}
+	}
+}
+
+// 送出請求並等待結果
+func (p *MatchingPool) submit(symbol orderbook.Symbol, req poolRequest) (poolResult, error) {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return poolResult{}, ErrPoolClosed
+	}
+	queue, ok := p.queues[symbol]
+	if !ok {
+		p.mu.RUnlock()
+		return poolResult{}, orderbook.ErrUnknownSymbol
+	}
+	req.reply = replyPool.Get().(chan poolResult)
+	queue <- req
+	p.mu.RUnlock()
+
+	result := <-req.reply
+	replyPool.Put(req.reply)
+	return result, nil
+}
+
+// PlaceOrder 經由交易對的處理 goroutine 下單，遵循市場階段路由及檢查流程
+func (p *MatchingPool) PlaceOrder(o *orderbook.Order) ([]*orderbook.Trade, error) {
+	result, err := p.submit(o.Symbol, poolRequest{order: o})
+	if err != nil {
+		return nil, &orderbook.OrderError{OrderID: o.ID, Err: err}
+	}
+	return result.trades, result.err
+}
+
+// Snapshot 經由處理 goroutine 取得訂單簿快照，與該交易對的下單按佇列順序排列
+func (p *MatchingPool) Snapshot(symbol orderbook.Symbol) (orderbook.BookSnapshot, error) {
+	result, err := p.submit(symbol, poolRequest{snapshot: true})
+	return result.snapshot, err
+}
+
+// Close 停止接收請求並等待佇列中的請求處理完畢，可重複呼叫；之後的請求返回 ErrPoolClosed
+func (p *MatchingPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	p.ex.pool.CompareAndSwap(p, nil)
+}
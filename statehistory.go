@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/clary-work01/crypto_exchange/orderbook"
+)
+
+// 狀態變更的種類
+type TransitionKind string
+
+const (
+	PhaseTransition     TransitionKind = "phase"      // 市場階段變更（SetPhase）
+	BookStateTransition TransitionKind = "book_state" // 訂單簿交易狀態變更（暫停、排空、恢復）
+)
+
+// StateTransition 交易對的一次階段或交易狀態變更，From/To 為狀態名稱
+type StateTransition struct {
+	Kind      TransitionKind
+	From      string
+	To        string
+	Reason    string
+	Timestamp time.Time
+}
+
+// SymbolStateHistory 返回交易對所有階段及交易狀態變更，按發生順序排列，用於追查撮合停止的原因
+func (ex *Exchange) SymbolStateHistory(symbol orderbook.Symbol) []StateTransition {
+	ex.stateMu.Lock()
+	defer ex.stateMu.Unlock()
+
+	return append([]StateTransition(nil), ex.stateHistory[symbol]...)
+}
+
+func (ex *Exchange) recordTransition(symbol orderbook.Symbol, t StateTransition) {
+	ex.stateMu.Lock()
+	defer ex.stateMu.Unlock()
+
+	ex.stateHistory[symbol] = append(ex.stateHistory[symbol], t)
+}
+
+// 記錄訂單簿自身的交易狀態變更（包括價格帶異常觸發的暫停）
+func (ex *Exchange) watchBookState(symbol orderbook.Symbol, ob *orderbook.OrderBook) {
+	ob.OnStateChange = func(c orderbook.StateChange) {
+		ex.recordTransition(symbol, StateTransition{
+			Kind:      BookStateTransition,
+			From:      orderbook.GetBookStateName(c.From),
+			To:        orderbook.GetBookStateName(c.To),
+			Reason:    c.Reason,
+			Timestamp: c.Timestamp,
+		})
+	}
+}